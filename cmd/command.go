@@ -1,20 +1,26 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"net"
 	"os"
 	"os/exec"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/loft-sh/devpod-provider-aws/pkg/aws"
+	"github.com/loft-sh/devpod-provider-aws/pkg/options"
 	"github.com/loft-sh/devpod/pkg/log"
 	"github.com/loft-sh/devpod/pkg/provider"
 	"github.com/loft-sh/devpod/pkg/ssh"
 	devssh "github.com/loft-sh/devpod/pkg/ssh"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 // CommandCmd holds the cmd flags
@@ -26,14 +32,14 @@ func NewCommandCmd() *cobra.Command {
 	commandCmd := &cobra.Command{
 		Use:   "command",
 		Short: "Command an instance",
-		RunE: func(_ *cobra.Command, args []string) error {
-			awsProvider, err := aws.NewProvider(context.Background(), log.Default)
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			awsProvider, err := aws.NewProvider(cobraCmd.Context(), log.Default)
 			if err != nil {
 				return err
 			}
 
 			return cmd.Run(
-				context.Background(),
+				cobraCmd.Context(),
 				awsProvider,
 				provider.FromEnvironment(),
 				log.Default,
@@ -56,36 +62,77 @@ func (cmd *CommandCmd) Run(
 		return fmt.Errorf("command environment variable is missing")
 	}
 
-	// get private key
-	privateKey, err := ssh.GetPrivateKeyRawBase(providerAws.Config.MachineFolder)
-	if err != nil {
-		return fmt.Errorf("load private key: %w", err)
-	}
+	// stdout carries the output of the command, so only log to stderr
+	logs = logs.ErrorStreamOnly()
 
-	// get instance
-	instance, err := aws.GetDevpodRunningInstance(
+	// the newest instance, like every other subcommand
+	instances, err := aws.GetDevpodInstance(
 		ctx,
-		providerAws.AwsConfig,
+		providerAws.EC2,
 		providerAws.Config.MachineID,
 	)
 	if err != nil {
 		return err
-	} else if len(instance.Reservations) == 0 {
+	} else if len(instances.Reservations) == 0 {
 		return fmt.Errorf("instance %s doesn't exist", providerAws.Config.MachineID)
 	}
 
+	instance := instances.Reservations[0].Instances[0]
+	if instance.State.Name != types.InstanceStateNameRunning {
+		return fmt.Errorf("instance %s is %s, start it first", providerAws.Config.MachineID, instance.State.Name)
+	}
+
+	growFilesystem := reconcileDiskSize(ctx, providerAws, instance, logs)
+
+	client, closeClient, err := connectInstance(ctx, providerAws, instance, logs)
+	if err != nil {
+		// e.g. the user data failed before the SSH user was set up
+		printConsoleTail(providerAws.EC2, *instance.InstanceId, logs)
+		return err
+	}
+	defer closeClient()
+
+	if growFilesystem {
+		err := growRootFilesystem(ctx, client, logs)
+		if err != nil {
+			logs.Warnf("Unable to grow the root filesystem: %v", err)
+			recordDiskSizeFailure(providerAws, logs)
+		} else {
+			err = aws.SetDiskSizeReconciled(providerAws.Config.MachineFolder, providerAws.Config.DiskSizeGB)
+			if err != nil {
+				logs.Debugf("error storing disk size: %v", err)
+			}
+		}
+	}
+
+	return ssh.Run(ctx, client, command, os.Stdin, os.Stdout, os.Stderr)
+}
+
+// connectInstance opens an SSH connection to the instance, through the
+// instance connect endpoint if enabled, otherwise to its public or private
+// IP. The returned function closes the connection.
+func connectInstance(
+	ctx context.Context,
+	providerAws *aws.AwsProvider,
+	instance types.Instance,
+	logs log.Logger,
+) (*gossh.Client, func(), error) {
+	// get private key
+	privateKey, err := ssh.GetPrivateKeyRawBase(providerAws.Config.MachineFolder)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load private key: %w", err)
+	}
+
 	if providerAws.Config.UseInstanceConnectEndpoint {
-		instanceID := *instance.Reservations[0].Instances[0].InstanceId
+		instanceID := *instance.InstanceId
 		endpointID := providerAws.Config.InstanceConnectEndpointID
 
-		var err error
 		port, err := findAvailablePort()
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 		addr := "localhost:" + port
 		cancelCtx, cancel := context.WithCancel(ctx)
-		defer cancel()
 		connectArgs := []string{
 			"ec2-instance-connect",
 			"open-tunnel",
@@ -98,82 +145,190 @@ func (cmd *CommandCmd) Run(
 		cmd := exec.CommandContext(cancelCtx, "aws", connectArgs...)
 		// open tunnel in background
 		if err = cmd.Start(); err != nil {
-			return fmt.Errorf("start tunnel: %w", err)
+			cancel()
+			return nil, nil, fmt.Errorf("start tunnel: %w", err)
 		}
-		defer func() {
-			err = cmd.Process.Kill()
+
+		// the tunnel exits on its own e.g. if the endpoint is not reachable
+		var tunnelErr error
+		tunnelExited := make(chan struct{})
+		go func() {
+			tunnelErr = cmd.Wait()
+			close(tunnelExited)
 		}()
 
+		closeTunnel := func() {
+			err := cmd.Process.Kill()
+			if err != nil && !errors.Is(err, os.ErrProcessDone) {
+				logs.Warnf("Unable to stop the instance connect tunnel: %v", err)
+			}
+
+			cancel()
+			<-tunnelExited
+		}
+
 		timeoutCtx, cancelFn := context.WithTimeout(ctx, 30*time.Second)
 		defer cancelFn()
-		waitForPort(timeoutCtx, addr)
-
-		client, err := devssh.NewSSHClient("devpod", addr, privateKey)
+		err = waitForPort(timeoutCtx, addr, tunnelExited)
 		if err != nil {
-			return err
+			closeTunnel()
+			if tunnelErr != nil {
+				err = tunnelErr
+			}
+
+			return nil, nil, fmt.Errorf("open tunnel to %s: %w", instanceID, err)
 		}
 
-		err = devssh.Run(ctx, client, command, os.Stdin, os.Stdout, os.Stderr)
+		client, err := devssh.NewSSHClient("devpod", addr, privateKey)
 		if err != nil {
-			return err
+			closeTunnel()
+			return nil, nil, err
 		}
 
-		return err
+		return client, func() {
+			_ = client.Close()
+			closeTunnel()
+		}, nil
 	}
 
 	// try public ip
-	if instance.Reservations[0].Instances[0].PublicIpAddress != nil {
-		ip := *instance.Reservations[0].Instances[0].PublicIpAddress
+	if instance.PublicIpAddress != nil {
+		ip := *instance.PublicIpAddress
 
 		sshClient, err := ssh.NewSSHClient("devpod", ip+":22", privateKey)
 		if err != nil {
 			logs.Debugf("error connecting to public ip [%s]: %v", ip, err)
 		} else {
 			// successfully connected to the public ip
-			defer sshClient.Close()
-
-			return ssh.Run(ctx, sshClient, command, os.Stdin, os.Stdout, os.Stderr)
+			return sshClient, func() { _ = sshClient.Close() }, nil
 		}
 	}
 
 	// try private ip
-	if instance.Reservations[0].Instances[0].PrivateIpAddress != nil {
-		ip := *instance.Reservations[0].Instances[0].PrivateIpAddress
+	if instance.PrivateIpAddress != nil {
+		ip := *instance.PrivateIpAddress
 
 		sshClient, err := ssh.NewSSHClient("devpod", ip+":22", privateKey)
 		if err != nil {
 			logs.Debugf("error connecting to private ip [%s]: %v", ip, err)
 		} else {
 			// successfully connected to the private ip
-			defer sshClient.Close()
-
-			return ssh.Run(ctx, sshClient, command, os.Stdin, os.Stdout, os.Stderr)
+			return sshClient, func() { _ = sshClient.Close() }, nil
 		}
 	}
 
-	return fmt.Errorf(
+	return nil, nil, fmt.Errorf(
 		"instance %s is not reachable",
 		providerAws.Config.MachineID,
 	)
 }
 
-func waitForPort(ctx context.Context, addr string) {
+// reconcileDiskSize grows the root volume if AWS_DISK_SIZE was raised since
+// it was last grown. It returns whether the filesystem needs to be grown,
+// i.e. the volume was grown now or by a previous start.
+func reconcileDiskSize(
+	ctx context.Context,
+	providerAws *aws.AwsProvider,
+	instance types.Instance,
+	logs log.Logger,
+) bool {
+	config := providerAws.Config
+	if config.DiskSizeGB <= 0 || aws.IsDiskSizeReconciled(config.MachineFolder, config.DiskSizeGB) {
+		return false
+	}
+
+	modified, err := aws.GrowRootVolume(ctx, providerAws.EC2, instance, config.DiskSizeGB, logs)
+
+	var rateErr *aws.VolumeModificationRateError
+	switch {
+	case errors.Is(err, aws.ErrVolumeShrink):
+		logs.Warn(err)
+
+		// only report a refused shrink once
+		err = aws.SetDiskSizeReconciled(config.MachineFolder, config.DiskSizeGB)
+		if err != nil {
+			logs.Debugf("error storing disk size: %v", err)
+		}
+
+		return false
+	case errors.As(err, &rateErr):
+		// not a failure, the next command tries again
+		logs.Warnf("Unable to grow the root volume yet: %v", err)
+
+		return false
+	case err != nil:
+		logs.Warnf("Unable to grow the root volume: %v", err)
+		recordDiskSizeFailure(providerAws, logs)
+
+		return false
+	case modified:
+		err = aws.SetFilesystemGrowPending(config.MachineFolder, config.DiskSizeGB)
+		if err != nil {
+			logs.Debugf("error storing disk size: %v", err)
+		}
+
+		return true
+	case aws.IsFilesystemGrowPending(config.MachineFolder, config.DiskSizeGB):
+		return true
+	}
+
+	// the volume was created with the disk size
+	err = aws.SetDiskSizeReconciled(config.MachineFolder, config.DiskSizeGB)
+	if err != nil {
+		logs.Debugf("error storing disk size: %v", err)
+	}
+
+	return false
+}
+
+// recordDiskSizeFailure records a failed attempt to grow the root volume or
+// filesystem, so it is not retried by every command
+func recordDiskSizeFailure(providerAws *aws.AwsProvider, logs log.Logger) {
+	config := providerAws.Config
+
+	gaveUp, err := aws.RecordDiskSizeFailure(config.MachineFolder, config.DiskSizeGB)
+	if err != nil {
+		logs.Debugf("error storing disk size: %v", err)
+	} else if gaveUp {
+		logs.Warnf("Giving up growing the disk to %dGB, change %s to try again", config.DiskSizeGB, options.AWS_DISK_SIZE)
+	}
+}
+
+// growRootFilesystem grows the root partition and filesystem to the size of
+// the root volume
+func growRootFilesystem(ctx context.Context, client *gossh.Client, logs log.Logger) error {
+	output := &bytes.Buffer{}
+	err := ssh.Run(ctx, client, "sudo sh -s", strings.NewReader(aws.GrowRootFilesystemScript), output, output)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(output.String()))
+	}
+
+	logs.Debugf("grew root filesystem: %s", strings.TrimSpace(output.String()))
+
+	return nil
+}
+
+// waitForPort waits until addr is listened on, it fails if the context is
+// done or the tunnel exits first
+func waitForPort(ctx context.Context, addr string, tunnelExited <-chan struct{}) error {
 	for {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			// port is taken
+			return nil
+		}
+		_ = l.Close()
+
 		select {
 		case <-ctx.Done():
-			return
-		default:
-			l, err := net.Listen("tcp", addr)
-			if err != nil {
-				// port is taken
-				return
-			}
-			_ = l.Close()
-			time.Sleep(1 * time.Second)
+			return ctx.Err()
+		case <-tunnelExited:
+			return fmt.Errorf("tunnel exited")
+		case <-time.After(time.Second):
 		}
 	}
-
 }
+
 func findAvailablePort() (string, error) {
 	l, err := net.Listen("tcp", ":0")
 	if err != nil {
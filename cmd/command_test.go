@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/sirupsen/logrus"
+)
+
+func TestCommandCmd(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		command   string
+		instances []types.Instance
+		err       string
+	}{
+		{
+			name: "missing command",
+			err:  "command environment variable is missing",
+		},
+		{
+			name:    "no instance",
+			command: "echo hello",
+			err:     "instance devpod-test doesn't exist",
+		},
+		{
+			name:      "stopped instance",
+			command:   "echo hello",
+			instances: []types.Instance{withState(fake.Instance("i-1", testMachineID, now), types.InstanceStateNameStopped)},
+			err:       "instance devpod-test is stopped, start it first",
+		},
+		{
+			name:      "instance without address",
+			command:   "echo hello",
+			instances: []types.Instance{fake.Instance("i-1", testMachineID, now)},
+			err:       "instance devpod-test is not reachable",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("COMMAND", test.command)
+
+			ec2Client := fake.NewDefaultEC2()
+			ec2Client.Instances = test.instances
+
+			providerAws := newTestProvider(t, ec2Client)
+			err := (&CommandCmd{}).Run(context.Background(), providerAws, testMachine(providerAws), testLog)
+			checkError(t, err, test.err)
+		})
+	}
+}
+
+func TestCommandCmdKeepsDuplicates(t *testing.T) {
+	t.Setenv("COMMAND", "echo hello")
+
+	now := time.Now()
+	ec2Client := fake.NewDefaultEC2()
+	ec2Client.Instances = []types.Instance{
+		fake.Instance("i-old", testMachineID, now.Add(-time.Minute)),
+		fake.Instance("i-new", testMachineID, now),
+	}
+
+	// duplicates are only terminated by create and start
+	providerAws := newTestProvider(t, ec2Client)
+	providerAws.Config.TerminateDuplicates = true
+	err := (&CommandCmd{}).Run(context.Background(), providerAws, testMachine(providerAws), testLog)
+	checkError(t, err, "instance devpod-test is not reachable")
+
+	for _, instance := range ec2Client.Instances {
+		if instance.State.Name == types.InstanceStateNameTerminated {
+			t.Errorf("expected instance %s to be kept", *instance.InstanceId)
+		}
+	}
+}
+
+func TestCommandCmdPrintsConsoleTail(t *testing.T) {
+	t.Setenv("COMMAND", "echo hello")
+
+	ec2Client := fake.NewDefaultEC2()
+	ec2Client.Instances = []types.Instance{fake.Instance("i-1", testMachineID, time.Now())}
+	ec2Client.ConsoleOutputs = map[string]string{"i-1": "useradd: group 'sudo' does not exist\n"}
+
+	providerAws := newTestProvider(t, ec2Client)
+	out := &bytes.Buffer{}
+	err := (&CommandCmd{}).Run(context.Background(), providerAws, testMachine(providerAws), log.NewStreamLogger(out, out, logrus.InfoLevel))
+	checkError(t, err, "instance devpod-test is not reachable")
+
+	if !strings.Contains(out.String(), "useradd: group 'sudo' does not exist") {
+		t.Errorf("expected the console tail, got %q", out.String())
+	}
+}
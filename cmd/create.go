@@ -2,13 +2,19 @@ package cmd
 
 import (
 	"context"
+	"time"
 
+	awsSDK "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/loft-sh/devpod-provider-aws/pkg/aws"
 	"github.com/loft-sh/devpod/pkg/log"
 	"github.com/loft-sh/devpod/pkg/provider"
 	"github.com/spf13/cobra"
 )
 
+// createRollbackTimeout bounds the clean up of an aborted create
+var createRollbackTimeout = 2 * time.Minute
+
 // CreateCmd holds the cmd flags
 type CreateCmd struct{}
 
@@ -18,14 +24,14 @@ func NewCreateCmd() *cobra.Command {
 	createCmd := &cobra.Command{
 		Use:   "create",
 		Short: "Create an instance",
-		RunE: func(_ *cobra.Command, args []string) error {
-			awsProvider, err := aws.NewProvider(context.Background(), log.Default)
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			awsProvider, err := aws.NewProvider(cobraCmd.Context(), log.Default)
 			if err != nil {
 				return err
 			}
 
 			return cmd.Run(
-				context.Background(),
+				cobraCmd.Context(),
 				awsProvider,
 				provider.FromEnvironment(),
 				log.Default,
@@ -43,16 +49,93 @@ func (cmd *CreateCmd) Run(
 	machine *provider.Machine,
 	logs log.Logger,
 ) error {
-	// Ensure DevPod security group is created
-	_, err := aws.GetDevpodSecurityGroups(ctx, providerAws)
+	// a retried create succeeds if the machine already exists
+	instances, err := aws.GetDevpodInstance(ctx, providerAws.EC2, providerAws.Config.MachineID)
 	if err != nil {
+		return err
+	} else if len(instances.Reservations) > 0 {
+		logs.Infof(
+			"Machine %s already exists as instance %s",
+			providerAws.Config.MachineID,
+			*instances.Reservations[0].Instances[0].InstanceId,
+		)
+
+		return aws.ReconcileDuplicateInstances(ctx, providerAws, logs)
+	}
+
+	result, err := aws.Create(ctx, providerAws)
+	if err != nil {
+		// the instance may have been launched before the create was aborted
+		if ctx.Err() != nil {
+			rollbackCreate(providerAws, logs)
+		}
+
 		return err
 	}
 
-	_, err = aws.Create(ctx, providerAws.AwsConfig, providerAws)
+	instanceID := *result.Instances[0].InstanceId
+	err = aws.WaitForInstanceState(ctx, providerAws.EC2, instanceID, types.InstanceStateNameRunning)
 	if err != nil {
+		printConsoleTail(providerAws.EC2, instanceID, logs)
+
+		if ctx.Err() != nil {
+			rollbackCreate(providerAws, logs)
+		}
+
 		return err
 	}
 
 	return nil
 }
+
+// rollbackCreate terminates the instances of a create that was cancelled or
+// timed out and deletes the security groups, role and instance profile the
+// create made. Instances it cannot terminate keep their devpod tag, so delete
+// or gc can remove them later.
+func rollbackCreate(providerAws *aws.AwsProvider, logs log.Logger) {
+	// the context of the command is done already
+	ctx, cancel := context.WithTimeout(context.Background(), createRollbackTimeout)
+	defer cancel()
+
+	machineID := providerAws.Config.MachineID
+	instances, err := aws.GetDevpodInstance(ctx, providerAws.EC2, machineID)
+	if err != nil {
+		logs.Warnf("Unable to find the instances of the aborted create, remove the instances tagged devpod=%s: %v", machineID, err)
+		return
+	}
+
+	terminated := []string{}
+	for _, reservation := range instances.Reservations {
+		for _, instance := range reservation.Instances {
+			instanceID := awsSDK.ToString(instance.InstanceId)
+			logs.Infof("Terminating instance %s of the aborted create", instanceID)
+
+			err := aws.Delete(ctx, providerAws.EC2, instanceID)
+			if err != nil {
+				logs.Warnf("Unable to terminate instance %s of the aborted create, it is tagged devpod=%s: %v", instanceID, machineID, err)
+				return
+			}
+
+			terminated = append(terminated, instanceID)
+		}
+	}
+
+	if providerAws.Created.Empty() {
+		return
+	}
+
+	// the security groups and the instance profile are in use until the
+	// instances are terminated
+	for _, instanceID := range terminated {
+		err := aws.WaitForInstanceState(ctx, providerAws.EC2, instanceID, types.InstanceStateNameTerminated)
+		if err != nil {
+			logs.Warnf("Unable to delete the resources of the aborted create: %v", err)
+			return
+		}
+	}
+
+	err = aws.DeleteCreatedResources(ctx, providerAws, logs)
+	if err != nil {
+		logs.Warnf("%v, remove them with gc", err)
+	}
+}
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/sirupsen/logrus"
+)
+
+func TestCreateCmd(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(ec2Client *fake.EC2)
+		err   string
+	}{
+		{
+			name: "create instance and security group",
+		},
+		{
+			name: "machine already exists",
+			setup: func(ec2Client *fake.EC2) {
+				instance := fake.Instance("i-1", testMachineID, time.Now())
+				instance.ImageId = aws.String("ami-ubuntu-x86")
+				instance.SecurityGroups = []types.GroupIdentifier{{GroupId: aws.String("sg-existing")}}
+				ec2Client.Instances = []types.Instance{instance}
+				ec2Client.SecurityGroups = []types.SecurityGroup{{GroupId: aws.String("sg-existing")}}
+			},
+		},
+		{
+			name: "no default VPC",
+			setup: func(ec2Client *fake.EC2) {
+				ec2Client.Vpcs = []types.Vpc{{VpcId: aws.String("vpc-custom"), IsDefault: aws.Bool(false)}}
+			},
+			err: "There is no default VPC",
+		},
+		{
+			name: "run instances fails",
+			setup: func(ec2Client *fake.EC2) {
+				ec2Client.Errors["RunInstances"] = fake.APIError("InsufficientInstanceCapacity", "no capacity")
+			},
+			err: "InsufficientInstanceCapacity",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec2Client := fake.NewDefaultEC2()
+			if test.setup != nil {
+				test.setup(ec2Client)
+			}
+
+			providerAws := newTestProvider(t, ec2Client)
+			err := (&CreateCmd{}).Run(context.Background(), providerAws, testMachine(providerAws), testLog)
+			checkError(t, err, test.err)
+			if err != nil {
+				return
+			}
+
+			if len(ec2Client.Instances) != 1 {
+				t.Fatalf("expected one instance, got %d", len(ec2Client.Instances))
+			}
+			if len(ec2Client.SecurityGroups) != 1 {
+				t.Fatalf("expected the devpod security group, got %d groups", len(ec2Client.SecurityGroups))
+			}
+
+			instance := ec2Client.Instances[0]
+			if aws.ToString(instance.ImageId) != "ami-ubuntu-x86" {
+				t.Errorf("expected image ami-ubuntu-x86, got %s", aws.ToString(instance.ImageId))
+			}
+			if instance.SecurityGroups[0].GroupId == nil ||
+				*instance.SecurityGroups[0].GroupId != *ec2Client.SecurityGroups[0].GroupId {
+				t.Errorf("expected instance in the devpod security group")
+			}
+		})
+	}
+}
+
+// abortingEC2 cancels the create right after the instance was launched,
+// before its response arrives
+type abortingEC2 struct {
+	*fake.EC2
+	cancel context.CancelFunc
+}
+
+func (a *abortingEC2) RunInstances(ctx context.Context, params *ec2.RunInstancesInput, optFns ...func(*ec2.Options)) (*ec2.RunInstancesOutput, error) {
+	_, err := a.EC2.RunInstances(ctx, params, optFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	a.cancel()
+	return nil, ctx.Err()
+}
+
+func TestCreateCmdRollsBackWhenAborted(t *testing.T) {
+	ec2Client := fake.NewDefaultEC2()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	providerAws := newTestProvider(t, ec2Client)
+	providerAws.EC2 = &abortingEC2{EC2: ec2Client, cancel: cancel}
+
+	err := (&CreateCmd{}).Run(ctx, providerAws, testMachine(providerAws), testLog)
+	checkError(t, err, "context canceled")
+
+	if len(ec2Client.Instances) != 1 {
+		t.Fatalf("expected the launched instance, got %d", len(ec2Client.Instances))
+	}
+	if state := ec2Client.Instances[0].State.Name; state != types.InstanceStateNameTerminated {
+		t.Errorf("expected the instance of the aborted create to be terminated, got %s", state)
+	}
+	if len(ec2Client.SecurityGroups) != 0 {
+		t.Errorf("expected the security group of the aborted create to be deleted, got %v", ec2Client.SecurityGroups)
+	}
+	if _, ok := providerAws.IAM.(*fake.IAM).InstanceProfiles["devpod-ec2-role"]; !ok {
+		t.Errorf("expected the existing instance profile to be kept")
+	}
+}
+
+// terminatingEC2 terminates the launched instance right away, like an
+// instance that fails to boot
+type terminatingEC2 struct {
+	*fake.EC2
+}
+
+func (f *terminatingEC2) RunInstances(ctx context.Context, params *ec2.RunInstancesInput, optFns ...func(*ec2.Options)) (*ec2.RunInstancesOutput, error) {
+	result, err := f.EC2.RunInstances(ctx, params, optFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	instanceID := aws.ToString(result.Instances[0].InstanceId)
+	f.ConsoleOutputs = map[string]string{instanceID: "Kernel panic - not syncing: VFS: Unable to mount root fs"}
+	for i := range f.Instances {
+		if aws.ToString(f.Instances[i].InstanceId) == instanceID {
+			f.Instances[i].State = &types.InstanceState{Name: types.InstanceStateNameTerminated}
+		}
+	}
+
+	return result, nil
+}
+
+func TestCreateCmdPrintsConsoleTail(t *testing.T) {
+	ec2Client := fake.NewDefaultEC2()
+	providerAws := newTestProvider(t, ec2Client)
+	providerAws.EC2 = &terminatingEC2{EC2: ec2Client}
+
+	out := &bytes.Buffer{}
+	err := (&CreateCmd{}).Run(context.Background(), providerAws, testMachine(providerAws), log.NewStreamLogger(out, out, logrus.InfoLevel))
+	checkError(t, err, "wait for instance")
+
+	if !strings.Contains(out.String(), "Unable to mount root fs") {
+		t.Errorf("expected the console tail, got %q", out.String())
+	}
+}
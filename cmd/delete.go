@@ -6,7 +6,6 @@ import (
 	"github.com/loft-sh/devpod-provider-aws/pkg/aws"
 	"github.com/loft-sh/devpod/pkg/log"
 	"github.com/loft-sh/devpod/pkg/provider"
-	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
@@ -19,14 +18,14 @@ func NewDeleteCmd() *cobra.Command {
 	deleteCmd := &cobra.Command{
 		Use:   "delete",
 		Short: "Delete an instance",
-		RunE: func(_ *cobra.Command, args []string) error {
-			awsProvider, err := aws.NewProvider(context.Background(), log.Default)
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			awsProvider, err := aws.NewProvider(cobraCmd.Context(), log.Default)
 			if err != nil {
 				return err
 			}
 
 			return cmd.Run(
-				context.Background(),
+				cobraCmd.Context(),
 				awsProvider,
 				provider.FromEnvironment(),
 				log.Default,
@@ -44,25 +43,5 @@ func (cmd *DeleteCmd) Run(
 	machine *provider.Machine,
 	logs log.Logger,
 ) error {
-	instances, err := aws.GetDevpodInstance(
-		ctx,
-		providerAws.AwsConfig,
-		providerAws.Config.MachineID,
-	)
-	if err != nil {
-		return err
-	}
-
-	if len(instances.Reservations) > 0 {
-		targetID := instances.Reservations[0].Instances[0].InstanceId
-
-		err = aws.Delete(ctx, providerAws.AwsConfig, *targetID)
-		if err != nil {
-			return err
-		}
-	} else {
-		return errors.Errorf("No devpod instance %s found", providerAws.Config.MachineID)
-	}
-
-	return nil
+	return aws.DeleteMachine(ctx, providerAws, logs)
 }
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+)
+
+func TestDeleteCmd(t *testing.T) {
+	now := time.Now()
+	machineTags := []types.Tag{{Key: awsSDK.String("devpod"), Value: awsSDK.String(testMachineID)}}
+	otherTags := []types.Tag{{Key: awsSDK.String("devpod"), Value: awsSDK.String("devpod-other")}}
+
+	tests := []struct {
+		name        string
+		instances   []types.Instance
+		keepVolumes bool
+		terminated  []string
+		volumes     int
+		err         string
+	}{
+		{
+			name:       "running instance",
+			instances:  []types.Instance{fake.Instance("i-1", testMachineID, now)},
+			terminated: []string{"i-1"},
+			volumes:    1,
+		},
+		{
+			name:       "stopped instance",
+			instances:  []types.Instance{withState(fake.Instance("i-1", testMachineID, now), types.InstanceStateNameStopped)},
+			terminated: []string{"i-1"},
+			volumes:    1,
+		},
+		{
+			name: "duplicate instances",
+			instances: []types.Instance{
+				fake.Instance("i-old", testMachineID, now.Add(-time.Hour)),
+				fake.Instance("i-1", testMachineID, now),
+			},
+			terminated: []string{"i-old", "i-1"},
+			volumes:    1,
+		},
+		{
+			name:    "no instance",
+			volumes: 1,
+		},
+		{
+			name:        "keep volumes",
+			instances:   []types.Instance{fake.Instance("i-1", testMachineID, now)},
+			keepVolumes: true,
+			terminated:  []string{"i-1"},
+			volumes:     2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec2Client := fake.NewDefaultEC2()
+			ec2Client.Instances = test.instances
+			ec2Client.Volumes = []types.Volume{
+				{VolumeId: awsSDK.String("vol-data"), State: types.VolumeStateAvailable, Tags: machineTags},
+				{VolumeId: awsSDK.String("vol-other"), State: types.VolumeStateAvailable, Tags: otherTags},
+			}
+			ec2Client.Addresses = []types.Address{
+				{AllocationId: awsSDK.String("eipalloc-1"), Tags: machineTags},
+			}
+			if len(test.instances) > 0 {
+				ec2Client.Addresses[0].AssociationId = awsSDK.String("eipassoc-1")
+				ec2Client.Addresses[0].InstanceId = awsSDK.String("i-1")
+			}
+			ec2Client.Snapshots = []types.Snapshot{
+				{SnapshotId: awsSDK.String("snap-1"), Tags: machineTags},
+			}
+			ec2Client.SecurityGroups = append(ec2Client.SecurityGroups, types.SecurityGroup{GroupId: awsSDK.String("sg-machine"), Tags: machineTags})
+
+			providerAws := newTestProvider(t, ec2Client)
+			providerAws.Config.KeepVolumes = test.keepVolumes
+			err := aws.SetDiskSizeReconciled(providerAws.Config.MachineFolder, 40)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = (&DeleteCmd{}).Run(context.Background(), providerAws, testMachine(providerAws), testLog)
+			checkError(t, err, test.err)
+			if err != nil {
+				return
+			}
+
+			for _, id := range test.terminated {
+				instance, _ := ec2Client.Instance(id)
+				if instance.State.Name != types.InstanceStateNameTerminated {
+					t.Errorf("expected %s to be terminated, got %s", id, instance.State.Name)
+				}
+			}
+
+			if len(ec2Client.Volumes) != test.volumes {
+				t.Errorf("expected %d volumes, got %d", test.volumes, len(ec2Client.Volumes))
+			}
+			if len(ec2Client.Addresses) != 0 {
+				t.Errorf("expected elastic IP to be released, got %v", ec2Client.Addresses)
+			}
+			if len(ec2Client.Snapshots) != 0 {
+				t.Errorf("expected snapshots to be deleted, got %d", len(ec2Client.Snapshots))
+			}
+			if len(ec2Client.SecurityGroups) != 0 {
+				t.Errorf("expected security group to be deleted, got %d groups", len(ec2Client.SecurityGroups))
+			}
+			if aws.IsDiskSizeReconciled(providerAws.Config.MachineFolder, 40) {
+				t.Error("expected cached disk size to be removed")
+			}
+		})
+	}
+}
+
+func TestDeleteCmdKeptVolumesSurviveGc(t *testing.T) {
+	machineTags := []types.Tag{{Key: awsSDK.String("devpod"), Value: awsSDK.String(testMachineID)}}
+
+	ec2Client := fake.NewDefaultEC2()
+	ec2Client.Instances = []types.Instance{fake.Instance("i-1", testMachineID, time.Now())}
+	ec2Client.Volumes = []types.Volume{
+		{
+			VolumeId: awsSDK.String("vol-data"),
+			State:    types.VolumeStateInUse,
+			Tags:     machineTags,
+			Attachments: []types.VolumeAttachment{
+				{InstanceId: awsSDK.String("i-1"), DeleteOnTermination: awsSDK.Bool(false)},
+			},
+		},
+	}
+
+	providerAws := newTestProvider(t, ec2Client)
+	providerAws.AwsConfig.Region = "us-east-1"
+	providerAws.Config.KeepVolumes = true
+	err := (&DeleteCmd{}).Run(context.Background(), providerAws, testMachine(providerAws), testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = (&GcCmd{Yes: true}).Run(context.Background(), providerAws, testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ec2Client.Volumes) != 1 {
+		t.Fatalf("expected the kept volume to survive gc, got %d volumes", len(ec2Client.Volumes))
+	}
+	if !aws.IsKeptAlive(ec2Client.Volumes[0].Tags, time.Now()) {
+		t.Errorf("expected the kept volume to be tagged %s, got %v", aws.TagKeyKeepAlive, ec2Client.Volumes[0].Tags)
+	}
+}
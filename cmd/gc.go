@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/spf13/cobra"
+)
+
+// GcCmd holds the cmd flags
+type GcCmd struct {
+	Regions    []string
+	AllRegions bool
+	StoppedFor time.Duration
+	Yes        bool
+	DryRun     bool
+}
+
+// NewGcCmd defines a command
+func NewGcCmd() *cobra.Command {
+	cmd := &GcCmd{}
+	gcCmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Delete orphaned devpod resources of the account",
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			providerAws, err := newAccountProvider(cobraCmd.Context())
+			if err != nil {
+				return err
+			}
+
+			return cmd.Run(cobraCmd.Context(), providerAws, log.Default)
+		},
+	}
+
+	gcCmd.Flags().StringSliceVar(&cmd.Regions, "region", nil, "Regions to clean up, defaults to the configured region")
+	gcCmd.Flags().BoolVar(&cmd.AllRegions, "all-regions", false, "Clean up all regions enabled for the account, the devpod IAM role and instance profile are only deleted with this flag")
+	gcCmd.Flags().DurationVar(&cmd.StoppedFor, "stopped-for", 0, "Terminate instances stopped for longer than this, e.g. 720h, stopped instances are kept by default")
+	gcCmd.Flags().BoolVarP(&cmd.Yes, "yes", "y", false, "Delete the planned resources")
+	gcCmd.Flags().BoolVar(&cmd.DryRun, "dry-run", false, "Only check the permissions to delete the planned resources")
+
+	return gcCmd
+}
+
+// Run runs the command logic
+func (cmd *GcCmd) Run(
+	ctx context.Context,
+	providerAws *aws.AwsProvider,
+	logs log.Logger,
+) error {
+	if cmd.Yes && cmd.DryRun {
+		return fmt.Errorf("--yes and --dry-run are mutually exclusive")
+	}
+
+	regions, err := getRegions(ctx, providerAws, cmd.Regions, cmd.AllRegions)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	clients := map[string]aws.EC2Client{}
+	plan := []aws.OrphanedResource{}
+	for _, region := range regions {
+		svc := providerAws.EC2ForRegion(region)
+		clients[region] = svc
+
+		orphans, err := aws.FindOrphanedResources(ctx, svc, region, cmd.StoppedFor, now)
+		if err != nil {
+			return fmt.Errorf("find orphaned resources in %s: %w", region, err)
+		}
+
+		plan = append(plan, orphans...)
+	}
+
+	// the IAM role is global, it can only be considered unused if no
+	// instance in any region uses it
+	if cmd.AllRegions {
+		profile, err := aws.FindOrphanedInstanceProfile(ctx, providerAws.IAM, clients)
+		if err != nil {
+			return err
+		}
+
+		if profile != nil {
+			plan = append(plan, *profile)
+		}
+	}
+
+	aws.SortOrphanedResources(plan)
+
+	if len(plan) == 0 {
+		logs.Info("No orphaned devpod resources found")
+		return nil
+	}
+
+	err = writePlan(os.Stdout, plan)
+	if err != nil {
+		return err
+	}
+
+	if !cmd.Yes && !cmd.DryRun {
+		logs.Infof("Run with --yes to delete these %d resources", len(plan))
+		return nil
+	}
+
+	failed := 0
+	for _, resource := range plan {
+		svc := clients[resource.Region]
+		if svc == nil {
+			svc = providerAws.EC2
+		}
+
+		if cmd.DryRun && resource.Kind == aws.OrphanedInstanceProfile {
+			logs.Infof("Skipping %s %s, IAM does not support dry runs", resource.Kind, resource.ID)
+			continue
+		}
+
+		err := aws.DeleteOrphanedResource(ctx, svc, providerAws.IAM, resource, cmd.DryRun)
+		if err != nil {
+			failed++
+			logs.Errorf("Failed to delete %s %s: %v", resource.Kind, resource.ID, err)
+			continue
+		}
+
+		if cmd.DryRun {
+			logs.Donef("Would delete %s %s", resource.Kind, resource.ID)
+		} else {
+			logs.Donef("Deleted %s %s", resource.Kind, resource.ID)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to delete %d of %d resources", failed, len(plan))
+	}
+
+	return nil
+}
+
+func writePlan(out io.Writer, plan []aws.OrphanedResource) error {
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "KIND\tID\tREGION\tMACHINE\tREASON")
+
+	for _, resource := range plan {
+		fmt.Fprintf(
+			writer,
+			"%s\t%s\t%s\t%s\t%s\n",
+			resource.Kind,
+			resource.ID,
+			valueOrDash(resource.Region),
+			valueOrDash(resource.MachineID),
+			resource.Reason,
+		)
+	}
+
+	return writer.Flush()
+}
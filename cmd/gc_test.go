@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	awsSDK "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+)
+
+func TestGcCmd(t *testing.T) {
+	tests := []struct {
+		name            string
+		cmd             GcCmd
+		instances       bool
+		errors          map[string]error
+		expectedPlan    []string
+		expectedVolumes int
+		expectedGroups  int
+		expectedProfile bool
+		err             string
+	}{
+		{
+			name:            "plan only",
+			cmd:             GcCmd{AllRegions: true},
+			expectedPlan:    []string{"volume", "vol-orphan", "machine devpod-gone no longer exists", "security-group", "instance-profile"},
+			expectedVolumes: 1,
+			expectedGroups:  1,
+			expectedProfile: true,
+		},
+		{
+			name:            "dry run",
+			cmd:             GcCmd{AllRegions: true, DryRun: true},
+			expectedPlan:    []string{"vol-orphan", "instance-profile"},
+			expectedVolumes: 1,
+			expectedGroups:  1,
+			expectedProfile: true,
+		},
+		{
+			name:         "apply",
+			cmd:          GcCmd{AllRegions: true, Yes: true},
+			expectedPlan: []string{"vol-orphan", "instance-profile"},
+		},
+		{
+			name:            "role kept without all regions",
+			cmd:             GcCmd{Yes: true},
+			expectedPlan:    []string{"vol-orphan"},
+			expectedProfile: true,
+		},
+		{
+			name:            "instance keeps its resources",
+			cmd:             GcCmd{AllRegions: true, Yes: true},
+			instances:       true,
+			expectedPlan:    []string{"vol-orphan"},
+			expectedGroups:  1,
+			expectedProfile: true,
+		},
+		{
+			name:            "delete fails",
+			cmd:             GcCmd{Yes: true},
+			errors:          map[string]error{"DeleteVolume": fake.APIError("UnauthorizedOperation", "You are not authorized to perform this operation.")},
+			expectedPlan:    []string{"vol-orphan"},
+			expectedVolumes: 1,
+			expectedProfile: true,
+			err:             "failed to delete 1 of 2 resources",
+		},
+		{
+			name:            "yes and dry run",
+			cmd:             GcCmd{Yes: true, DryRun: true},
+			expectedVolumes: 1,
+			expectedGroups:  1,
+			expectedProfile: true,
+			err:             "mutually exclusive",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec2Client := fake.NewDefaultEC2()
+			for name, err := range test.errors {
+				ec2Client.Errors[name] = err
+			}
+
+			providerAws := newTestProvider(t, ec2Client)
+			providerAws.AwsConfig.Region = "us-east-1"
+			if test.instances {
+				err := (&CreateCmd{}).Run(context.Background(), providerAws, testMachine(providerAws), testLog)
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				ec2Client.SecurityGroups = append(ec2Client.SecurityGroups, types.SecurityGroup{
+					GroupId: awsSDK.String("sg-devpod"),
+					Tags:    []types.Tag{{Key: awsSDK.String("devpod"), Value: awsSDK.String("devpod")}},
+				})
+			}
+
+			ec2Client.Volumes = append(ec2Client.Volumes, types.Volume{
+				VolumeId: awsSDK.String("vol-orphan"),
+				State:    types.VolumeStateAvailable,
+				Tags:     []types.Tag{{Key: awsSDK.String("devpod"), Value: awsSDK.String("devpod-gone")}},
+			})
+
+			out, err := captureStdout(t, func() error {
+				return test.cmd.Run(context.Background(), providerAws, testLog)
+			})
+			checkError(t, err, test.err)
+
+			for _, expected := range test.expectedPlan {
+				if !strings.Contains(out, expected) {
+					t.Errorf("expected %q in plan:\n%s", expected, out)
+				}
+			}
+
+			volumes := 0
+			for _, volume := range ec2Client.Volumes {
+				if awsSDK.ToString(volume.VolumeId) == "vol-orphan" {
+					volumes++
+				}
+			}
+
+			if volumes != test.expectedVolumes {
+				t.Errorf("expected %d orphaned volumes, got %d", test.expectedVolumes, volumes)
+			}
+
+			if len(ec2Client.SecurityGroups) != test.expectedGroups {
+				t.Errorf("expected %d security groups, got %d", test.expectedGroups, len(ec2Client.SecurityGroups))
+			}
+
+			_, profile := providerAws.IAM.(*fake.IAM).InstanceProfiles["devpod-ec2-role"]
+			if profile != test.expectedProfile {
+				t.Errorf("expected instance profile to exist: %v, got %v", test.expectedProfile, profile)
+			}
+		})
+	}
+}
+
+func TestGcCmdKeepsStoppedInstancesByDefault(t *testing.T) {
+	flag := NewGcCmd().Flags().Lookup("stopped-for")
+	if flag == nil || flag.DefValue != "0s" {
+		t.Errorf("expected --stopped-for to default to 0s, got %v", flag)
+	}
+}
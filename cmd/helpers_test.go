@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+	"github.com/loft-sh/devpod-provider-aws/pkg/options"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/loft-sh/devpod/pkg/provider"
+	"github.com/sirupsen/logrus"
+)
+
+const testMachineID = "devpod-test"
+
+var testLog = log.NewStreamLogger(io.Discard, io.Discard, logrus.DebugLevel)
+
+// newTestProvider returns a provider backed by the given fake EC2 API, with
+// an existing devpod instance profile
+func newTestProvider(t *testing.T, ec2Client *fake.EC2) *aws.AwsProvider {
+	t.Helper()
+
+	iamClient := fake.NewIAM()
+	iamClient.AddInstanceProfile("devpod-ec2-role")
+
+	return &aws.AwsProvider{
+		Config: &options.Options{
+			DiskImage:     "ami-ubuntu-x86",
+			DiskSizeGB:    40,
+			RootDevice:    "/dev/sda1",
+			MachineFolder: t.TempDir(),
+			MachineID:     testMachineID,
+			MachineType:   "c5.xlarge",
+		},
+		Log: testLog,
+		EC2: ec2Client,
+		IAM: iamClient,
+		STS: fake.NewSTS("arn:aws:iam::123456789012:user/alice"),
+	}
+}
+
+func testMachine(providerAws *aws.AwsProvider) *provider.Machine {
+	return &provider.Machine{
+		ID:     providerAws.Config.MachineID,
+		Folder: providerAws.Config.MachineFolder,
+	}
+}
+
+func withState(instance types.Instance, state types.InstanceStateName) types.Instance {
+	instance.State = &types.InstanceState{Name: state}
+
+	return instance
+}
+
+func checkError(t *testing.T, err error, expected string) {
+	t.Helper()
+
+	switch {
+	case expected == "" && err != nil:
+		t.Fatalf("unexpected error: %v", err)
+	case expected != "" && err == nil:
+		t.Fatalf("expected error containing %q, got nil", expected)
+	case expected != "" && !strings.Contains(err.Error(), expected):
+		t.Fatalf("expected error containing %q, got %v", expected, err)
+	}
+}
+
+// captureStdout returns everything fn writes to os.Stdout
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	t.Helper()
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() {
+		os.Stdout = stdout
+	}()
+
+	fnErr := fn()
+	_ = writer.Close()
+
+	out, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(out), fnErr
+}
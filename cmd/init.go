@@ -3,7 +3,6 @@ package cmd
 import (
 	"context"
 
-	AwsConfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/loft-sh/devpod-provider-aws/pkg/aws"
 	"github.com/loft-sh/devpod-provider-aws/pkg/options"
 	"github.com/loft-sh/devpod/pkg/log"
@@ -20,9 +19,20 @@ func NewInitCmd() *cobra.Command {
 	initCmd := &cobra.Command{
 		Use:   "init",
 		Short: "Init account",
-		RunE: func(_ *cobra.Command, args []string) error {
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			config, err := options.FromEnv(true)
+			if err != nil {
+				return err
+			}
+
+			cfg, err := aws.NewAWSConfig(cobraCmd.Context(), config.Retry)
+			if err != nil {
+				return err
+			}
+
 			return cmd.Run(
-				context.Background(),
+				cobraCmd.Context(),
+				aws.NewProviderFromConfig(config, cfg, log.Default),
 				provider.FromEnvironment(),
 				log.Default,
 			)
@@ -35,29 +45,20 @@ func NewInitCmd() *cobra.Command {
 // Run runs the init logic
 func (cmd *InitCmd) Run(
 	ctx context.Context,
+	providerAws *aws.AwsProvider,
 	machine *provider.Machine,
 	logs log.Logger,
 ) error {
-	config, err := options.FromEnv(true)
-	if err != nil {
-		return err
-	}
-
-	cfg, err := AwsConfig.LoadDefaultConfig(ctx)
-	if err != nil {
-		return err
-	}
-
-	_, err = aws.GetDevpodRunningInstance(
+	_, err := aws.GetDevpodRunningInstance(
 		ctx,
-		cfg,
-		config.MachineID,
+		providerAws.EC2,
+		providerAws.Config.MachineID,
 	)
 	if err != nil {
 		return err
 	}
 
-	_, err = aws.GetDefaultAMI(ctx, cfg, config.MachineType)
+	_, err = aws.GetDefaultAMI(ctx, providerAws.EC2, providerAws.Config.MachineType)
 	if err != nil {
 		return err
 	}
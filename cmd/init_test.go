@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+)
+
+func TestInitCmd(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(ec2Client *fake.EC2)
+		err   string
+	}{
+		{
+			name: "valid account",
+		},
+		{
+			name: "no AMI available",
+			setup: func(ec2Client *fake.EC2) {
+				ec2Client.Images = []types.Image{}
+			},
+			err: "no Ubuntu 22.04 x86_64 AMI found",
+		},
+		{
+			name: "missing permissions",
+			setup: func(ec2Client *fake.EC2) {
+				ec2Client.Errors["DescribeInstances"] = fake.APIError("UnauthorizedOperation", "You are not authorized to perform this operation.")
+			},
+			err: "UnauthorizedOperation",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec2Client := fake.NewDefaultEC2()
+			if test.setup != nil {
+				test.setup(ec2Client)
+			}
+
+			providerAws := newTestProvider(t, ec2Client)
+			err := (&InitCmd{}).Run(context.Background(), providerAws, testMachine(providerAws), testLog)
+			checkError(t, err, test.err)
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws"
+	"github.com/loft-sh/devpod-provider-aws/pkg/options"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/spf13/cobra"
+)
+
+// ListCmd holds the cmd flags
+type ListCmd struct {
+	Regions    []string
+	AllRegions bool
+	Output     string
+	Owner      string
+	States     []string
+	MinAge     time.Duration
+	MaxAge     time.Duration
+}
+
+// NewListCmd defines a command
+func NewListCmd() *cobra.Command {
+	cmd := &ListCmd{}
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List all devpod instances of the account",
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			providerAws, err := newAccountProvider(cobraCmd.Context())
+			if err != nil {
+				return err
+			}
+
+			return cmd.Run(cobraCmd.Context(), providerAws, log.Default)
+		},
+	}
+
+	listCmd.Flags().StringSliceVar(&cmd.Regions, "region", nil, "Regions to search, defaults to the configured region")
+	listCmd.Flags().BoolVar(&cmd.AllRegions, "all-regions", false, "Search all regions enabled for the account")
+	listCmd.Flags().StringVarP(&cmd.Output, "output", "o", "table", "Output format, one of table, json or csv")
+	listCmd.Flags().StringVar(&cmd.Owner, "owner", "", "Only list instances whose owner contains this value")
+	listCmd.Flags().StringSliceVar(&cmd.States, "state", nil, "Only list instances in these states, e.g. running,stopped")
+	listCmd.Flags().DurationVar(&cmd.MinAge, "min-age", 0, "Only list instances older than this, e.g. 72h")
+	listCmd.Flags().DurationVar(&cmd.MaxAge, "max-age", 0, "Only list instances younger than this, e.g. 24h")
+
+	return listCmd
+}
+
+// Run runs the command logic
+func (cmd *ListCmd) Run(
+	ctx context.Context,
+	providerAws *aws.AwsProvider,
+	logs log.Logger,
+) error {
+	if cmd.Output != "table" && cmd.Output != "json" && cmd.Output != "csv" {
+		return fmt.Errorf("unsupported output %q, expected table, json or csv", cmd.Output)
+	}
+
+	regions, err := getRegions(ctx, providerAws, cmd.Regions, cmd.AllRegions)
+	if err != nil {
+		return err
+	}
+
+	machines, err := listMachines(ctx, providerAws, regions)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	filtered := []aws.Machine{}
+	for _, machine := range machines {
+		if cmd.matches(machine, now) {
+			filtered = append(filtered, machine)
+		}
+	}
+
+	switch cmd.Output {
+	case "json":
+		return writeMachinesJSON(os.Stdout, filtered)
+	case "csv":
+		return writeMachinesCSV(os.Stdout, filtered)
+	default:
+		return writeMachinesTable(os.Stdout, filtered, now)
+	}
+}
+
+func (cmd *ListCmd) matches(machine aws.Machine, now time.Time) bool {
+	if cmd.Owner != "" && !strings.Contains(machine.Owner, cmd.Owner) {
+		return false
+	}
+
+	if len(cmd.States) > 0 {
+		found := false
+		for _, state := range cmd.States {
+			if strings.EqualFold(state, machine.State) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	age := now.Sub(machine.CreatedAt)
+	if cmd.MinAge > 0 && age < cmd.MinAge {
+		return false
+	}
+
+	if cmd.MaxAge > 0 && age > cmd.MaxAge {
+		return false
+	}
+
+	return true
+}
+
+// newAccountProvider returns a provider for the account wide commands, which
+// need credentials but none of the machine options
+func newAccountProvider(ctx context.Context) (*aws.AwsProvider, error) {
+	retryOptions, err := options.RetryFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := aws.NewAWSConfig(ctx, retryOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return aws.NewProviderFromConfig(&options.Options{}, cfg, log.Default), nil
+}
+
+// getRegions returns the regions to search, the configured region if none
+// are given
+func getRegions(ctx context.Context, providerAws *aws.AwsProvider, regions []string, all bool) ([]string, error) {
+	if all {
+		return aws.ListRegions(ctx, providerAws.EC2)
+	}
+
+	if len(regions) == 0 {
+		return []string{providerAws.AwsConfig.Region}, nil
+	}
+
+	return regions, nil
+}
+
+// listMachines queries all regions concurrently
+func listMachines(ctx context.Context, providerAws *aws.AwsProvider, regions []string) ([]aws.Machine, error) {
+	results := make([][]aws.Machine, len(regions))
+	errs := make([]error, len(regions))
+
+	wg := sync.WaitGroup{}
+	for i, region := range regions {
+		svc := providerAws.EC2ForRegion(region)
+
+		wg.Add(1)
+		go func(i int, region string) {
+			defer wg.Done()
+
+			results[i], errs[i] = aws.ListMachines(ctx, svc, region)
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("list instances in %s: %w", region, errs[i])
+			}
+		}(i, region)
+	}
+	wg.Wait()
+
+	machines := []aws.Machine{}
+	for i := range regions {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+
+		machines = append(machines, results[i]...)
+	}
+
+	sort.SliceStable(machines, func(i, j int) bool {
+		return machines[i].CreatedAt.Before(machines[j].CreatedAt)
+	})
+
+	return machines, nil
+}
+
+func writeMachinesJSON(out io.Writer, machines []aws.Machine) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(machines)
+}
+
+func writeMachinesCSV(out io.Writer, machines []aws.Machine) error {
+	writer := csv.NewWriter(out)
+
+	err := writer.Write([]string{
+		"id", "instance_id", "region", "owner", "state", "type", "zone",
+		"public_ip", "private_ip", "created_at", "launched_at", "volumes_gb", "monthly_cost_usd_us_east_1_estimate",
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, machine := range machines {
+		err := writer.Write([]string{
+			machine.ID,
+			machine.InstanceID,
+			machine.Region,
+			machine.Owner,
+			machine.State,
+			machine.InstanceType,
+			machine.AvailabilityZone,
+			machine.PublicIP,
+			machine.PrivateIP,
+			machine.CreatedAt.UTC().Format(time.RFC3339),
+			machine.LaunchedAt.UTC().Format(time.RFC3339),
+			formatVolumeSizes(machine.Volumes, " "),
+			formatCost(machine.MonthlyCost, "%.2f", ""),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
+
+func writeMachinesTable(out io.Writer, machines []aws.Machine, now time.Time) error {
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "MACHINE\tINSTANCE\tREGION\tOWNER\tSTATE\tTYPE\tZONE\tPUBLIC IP\tPRIVATE IP\tAGE\tUP\tVOLUMES\tCOST/MONTH (US-EAST-1 EST.)")
+
+	for _, machine := range machines {
+		// the time since the last start is only meaningful while running
+		up := "-"
+		if machine.State == "running" {
+			up = formatAge(now.Sub(machine.LaunchedAt))
+		}
+
+		fmt.Fprintf(
+			writer,
+			"%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			machine.ID,
+			machine.InstanceID,
+			machine.Region,
+			valueOrDash(machine.Owner),
+			machine.State,
+			machine.InstanceType,
+			valueOrDash(machine.AvailabilityZone),
+			valueOrDash(machine.PublicIP),
+			valueOrDash(machine.PrivateIP),
+			formatAge(now.Sub(machine.CreatedAt)),
+			up,
+			valueOrDash(formatVolumeSizes(machine.Volumes, ",")),
+			formatCost(machine.MonthlyCost, "$%.2f", "-"),
+		)
+	}
+
+	return writer.Flush()
+}
+
+func formatVolumeSizes(volumes []aws.MachineVolume, separator string) string {
+	sizes := []string{}
+	for _, volume := range volumes {
+		sizes = append(sizes, fmt.Sprintf("%dGB", volume.SizeGB))
+	}
+
+	return strings.Join(sizes, separator)
+}
+
+func formatCost(cost *float64, format, unknown string) string {
+	if cost == nil {
+		return unknown
+	}
+
+	return fmt.Sprintf(format, *cost)
+}
+
+// formatAge formats a duration with its largest unit, e.g. 3d or 5h
+func formatAge(duration time.Duration) string {
+	switch {
+	case duration >= 48*time.Hour:
+		return fmt.Sprintf("%dd", duration/(24*time.Hour))
+	case duration >= time.Hour:
+		return fmt.Sprintf("%dh", duration/time.Hour)
+	case duration >= time.Minute:
+		return fmt.Sprintf("%dm", duration/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", duration/time.Second)
+	}
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+)
+
+func TestListCmd(t *testing.T) {
+	now := time.Now()
+
+	newInstance := func(id, machineID, owner string, age time.Duration, state types.InstanceStateName) types.Instance {
+		instance := withState(fake.Instance(id, machineID, now), state)
+		instance.Tags = append(
+			instance.Tags,
+			types.Tag{Key: awsSDK.String(aws.TagKeyOwner), Value: awsSDK.String(owner)},
+			types.Tag{Key: awsSDK.String(aws.TagKeyCreatedAt), Value: awsSDK.String(now.Add(-age).UTC().Format(time.RFC3339))},
+		)
+
+		return instance
+	}
+
+	east := fake.NewDefaultEC2()
+	east.Regions = append(east.Regions, types.Region{RegionName: awsSDK.String("eu-west-1")})
+	east.Instances = []types.Instance{
+		newInstance("i-alice", "devpod-alice", "arn:aws:iam::123456789012:user/alice", 10*24*time.Hour, types.InstanceStateNameRunning),
+		newInstance("i-bob", "devpod-bob", "arn:aws:iam::123456789012:user/bob", time.Hour, types.InstanceStateNameStopped),
+	}
+
+	west := fake.NewEC2()
+	west.Instances = []types.Instance{
+		newInstance("i-carol", "devpod-carol", "arn:aws:iam::123456789012:user/carol", 3*24*time.Hour, types.InstanceStateNameRunning),
+	}
+
+	tests := []struct {
+		name     string
+		cmd      ListCmd
+		errors   map[string]error
+		expected []string
+		err      string
+	}{
+		{
+			name:     "configured region",
+			cmd:      ListCmd{Output: "json"},
+			expected: []string{"devpod-alice", "devpod-bob"},
+		},
+		{
+			name:     "all regions",
+			cmd:      ListCmd{Output: "json", AllRegions: true},
+			expected: []string{"devpod-alice", "devpod-carol", "devpod-bob"},
+		},
+		{
+			name:     "explicit region",
+			cmd:      ListCmd{Output: "json", Regions: []string{"eu-west-1"}},
+			expected: []string{"devpod-carol"},
+		},
+		{
+			name:     "owner",
+			cmd:      ListCmd{Output: "json", AllRegions: true, Owner: "user/bob"},
+			expected: []string{"devpod-bob"},
+		},
+		{
+			name:     "state",
+			cmd:      ListCmd{Output: "json", AllRegions: true, States: []string{"Running"}},
+			expected: []string{"devpod-alice", "devpod-carol"},
+		},
+		{
+			name:     "min age",
+			cmd:      ListCmd{Output: "json", AllRegions: true, MinAge: 48 * time.Hour},
+			expected: []string{"devpod-alice", "devpod-carol"},
+		},
+		{
+			name:     "max age",
+			cmd:      ListCmd{Output: "json", AllRegions: true, MaxAge: 5 * 24 * time.Hour},
+			expected: []string{"devpod-carol", "devpod-bob"},
+		},
+		{
+			name: "invalid output",
+			cmd:  ListCmd{Output: "yaml"},
+			err:  "unsupported output",
+		},
+		{
+			name:   "describe fails",
+			cmd:    ListCmd{Output: "json"},
+			errors: map[string]error{"DescribeInstances": fake.APIError("UnauthorizedOperation", "You are not authorized to perform this operation.")},
+			err:    "list instances in us-east-1: api error UnauthorizedOperation",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			east.Errors = map[string]error{}
+			for name, err := range test.errors {
+				east.Errors[name] = err
+			}
+
+			providerAws := newTestProvider(t, east)
+			providerAws.AwsConfig.Region = "us-east-1"
+			providerAws.RegionalEC2 = map[string]aws.EC2Client{"eu-west-1": west}
+
+			out, err := captureStdout(t, func() error {
+				return test.cmd.Run(context.Background(), providerAws, testLog)
+			})
+			checkError(t, err, test.err)
+			if err != nil {
+				return
+			}
+
+			machines := []aws.Machine{}
+			if err := json.Unmarshal([]byte(out), &machines); err != nil {
+				t.Fatalf("invalid json %q: %v", out, err)
+			}
+
+			ids := []string{}
+			for _, machine := range machines {
+				ids = append(ids, machine.ID)
+			}
+
+			if strings.Join(ids, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("expected machines %v, got %v", test.expected, ids)
+			}
+		})
+	}
+}
+
+func TestListCmdFormats(t *testing.T) {
+	ec2Client := fake.NewDefaultEC2()
+	providerAws := newTestProvider(t, ec2Client)
+	if err := (&CreateCmd{}).Run(context.Background(), providerAws, testMachine(providerAws), testLog); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		output   string
+		expected []string
+	}{
+		{
+			output:   "table",
+			expected: []string{"MACHINE", "COST/MONTH (US-EAST-1 EST.)", testMachineID, "c5.xlarge", "us-east-1a", "40GB", "$128.10"},
+		},
+		{
+			output:   "csv",
+			expected: []string{"id,instance_id,region", "monthly_cost_usd_us_east_1_estimate", testMachineID, "c5.xlarge", "40GB", "128.10"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.output, func(t *testing.T) {
+			out, err := captureStdout(t, func() error {
+				return (&ListCmd{Output: test.output}).Run(context.Background(), providerAws, testLog)
+			})
+			checkError(t, err, "")
+
+			for _, expected := range test.expected {
+				if !strings.Contains(out, expected) {
+					t.Errorf("expected %q in output:\n%s", expected, out)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/loft-sh/devpod/pkg/provider"
+	"github.com/loft-sh/devpod/pkg/ssh"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// consoleTailLines is the number of console lines printed when an instance
+// does not become ready
+const consoleTailLines = 50
+
+// consoleTailTimeout bounds fetching the console output after an instance
+// did not become ready
+var consoleTailTimeout = 10 * time.Second
+
+// consolePollInterval is the time between fetches of the console output
+// with --follow
+var consolePollInterval = 10 * time.Second
+
+// LogsCmd holds the cmd flags
+type LogsCmd struct {
+	Follow    bool
+	CloudInit bool
+}
+
+// NewLogsCmd defines a command
+func NewLogsCmd() *cobra.Command {
+	cmd := &LogsCmd{}
+	logsCmd := &cobra.Command{
+		Use:   "logs",
+		Short: "Print the console output of an instance",
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			awsProvider, err := aws.NewProvider(cobraCmd.Context(), log.Default)
+			if err != nil {
+				return err
+			}
+
+			return cmd.Run(
+				cobraCmd.Context(),
+				awsProvider,
+				provider.FromEnvironment(),
+				log.Default,
+			)
+		},
+	}
+
+	logsCmd.Flags().BoolVarP(&cmd.Follow, "follow", "f", false, "Keep printing new output")
+	logsCmd.Flags().BoolVar(&cmd.CloudInit, "cloud-init", false, "Print the cloud-init output over SSH instead of the console output")
+
+	return logsCmd
+}
+
+// Run runs the command logic
+func (cmd *LogsCmd) Run(
+	ctx context.Context,
+	providerAws *aws.AwsProvider,
+	machine *provider.Machine,
+	logs log.Logger,
+) error {
+	// stdout carries the logs, so only log to stderr
+	logs = logs.ErrorStreamOnly()
+
+	instances, err := aws.GetDevpodInstance(ctx, providerAws.EC2, providerAws.Config.MachineID)
+	if err != nil {
+		return err
+	} else if len(instances.Reservations) == 0 {
+		return errors.Errorf("No devpod instance %s found", providerAws.Config.MachineID)
+	}
+
+	instance := instances.Reservations[0].Instances[0]
+	if cmd.CloudInit {
+		return cmd.printCloudInitOutput(ctx, providerAws, instance, os.Stdout, logs)
+	}
+
+	return cmd.printConsoleOutput(ctx, providerAws.EC2, *instance.InstanceId, os.Stdout)
+}
+
+func (cmd *LogsCmd) printConsoleOutput(ctx context.Context, svc aws.EC2Client, instanceID string, out io.Writer) error {
+	printed := ""
+	for {
+		output, err := aws.GetConsoleOutput(ctx, svc, instanceID)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprint(out, newConsoleOutput(printed, output))
+		if err != nil {
+			return err
+		}
+		if output != "" {
+			printed = output
+		}
+
+		if !cmd.Follow {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(consolePollInterval):
+		}
+	}
+}
+
+func (cmd *LogsCmd) printCloudInitOutput(
+	ctx context.Context,
+	providerAws *aws.AwsProvider,
+	instance types.Instance,
+	out io.Writer,
+	logs log.Logger,
+) error {
+	if instance.State == nil || instance.State.Name != types.InstanceStateNameRunning {
+		return errors.Errorf("Instance %s is not running", *instance.InstanceId)
+	}
+
+	client, closeClient, err := connectInstance(ctx, providerAws, instance, logs)
+	if err != nil {
+		printConsoleTail(providerAws.EC2, *instance.InstanceId, logs)
+		return err
+	}
+	defer closeClient()
+
+	command := "sudo cat " + aws.CloudInitOutputLog
+	if cmd.Follow {
+		command = "sudo tail -n +1 -f " + aws.CloudInitOutputLog
+	}
+
+	return ssh.Run(ctx, client, command, nil, out, os.Stderr)
+}
+
+// newConsoleOutput returns the part of output that was not printed yet. The
+// console output only contains the latest 64KB, so the printed output may
+// only end with its start instead of being a common prefix.
+func newConsoleOutput(printed, output string) string {
+	if strings.HasPrefix(output, printed) {
+		return output[len(printed):]
+	}
+
+	// the first line of output is also the start of the overlap
+	start := output
+	if newline := strings.IndexByte(start, '\n'); newline >= 0 {
+		start = start[:newline+1]
+	}
+	if len(start) > 256 {
+		start = start[:256]
+	}
+
+	index := strings.LastIndex(printed, start)
+	for index >= 0 {
+		if strings.HasPrefix(output, printed[index:]) {
+			return output[len(printed)-index:]
+		}
+
+		index = strings.LastIndex(printed[:index], start)
+	}
+
+	return output
+}
+
+// printConsoleTail logs the end of the console output, e.g. to show why an
+// instance is not reachable over SSH
+func printConsoleTail(svc aws.EC2Client, instanceID string, logs log.Logger) {
+	// the context of the command may be done already
+	ctx, cancel := context.WithTimeout(context.Background(), consoleTailTimeout)
+	defer cancel()
+
+	output, err := aws.GetConsoleOutput(ctx, svc, instanceID)
+	if err != nil {
+		logs.Debugf("error getting console output of %s: %v", instanceID, err)
+		return
+	} else if strings.TrimSpace(output) == "" {
+		logs.Warnf("Instance %s has no console output yet", instanceID)
+		return
+	}
+
+	logs.Warnf(
+		"Last %d console lines of instance %s:\n%s",
+		consoleTailLines,
+		instanceID,
+		aws.TailLines(output, consoleTailLines),
+	)
+}
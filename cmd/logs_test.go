@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/sirupsen/logrus"
+)
+
+func TestLogsCmd(t *testing.T) {
+	consolePollInterval = time.Millisecond
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		instances []types.Instance
+		follow    bool
+		expected  string
+		err       string
+	}{
+		{
+			name:      "console output",
+			instances: []types.Instance{fake.Instance("i-1", testMachineID, now)},
+			expected:  "[  OK  ] Reached target Cloud-init target.\n",
+		},
+		{
+			name:      "follow until cancelled",
+			instances: []types.Instance{fake.Instance("i-1", testMachineID, now)},
+			follow:    true,
+			expected:  "[  OK  ] Reached target Cloud-init target.\n",
+		},
+		{
+			name: "no instance",
+			err:  "No devpod instance devpod-test found",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec2Client := fake.NewDefaultEC2()
+			ec2Client.Instances = test.instances
+			ec2Client.ConsoleOutputs = map[string]string{"i-1": "[  OK  ] Reached target Cloud-init target.\n"}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			providerAws := newTestProvider(t, ec2Client)
+			out, err := captureStdout(t, func() error {
+				return (&LogsCmd{Follow: test.follow}).Run(ctx, providerAws, testMachine(providerAws), testLog)
+			})
+			checkError(t, err, test.err)
+
+			if out != test.expected {
+				t.Errorf("expected output %q, got %q", test.expected, out)
+			}
+		})
+	}
+}
+
+func TestNewConsoleOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		printed  string
+		output   string
+		expected string
+	}{
+		{
+			name:     "nothing printed",
+			output:   "a\nb\n",
+			expected: "a\nb\n",
+		},
+		{
+			name:     "appended output",
+			printed:  "a\nb\n",
+			output:   "a\nb\nc\n",
+			expected: "c\n",
+		},
+		{
+			name:     "truncated start",
+			printed:  "a\nb\n",
+			output:   "b\nc\n",
+			expected: "c\n",
+		},
+		{
+			name:     "unchanged",
+			printed:  "a\nb\n",
+			output:   "a\nb\n",
+			expected: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := newConsoleOutput(test.printed, test.output); actual != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestPrintConsoleTail(t *testing.T) {
+	ec2Client := fake.NewDefaultEC2()
+	ec2Client.Instances = []types.Instance{fake.Instance("i-1", testMachineID, time.Now())}
+
+	lines := []string{}
+	for i := 0; i < 100; i++ {
+		lines = append(lines, "line")
+	}
+	lines = append(lines, "useradd: group 'sudo' does not exist")
+	ec2Client.ConsoleOutputs = map[string]string{"i-1": strings.Join(lines, "\n")}
+
+	out := &bytes.Buffer{}
+	printConsoleTail(ec2Client, "i-1", log.NewStreamLogger(out, out, logrus.InfoLevel))
+
+	if !strings.Contains(out.String(), "useradd: group 'sudo' does not exist") {
+		t.Errorf("expected the console tail, got %q", out.String())
+	}
+	if strings.Count(out.String(), "line") >= 100 {
+		t.Errorf("expected only the last %d lines, got %q", consoleTailLines, out.String())
+	}
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/spf13/cobra"
+)
+
+// ReapCmd holds the cmd flags
+type ReapCmd struct {
+	Regions    []string
+	AllRegions bool
+	DryRun     bool
+}
+
+// NewReapCmd defines a command
+func NewReapCmd() *cobra.Command {
+	cmd := &ReapCmd{}
+	reapCmd := &cobra.Command{
+		Use:   "reap",
+		Short: "Enforce the stop schedules and ttls of all devpod instances",
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			providerAws, err := newAccountProvider(cobraCmd.Context())
+			if err != nil {
+				return err
+			}
+
+			return cmd.Run(cobraCmd.Context(), providerAws, log.Default)
+		},
+	}
+
+	reapCmd.Flags().StringSliceVar(&cmd.Regions, "region", nil, "Regions to enforce, defaults to the configured region")
+	reapCmd.Flags().BoolVar(&cmd.AllRegions, "all-regions", false, "Enforce all regions enabled for the account")
+	reapCmd.Flags().BoolVar(&cmd.DryRun, "dry-run", false, "Only check the permissions to stop or terminate the instances")
+
+	return reapCmd
+}
+
+// Run runs the command logic
+func (cmd *ReapCmd) Run(
+	ctx context.Context,
+	providerAws *aws.AwsProvider,
+	logs log.Logger,
+) error {
+	regions, err := getRegions(ctx, providerAws, cmd.Regions, cmd.AllRegions)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	total, failed := 0, 0
+	for _, region := range regions {
+		svc := providerAws.EC2ForRegion(region)
+
+		actions, err := aws.FindReapActions(ctx, svc, region, now, logs)
+		if err != nil {
+			return fmt.Errorf("find instances to reap in %s: %w", region, err)
+		}
+
+		for _, action := range actions {
+			total++
+			logs.Infof("%s %s (%s) in %s: %s", action.Action, action.InstanceID, action.MachineID, region, action.Reason)
+
+			err := aws.ApplyReapAction(ctx, svc, action, cmd.DryRun)
+			if err != nil {
+				failed++
+				logs.Errorf("Failed to %s %s: %v", action.Action, action.InstanceID, err)
+				continue
+			}
+
+			if cmd.DryRun {
+				logs.Donef("Would %s %s", action.Action, action.InstanceID)
+			} else {
+				logs.Donef("Requested %s of %s", action.Action, action.InstanceID)
+			}
+		}
+	}
+
+	if total == 0 {
+		logs.Info("No instances to stop or terminate")
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to reap %d of %d instances", failed, total)
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+	"github.com/loft-sh/devpod-provider-aws/pkg/options"
+)
+
+func TestReapCmd(t *testing.T) {
+	now := time.Now()
+
+	schedule, err := options.ParseSchedule("0 20 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		state     types.InstanceStateName
+		age       time.Duration
+		keepAlive string
+		dryRun    bool
+		errors    map[string]error
+		expected  types.InstanceStateName
+		err       string
+	}{
+		{
+			name:     "schedule due",
+			state:    types.InstanceStateNameRunning,
+			age:      25 * time.Hour,
+			expected: types.InstanceStateNameStopped,
+		},
+		{
+			name:     "schedule not due",
+			state:    types.InstanceStateNameRunning,
+			expected: types.InstanceStateNameRunning,
+		},
+		{
+			name:     "ttl expired",
+			state:    types.InstanceStateNameStopped,
+			age:      8 * 24 * time.Hour,
+			expected: types.InstanceStateNameTerminated,
+		},
+		{
+			name:     "ttl not expired",
+			state:    types.InstanceStateNameStopped,
+			age:      6 * 24 * time.Hour,
+			expected: types.InstanceStateNameStopped,
+		},
+		{
+			name:      "keep alive",
+			state:     types.InstanceStateNameStopped,
+			age:       8 * 24 * time.Hour,
+			keepAlive: "true",
+			expected:  types.InstanceStateNameStopped,
+		},
+		{
+			name:      "keep alive expired",
+			state:     types.InstanceStateNameStopped,
+			age:       8 * 24 * time.Hour,
+			keepAlive: now.Add(-time.Hour).UTC().Format(time.RFC3339),
+			expected:  types.InstanceStateNameTerminated,
+		},
+		{
+			name:     "dry run",
+			state:    types.InstanceStateNameRunning,
+			age:      25 * time.Hour,
+			dryRun:   true,
+			expected: types.InstanceStateNameRunning,
+		},
+		{
+			name:     "stop fails",
+			state:    types.InstanceStateNameRunning,
+			age:      25 * time.Hour,
+			errors:   map[string]error{"StopInstances": fake.APIError("UnauthorizedOperation", "You are not authorized to perform this operation.")},
+			expected: types.InstanceStateNameRunning,
+			err:      "failed to reap 1 of 1 instances",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec2Client := fake.NewDefaultEC2()
+			ec2Client.Now = func() time.Time { return now.Add(-test.age) }
+
+			providerAws := newTestProvider(t, ec2Client)
+			providerAws.Config.StopSchedule = schedule
+			providerAws.Config.TTL = 7 * 24 * time.Hour
+
+			err := (&CreateCmd{}).Run(context.Background(), providerAws, testMachine(providerAws), testLog)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			instanceID := awsSDK.ToString(ec2Client.Instances[0].InstanceId)
+			if test.state == types.InstanceStateNameStopped {
+				err := aws.Stop(context.Background(), ec2Client, instanceID)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if test.keepAlive != "" {
+				ec2Client.Instances[0].Tags = append(ec2Client.Instances[0].Tags, types.Tag{
+					Key:   awsSDK.String(aws.TagKeyKeepAlive),
+					Value: awsSDK.String(test.keepAlive),
+				})
+			}
+
+			for name, err := range test.errors {
+				ec2Client.Errors[name] = err
+			}
+
+			err = (&ReapCmd{DryRun: test.dryRun}).Run(context.Background(), providerAws, testLog)
+			checkError(t, err, test.err)
+
+			instance, _ := ec2Client.Instance(instanceID)
+			if instance.State.Name != test.expected {
+				t.Errorf("expected state %s, got %s", test.expected, instance.State.Name)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/loft-sh/devpod/pkg/provider"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// ResizeCmd holds the cmd flags
+type ResizeCmd struct {
+	InstanceType string
+}
+
+// NewResizeCmd defines a command
+func NewResizeCmd() *cobra.Command {
+	cmd := &ResizeCmd{}
+	resizeCmd := &cobra.Command{
+		Use:   "resize",
+		Short: "Change the instance type of an instance",
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			awsProvider, err := aws.NewProvider(cobraCmd.Context(), log.Default)
+			if err != nil {
+				return err
+			}
+
+			return cmd.Run(
+				cobraCmd.Context(),
+				awsProvider,
+				provider.FromEnvironment(),
+				log.Default,
+			)
+		},
+	}
+
+	resizeCmd.Flags().StringVar(&cmd.InstanceType, "instance-type", "", "The instance type to change to, defaults to AWS_INSTANCE_TYPE")
+
+	return resizeCmd
+}
+
+// Run runs the command logic
+func (cmd *ResizeCmd) Run(
+	ctx context.Context,
+	providerAws *aws.AwsProvider,
+	machine *provider.Machine,
+	logs log.Logger,
+) error {
+	instanceType := cmd.InstanceType
+	if instanceType == "" {
+		instanceType = providerAws.Config.MachineType
+	}
+
+	instances, err := aws.GetDevpodInstance(ctx, providerAws.EC2, providerAws.Config.MachineID)
+	if err != nil {
+		return err
+	}
+
+	if len(instances.Reservations) == 0 {
+		return errors.Errorf("No instance %s found", providerAws.Config.MachineID)
+	}
+
+	instance := instances.Reservations[0].Instances[0]
+	if string(instance.InstanceType) == instanceType {
+		logs.Infof("Instance is already of type %s", instanceType)
+		return nil
+	}
+
+	// a stopped instance stays stopped
+	start := instance.State != nil && instance.State.Name != types.InstanceStateNameStopped
+
+	err = aws.Resize(ctx, providerAws.EC2, instance, instanceType, start, logs)
+	if err != nil {
+		return err
+	}
+
+	logs.Donef("Changed instance type to %s", instanceType)
+
+	return nil
+}
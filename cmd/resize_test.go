@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+)
+
+// resizableInstance returns an instance of the given type launched from the
+// x86 Ubuntu AMI of the default fake
+func resizableInstance(instanceType types.InstanceType, state types.InstanceStateName) types.Instance {
+	instance := withState(fake.Instance("i-1", testMachineID, time.Now()), state)
+	instance.ImageId = awsSDK.String("ami-ubuntu-x86")
+	instance.InstanceType = instanceType
+	instance.EnaSupport = awsSDK.Bool(true)
+
+	return instance
+}
+
+func TestResizeCmd(t *testing.T) {
+	tests := []struct {
+		name          string
+		instance      types.Instance
+		instanceType  string
+		unavailable   []string
+		expectedType  types.InstanceType
+		expectedState types.InstanceStateName
+		err           string
+	}{
+		{
+			name:          "running instance",
+			instance:      resizableInstance(types.InstanceTypeC5Xlarge, types.InstanceStateNameRunning),
+			instanceType:  "c5.4xlarge",
+			expectedType:  types.InstanceTypeC54xlarge,
+			expectedState: types.InstanceStateNameRunning,
+		},
+		{
+			name:          "stopped instance stays stopped",
+			instance:      resizableInstance(types.InstanceTypeC5Xlarge, types.InstanceStateNameStopped),
+			instanceType:  "c5.4xlarge",
+			expectedType:  types.InstanceTypeC54xlarge,
+			expectedState: types.InstanceStateNameStopped,
+		},
+		{
+			name:          "same type",
+			instance:      resizableInstance(types.InstanceTypeC5Xlarge, types.InstanceStateNameRunning),
+			instanceType:  "c5.xlarge",
+			expectedType:  types.InstanceTypeC5Xlarge,
+			expectedState: types.InstanceStateNameRunning,
+		},
+		{
+			name:          "architecture mismatch",
+			instance:      resizableInstance(types.InstanceTypeC5Xlarge, types.InstanceStateNameRunning),
+			instanceType:  "m6g.large",
+			expectedType:  types.InstanceTypeC5Xlarge,
+			expectedState: types.InstanceStateNameRunning,
+			err:           "m6g.large does not support the x86_64 architecture",
+		},
+		{
+			name: "ena required",
+			instance: func() types.Instance {
+				instance := resizableInstance(types.InstanceTypeT2Micro, types.InstanceStateNameStopped)
+				instance.EnaSupport = nil
+				return instance
+			}(),
+			instanceType:  "c5.4xlarge",
+			expectedType:  types.InstanceTypeT2Micro,
+			expectedState: types.InstanceStateNameStopped,
+			err:           "c5.4xlarge requires ENA",
+		},
+		{
+			name:          "unknown type",
+			instance:      resizableInstance(types.InstanceTypeC5Xlarge, types.InstanceStateNameRunning),
+			instanceType:  "c5.huge",
+			expectedType:  types.InstanceTypeC5Xlarge,
+			expectedState: types.InstanceStateNameRunning,
+			err:           "InvalidInstanceType",
+		},
+		{
+			name:          "rollback on capacity",
+			instance:      resizableInstance(types.InstanceTypeC5Xlarge, types.InstanceStateNameRunning),
+			instanceType:  "c5.4xlarge",
+			unavailable:   []string{"c5.4xlarge"},
+			expectedType:  types.InstanceTypeC5Xlarge,
+			expectedState: types.InstanceStateNameRunning,
+			err:           "no capacity to start as c5.4xlarge, rolled back to c5.xlarge",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec2Client := fake.NewDefaultEC2()
+			ec2Client.Instances = []types.Instance{test.instance}
+			ec2Client.UnavailableInstanceTypes = test.unavailable
+
+			providerAws := newTestProvider(t, ec2Client)
+			err := (&ResizeCmd{InstanceType: test.instanceType}).Run(context.Background(), providerAws, testMachine(providerAws), testLog)
+			checkError(t, err, test.err)
+
+			instance, _ := ec2Client.Instance("i-1")
+			if instance.InstanceType != test.expectedType {
+				t.Errorf("expected type %s, got %s", test.expectedType, instance.InstanceType)
+			}
+
+			if instance.State.Name != test.expectedState {
+				t.Errorf("expected state %s, got %s", test.expectedState, instance.State.Name)
+			}
+		})
+	}
+}
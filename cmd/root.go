@@ -1,16 +1,29 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws"
+	"github.com/loft-sh/devpod-provider-aws/pkg/options"
 	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/ssh"
 )
 
 // NewRootCmd returns a new root command
 func NewRootCmd() *cobra.Command {
+	var (
+		timeout       time.Duration
+		cancelTimeout context.CancelFunc
+	)
+
 	awsCmd := &cobra.Command{
 		Use:           "devpod-provider-aws",
 		Short:         "aws Provider commands",
@@ -20,21 +33,59 @@ func NewRootCmd() *cobra.Command {
 		PersistentPreRunE: func(cobraCmd *cobra.Command, args []string) error {
 			log.Default.MakeRaw()
 
+			if !cobraCmd.Flags().Changed("timeout") {
+				var err error
+				timeout, err = options.ParseTimeout(os.Getenv(options.AWS_PROVIDER_TIMEOUT))
+				if err != nil {
+					return fmt.Errorf("parse %s: %w", options.AWS_PROVIDER_TIMEOUT, err)
+				}
+			}
+
+			if timeout > 0 {
+				var ctx context.Context
+				ctx, cancelTimeout = context.WithTimeout(cobraCmd.Context(), timeout)
+				cobraCmd.SetContext(ctx)
+			}
+
 			return nil
 		},
+		PersistentPostRun: func(cobraCmd *cobra.Command, args []string) {
+			if cancelTimeout != nil {
+				cancelTimeout()
+			}
+		},
 	}
 
+	awsCmd.PersistentFlags().DurationVar(
+		&timeout,
+		"timeout",
+		0,
+		"Maximum duration of the command, e.g. 30m, 0 means no timeout. Defaults to "+options.AWS_PROVIDER_TIMEOUT,
+	)
+
 	return awsCmd
 }
 
+// exitCodeInterrupted is the exit code of a command cancelled by a signal,
+// as set by shells for SIGINT
+const exitCodeInterrupted = 130
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	// build the root command
 	rootCmd := BuildRoot()
 
+	// SIGINT and SIGTERM cancel the command, so it can clean up. A second
+	// signal terminates it immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
 	// execute command
-	err := rootCmd.Execute()
+	err := rootCmd.ExecuteContext(ctx)
 	if err != nil {
 		if exitErr, ok := err.(*ssh.ExitError); ok {
 			os.Exit(exitErr.ExitStatus())
@@ -48,7 +99,17 @@ func Execute() {
 			os.Exit(exitErr.ExitCode())
 		}
 
-		log.Default.Fatal(err)
+		if errors.Is(ctx.Err(), context.Canceled) {
+			log.Default.Error(errors.Wrap(err, "interrupted"))
+			os.Exit(exitCodeInterrupted)
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			err = errors.Wrap(err, "timed out, see --timeout")
+		}
+
+		// common failures are rendered with a hint and a distinct exit code
+		err = aws.ClassifyError(aws.DecodeAuthorizationError(context.Background(), err))
+		log.Default.Error(err)
+		os.Exit(aws.ExitCode(err))
 	}
 }
 
@@ -62,7 +123,13 @@ func BuildRoot() *cobra.Command {
 	rootCmd.AddCommand(NewCommandCmd())
 	rootCmd.AddCommand(NewStartCmd())
 	rootCmd.AddCommand(NewStopCmd())
+	rootCmd.AddCommand(NewSelfStopCmd())
 	rootCmd.AddCommand(NewStatusCmd())
+	rootCmd.AddCommand(NewResizeCmd())
+	rootCmd.AddCommand(NewLogsCmd())
+	rootCmd.AddCommand(NewListCmd())
+	rootCmd.AddCommand(NewGcCmd())
+	rootCmd.AddCommand(NewReapCmd())
 
 	return rootCmd
 }
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws"
+	"github.com/loft-sh/devpod-provider-aws/pkg/options"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/spf13/cobra"
+)
+
+// SelfStopCmd holds the cmd flags
+type SelfStopCmd struct{}
+
+// NewSelfStopCmd defines a command
+func NewSelfStopCmd() *cobra.Command {
+	cmd := &SelfStopCmd{}
+	selfStopCmd := &cobra.Command{
+		Use:   "self-stop",
+		Short: "Stop the instance the command runs on",
+		Long: `Stops the instance the command runs on, e.g. from the shutdown hook of the
+devpod agent. The instance ID and region are read from the instance metadata
+service and the instance is stopped with the credentials of its instance role,
+so no provider options are needed.`,
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			ctx := cobraCmd.Context()
+
+			// the provider options are not set on the VM
+			cfg, err := aws.NewAWSConfig(ctx, options.Retry{})
+			if err != nil {
+				return err
+			}
+
+			return cmd.Run(
+				ctx,
+				imds.NewFromConfig(cfg),
+				func(region string) aws.EC2Client {
+					regionalCfg := cfg.Copy()
+					regionalCfg.Region = region
+					return ec2.NewFromConfig(regionalCfg)
+				},
+				log.Default,
+			)
+		},
+	}
+
+	return selfStopCmd
+}
+
+// Run runs the command logic
+func (cmd *SelfStopCmd) Run(
+	ctx context.Context,
+	metadata aws.IMDSClient,
+	newEC2 func(region string) aws.EC2Client,
+	logs log.Logger,
+) error {
+	instanceID, region, err := aws.GetSelf(ctx, metadata)
+	if err != nil {
+		return err
+	}
+
+	logs.Debugf("running on instance %s in %s", instanceID, region)
+
+	return aws.SelfStop(ctx, newEC2(region), instanceID, logs)
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+)
+
+func TestSelfStopCmd(t *testing.T) {
+	hibernating := fake.Instance("i-1", testMachineID, time.Now())
+	hibernating.Tags = append(hibernating.Tags, types.Tag{Key: awsSDK.String(aws.TagKeyStopAction), Value: awsSDK.String("hibernate")})
+
+	tests := []struct {
+		name     string
+		instance types.Instance
+		imdsErr  error
+		err      string
+	}{
+		{
+			name:     "running instance",
+			instance: fake.Instance("i-1", testMachineID, time.Now()),
+		},
+		{
+			name:     "stopped instance",
+			instance: withState(fake.Instance("i-1", testMachineID, time.Now()), types.InstanceStateNameStopped),
+		},
+		{
+			name:     "hibernation not configured",
+			instance: hibernating,
+		},
+		{
+			name:     "terminated instance",
+			instance: withState(fake.Instance("i-1", testMachineID, time.Now()), types.InstanceStateNameTerminated),
+			err:      "instance i-1 is terminated",
+		},
+		{
+			name:     "not on EC2",
+			instance: fake.Instance("i-1", testMachineID, time.Now()),
+			imdsErr:  fake.APIError("AccessDenied", "not on EC2"),
+			err:      "get instance identity document",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec2Client := fake.NewDefaultEC2()
+			ec2Client.Instances = []types.Instance{test.instance}
+
+			metadata := fake.NewIMDS("i-1", "us-east-1")
+			if test.imdsErr != nil {
+				metadata.Errors["GetInstanceIdentityDocument"] = test.imdsErr
+			}
+
+			region := ""
+			err := (&SelfStopCmd{}).Run(context.Background(), metadata, func(r string) aws.EC2Client {
+				region = r
+				return ec2Client
+			}, testLog)
+			checkError(t, err, test.err)
+			if err != nil {
+				return
+			}
+
+			if region != "us-east-1" {
+				t.Errorf("expected region us-east-1, got %q", region)
+			}
+
+			instance, _ := ec2Client.Instance("i-1")
+			if instance.State.Name != types.InstanceStateNameStopped {
+				t.Errorf("expected instance to be stopped, got %s", instance.State.Name)
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package cmd
 import (
 	"context"
 
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/loft-sh/devpod-provider-aws/pkg/aws"
 	"github.com/loft-sh/devpod/pkg/log"
 	"github.com/loft-sh/devpod/pkg/provider"
@@ -11,7 +13,9 @@ import (
 )
 
 // StartCmd holds the cmd flags
-type StartCmd struct{}
+type StartCmd struct {
+	NoWait bool
+}
 
 // NewStartCmd defines a command
 func NewStartCmd() *cobra.Command {
@@ -19,14 +23,14 @@ func NewStartCmd() *cobra.Command {
 	startCmd := &cobra.Command{
 		Use:   "start",
 		Short: "Start an instance",
-		RunE: func(_ *cobra.Command, args []string) error {
-			awsProvider, err := aws.NewProvider(context.Background(), log.Default)
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			awsProvider, err := aws.NewProvider(cobraCmd.Context(), log.Default)
 			if err != nil {
 				return err
 			}
 
 			return cmd.Run(
-				context.Background(),
+				cobraCmd.Context(),
 				awsProvider,
 				provider.FromEnvironment(),
 				log.Default,
@@ -34,6 +38,8 @@ func NewStartCmd() *cobra.Command {
 		},
 	}
 
+	startCmd.Flags().BoolVar(&cmd.NoWait, "no-wait", false, "Return without waiting for the instance to be running")
+
 	return startCmd
 }
 
@@ -44,24 +50,125 @@ func (cmd *StartCmd) Run(
 	machine *provider.Machine,
 	logs log.Logger,
 ) error {
-	instances, err := aws.GetDevpodStoppedInstance(
+	err := aws.ReconcileDuplicateInstances(ctx, providerAws, logs)
+	if err != nil {
+		return err
+	}
+
+	instances, err := aws.GetDevpodInstance(
 		ctx,
-		providerAws.AwsConfig,
+		providerAws.EC2,
 		providerAws.Config.MachineID,
 	)
 	if err != nil {
 		return err
+	} else if len(instances.Reservations) == 0 {
+		return errors.Errorf("No devpod instance %s found", providerAws.Config.MachineID)
 	}
 
-	if len(instances.Reservations) > 0 {
-		targetID := instances.Reservations[0].Instances[0].InstanceId
+	instance := instances.Reservations[0].Instances[0]
+	instanceID := *instance.InstanceId
 
-		err = aws.Start(ctx, providerAws.AwsConfig, *targetID)
+	// reconcile the metadata options with AWS_IMDS_*, they take effect
+	// immediately
+	if instance.State.Name != types.InstanceStateNameShuttingDown && instance.State.Name != types.InstanceStateNameTerminated {
+		err := aws.ReconcileMetadataOptions(ctx, providerAws.EC2, instance, providerAws.Config, logs)
+		if err != nil {
+			logs.Warnf("Unable to change the metadata options: %v", err)
+		}
+	}
+
+	switch instance.State.Name {
+	case types.InstanceStateNameRunning:
+		logs.Infof("Instance %s is already running", instanceID)
+		return nil
+	case types.InstanceStateNamePending:
+		return cmd.waitForRunning(ctx, providerAws, instanceID, logs)
+	case types.InstanceStateNameStopping:
+		// an instance can only be started once it is stopped
+		logs.Infof("Waiting for instance %s to stop", instanceID)
+		err := aws.WaitForInstanceState(ctx, providerAws.EC2, instanceID, types.InstanceStateNameStopped)
 		if err != nil {
 			return err
 		}
-	} else {
-		return errors.Errorf("No stopped instance %s found", providerAws.Config.MachineID)
+		instance.State.Name = types.InstanceStateNameStopped
+	case types.InstanceStateNameStopped:
+	default:
+		return errors.Errorf("Instance %s is %s and cannot be started", instanceID, instance.State.Name)
+	}
+
+	// grow the root volume to AWS_DISK_SIZE, the filesystem is grown by
+	// the next command
+	reconcileDiskSize(ctx, providerAws, instance, logs)
+
+	// reconcile the instance type with AWS_INSTANCE_TYPE
+	if instanceType := providerAws.Config.MachineType; instanceType != "" && string(instance.InstanceType) != instanceType {
+		err := aws.Resize(ctx, providerAws.EC2, instance, instanceType, true, logs)
+
+		var rollback *aws.ResizeRollbackError
+		switch {
+		case err == nil:
+			return cmd.waitForRunning(ctx, providerAws, instanceID, logs)
+		case errors.As(err, &rollback):
+			logs.Warn(err)
+			return cmd.waitForRunning(ctx, providerAws, instanceID, logs)
+		case errors.Is(err, aws.ErrIncompatibleInstanceType):
+			logs.Warnf("Keeping instance type %s: %v", instance.InstanceType, err)
+		default:
+			// the instance is still usable if it was left stopped as its
+			// previous type, e.g. if changing the type failed
+			if !isStoppedAs(ctx, providerAws, instanceID, instance.InstanceType) {
+				return err
+			}
+
+			logs.Warnf("Keeping instance type %s, unable to change it to %s: %v", instance.InstanceType, instanceType, err)
+		}
+	}
+
+	err = aws.Start(ctx, providerAws.EC2, instanceID)
+	if err != nil {
+		return err
+	}
+
+	return cmd.waitForRunning(ctx, providerAws, instanceID, logs)
+}
+
+// isStoppedAs reports whether the instance is stopped and has the given
+// instance type
+func isStoppedAs(
+	ctx context.Context,
+	providerAws *aws.AwsProvider,
+	instanceID string,
+	instanceType types.InstanceType,
+) bool {
+	result, err := providerAws.EC2.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	})
+	if err != nil || len(result.Reservations) == 0 || len(result.Reservations[0].Instances) == 0 {
+		return false
+	}
+
+	instance := result.Reservations[0].Instances[0]
+
+	return instance.State != nil && instance.State.Name == types.InstanceStateNameStopped && instance.InstanceType == instanceType
+}
+
+func (cmd *StartCmd) waitForRunning(
+	ctx context.Context,
+	providerAws *aws.AwsProvider,
+	instanceID string,
+	logs log.Logger,
+) error {
+	if cmd.NoWait {
+		return nil
+	}
+
+	logs.Infof("Waiting for instance %s to be running", instanceID)
+
+	err := aws.WaitForInstanceState(ctx, providerAws.EC2, instanceID, types.InstanceStateNameRunning)
+	if err != nil {
+		printConsoleTail(providerAws.EC2, instanceID, logs)
+		return err
 	}
 
 	return nil
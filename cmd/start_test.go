@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/sirupsen/logrus"
+)
+
+func TestStartCmd(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name         string
+		instances    []types.Instance
+		machineType  string
+		unavailable  []string
+		errors       map[string]error
+		noWait       bool
+		expected     types.InstanceStateName
+		expectedType types.InstanceType
+		err          string
+	}{
+		{
+			name:      "stopped instance",
+			instances: []types.Instance{withState(fake.Instance("i-1", testMachineID, now), types.InstanceStateNameStopped)},
+			expected:  types.InstanceStateNameRunning,
+		},
+		{
+			name:      "running instance",
+			instances: []types.Instance{fake.Instance("i-1", testMachineID, now)},
+			expected:  types.InstanceStateNameRunning,
+		},
+		{
+			name:      "stopping instance",
+			instances: []types.Instance{withState(fake.Instance("i-1", testMachineID, now), types.InstanceStateNameStopping)},
+			expected:  types.InstanceStateNameRunning,
+		},
+		{
+			name:      "pending instance",
+			instances: []types.Instance{withState(fake.Instance("i-1", testMachineID, now), types.InstanceStateNamePending)},
+			expected:  types.InstanceStateNameRunning,
+		},
+		{
+			name:      "pending instance without waiting",
+			instances: []types.Instance{withState(fake.Instance("i-1", testMachineID, now), types.InstanceStateNamePending)},
+			noWait:    true,
+			expected:  types.InstanceStateNamePending,
+		},
+		{
+			name:      "terminating instance",
+			instances: []types.Instance{withState(fake.Instance("i-1", testMachineID, now), types.InstanceStateNameShuttingDown)},
+			err:       "Instance i-1 is shutting-down and cannot be started",
+		},
+		{
+			name: "no instance",
+			err:  "No devpod instance devpod-test found",
+		},
+		{
+			name:         "reconcile instance type",
+			instances:    []types.Instance{resizableInstance(types.InstanceTypeC5Xlarge, types.InstanceStateNameStopped)},
+			machineType:  "c5.4xlarge",
+			expected:     types.InstanceStateNameRunning,
+			expectedType: types.InstanceTypeC54xlarge,
+		},
+		{
+			name:         "incompatible instance type is kept",
+			instances:    []types.Instance{resizableInstance(types.InstanceTypeC5Xlarge, types.InstanceStateNameStopped)},
+			machineType:  "m6g.large",
+			expected:     types.InstanceStateNameRunning,
+			expectedType: types.InstanceTypeC5Xlarge,
+		},
+		{
+			name:         "no capacity for new instance type",
+			instances:    []types.Instance{resizableInstance(types.InstanceTypeC5Xlarge, types.InstanceStateNameStopped)},
+			machineType:  "c5.4xlarge",
+			unavailable:  []string{"c5.4xlarge"},
+			expected:     types.InstanceStateNameRunning,
+			expectedType: types.InstanceTypeC5Xlarge,
+		},
+		{
+			name:         "changing the instance type fails",
+			instances:    []types.Instance{resizableInstance(types.InstanceTypeC5Xlarge, types.InstanceStateNameStopped)},
+			machineType:  "c5.4xlarge",
+			errors:       map[string]error{"ModifyInstanceAttribute": fake.APIError("RequestLimitExceeded", "Request limit exceeded.")},
+			expected:     types.InstanceStateNameRunning,
+			expectedType: types.InstanceTypeC5Xlarge,
+		},
+		{
+			name:         "instance type cannot be checked",
+			instances:    []types.Instance{resizableInstance(types.InstanceTypeC5Xlarge, types.InstanceStateNameStopped)},
+			machineType:  "c5.4xlarge",
+			errors:       map[string]error{"DescribeInstanceTypes": fake.APIError("RequestLimitExceeded", "Request limit exceeded.")},
+			expected:     types.InstanceStateNameRunning,
+			expectedType: types.InstanceTypeC5Xlarge,
+		},
+		{
+			name:        "starting as the new instance type fails",
+			instances:   []types.Instance{resizableInstance(types.InstanceTypeC5Xlarge, types.InstanceStateNameStopped)},
+			machineType: "c5.4xlarge",
+			errors:      map[string]error{"StartInstances": fake.APIError("InternalError", "An internal error has occurred.")},
+			err:         "InternalError",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec2Client := fake.NewDefaultEC2()
+			ec2Client.Instances = test.instances
+			ec2Client.UnavailableInstanceTypes = test.unavailable
+			for operation, err := range test.errors {
+				ec2Client.Errors[operation] = err
+			}
+
+			providerAws := newTestProvider(t, ec2Client)
+			if test.machineType != "" {
+				providerAws.Config.MachineType = test.machineType
+			}
+
+			err := (&StartCmd{NoWait: test.noWait}).Run(context.Background(), providerAws, testMachine(providerAws), testLog)
+			checkError(t, err, test.err)
+			if err != nil {
+				return
+			}
+
+			instance, _ := ec2Client.Instance("i-1")
+			if instance.State.Name != test.expected {
+				t.Errorf("expected state %s, got %s", test.expected, instance.State.Name)
+			}
+
+			if test.expectedType != "" && instance.InstanceType != test.expectedType {
+				t.Errorf("expected type %s, got %s", test.expectedType, instance.InstanceType)
+			}
+		})
+	}
+}
+
+func TestStartCmdPrintsConsoleTail(t *testing.T) {
+	ec2Client := fake.NewDefaultEC2()
+	ec2Client.Instances = []types.Instance{withState(fake.Instance("i-1", testMachineID, time.Now()), types.InstanceStateNameShuttingDown)}
+	ec2Client.ConsoleOutputs = map[string]string{"i-1": "Kernel panic - not syncing: VFS: Unable to mount root fs"}
+	providerAws := newTestProvider(t, ec2Client)
+
+	out := &bytes.Buffer{}
+	err := (&StartCmd{}).waitForRunning(context.Background(), providerAws, "i-1", log.NewStreamLogger(out, out, logrus.InfoLevel))
+	checkError(t, err, "wait for instance i-1 to be running")
+
+	if !strings.Contains(out.String(), "Unable to mount root fs") {
+		t.Errorf("expected the console tail, got %q", out.String())
+	}
+}
+
+func TestStartCmdGrowsRootVolume(t *testing.T) {
+	instance := withState(fake.Instance("i-1", testMachineID, time.Now()), types.InstanceStateNameStopped)
+	instance.RootDeviceName = awsSDK.String("/dev/sda1")
+	instance.BlockDeviceMappings = []types.InstanceBlockDeviceMapping{
+		{DeviceName: awsSDK.String("/dev/sda1"), Ebs: &types.EbsInstanceBlockDevice{VolumeId: awsSDK.String("vol-1")}},
+	}
+
+	ec2Client := fake.NewDefaultEC2()
+	ec2Client.Instances = []types.Instance{instance}
+	ec2Client.Volumes = []types.Volume{{VolumeId: awsSDK.String("vol-1"), Size: awsSDK.Int32(20)}}
+
+	providerAws := newTestProvider(t, ec2Client)
+	err := (&StartCmd{}).Run(context.Background(), providerAws, testMachine(providerAws), testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size := awsSDK.ToInt32(ec2Client.Volumes[0].Size); size != 40 {
+		t.Errorf("expected root volume size 40, got %d", size)
+	}
+
+	// the filesystem is still to be grown by the next command
+	if aws.IsDiskSizeReconciled(providerAws.Config.MachineFolder, 40) {
+		t.Error("expected disk size not to be reconciled before the filesystem is grown")
+	}
+	if !reconcileDiskSize(context.Background(), providerAws, instance, testLog) {
+		t.Error("expected the next command to grow the filesystem")
+	}
+}
+
+func TestReconcileDiskSize(t *testing.T) {
+	instance := fake.Instance("i-1", testMachineID, time.Now())
+	instance.RootDeviceName = awsSDK.String("/dev/sda1")
+	instance.BlockDeviceMappings = []types.InstanceBlockDeviceMapping{
+		{DeviceName: awsSDK.String("/dev/sda1"), Ebs: &types.EbsInstanceBlockDevice{VolumeId: awsSDK.String("vol-1")}},
+	}
+
+	ec2Client := fake.NewDefaultEC2()
+	ec2Client.Instances = []types.Instance{instance}
+	ec2Client.Volumes = []types.Volume{{VolumeId: awsSDK.String("vol-1"), Size: awsSDK.Int32(40)}}
+
+	// created with the disk size, there is nothing to grow
+	providerAws := newTestProvider(t, ec2Client)
+	if reconcileDiskSize(context.Background(), providerAws, instance, testLog) {
+		t.Error("expected the filesystem not to be grown")
+	}
+	if !aws.IsDiskSizeReconciled(providerAws.Config.MachineFolder, 40) {
+		t.Error("expected disk size 40 to be reconciled")
+	}
+
+	// a volume modified within 6 hours is not a failure and tried again
+	providerAws.Config.DiskSizeGB = 60
+	ec2Client.VolumeModifications = []types.VolumeModification{{
+		VolumeId:          awsSDK.String("vol-1"),
+		ModificationState: types.VolumeModificationStateCompleted,
+		StartTime:         awsSDK.Time(time.Now().Add(-time.Hour)),
+	}}
+	for i := 0; i < 3; i++ {
+		if reconcileDiskSize(context.Background(), providerAws, instance, testLog) {
+			t.Error("expected the filesystem not to be grown")
+		}
+	}
+	if aws.IsDiskSizeReconciled(providerAws.Config.MachineFolder, 60) {
+		t.Error("expected growing to 60GB not to be given up")
+	}
+	ec2Client.VolumeModifications = nil
+
+	// failures are only retried a few times
+	providerAws.Config.DiskSizeGB = 80
+	ec2Client.Errors["ModifyVolume"] = fake.APIError("IncorrectModificationState", "volume is being modified")
+	for i := 0; i < 3; i++ {
+		if reconcileDiskSize(context.Background(), providerAws, instance, testLog) {
+			t.Error("expected the filesystem not to be grown")
+		}
+	}
+	if !aws.IsDiskSizeReconciled(providerAws.Config.MachineFolder, 80) {
+		t.Error("expected growing to 80GB to be given up")
+	}
+	if size := awsSDK.ToInt32(ec2Client.Volumes[0].Size); size != 40 {
+		t.Errorf("expected root volume size 40, got %d", size)
+	}
+}
+
+func TestStartCmdReconcilesMetadataOptions(t *testing.T) {
+	instance := withState(fake.Instance("i-1", testMachineID, time.Now()), types.InstanceStateNameStopped)
+	instance.MetadataOptions = &types.InstanceMetadataOptionsResponse{
+		HttpTokens:              types.HttpTokensStateRequired,
+		HttpPutResponseHopLimit: awsSDK.Int32(1),
+		InstanceMetadataTags:    types.InstanceMetadataTagsStateDisabled,
+	}
+
+	ec2Client := fake.NewDefaultEC2()
+	ec2Client.Instances = []types.Instance{instance}
+
+	providerAws := newTestProvider(t, ec2Client)
+	providerAws.Config.IMDSHopLimit = 2
+	providerAws.Config.IMDSTags = true
+
+	err := (&StartCmd{}).Run(context.Background(), providerAws, testMachine(providerAws), testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, _ = ec2Client.Instance("i-1")
+	if hopLimit := awsSDK.ToInt32(instance.MetadataOptions.HttpPutResponseHopLimit); hopLimit != 2 {
+		t.Errorf("expected hop limit 2, got %d", hopLimit)
+	}
+	if instance.MetadataOptions.InstanceMetadataTags != types.InstanceMetadataTagsStateEnabled {
+		t.Errorf("expected metadata tags to be enabled, got %s", instance.MetadataOptions.InstanceMetadataTags)
+	}
+	if instance.MetadataOptions.HttpTokens != types.HttpTokensStateRequired {
+		t.Errorf("expected tokens to stay required, got %s", instance.MetadataOptions.HttpTokens)
+	}
+}
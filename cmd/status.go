@@ -2,21 +2,51 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
+	awsSDK "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/loft-sh/devpod-provider-aws/pkg/aws"
+	"github.com/loft-sh/devpod/pkg/client"
 	"github.com/loft-sh/devpod/pkg/log"
 	"github.com/loft-sh/devpod/pkg/provider"
 	"github.com/spf13/cobra"
 )
 
+// InstanceStatus is the status of the instance of a machine printed by
+// status --output json
 type InstanceStatus struct {
+	Status            string                           `json:"status"`
+	InstanceID        string                           `json:"instanceId,omitempty"`
+	State             string                           `json:"state,omitempty"`
+	StateReason       string                           `json:"stateReason,omitempty"`
+	SystemStatus      string                           `json:"systemStatus,omitempty"`
+	InstanceStatus    string                           `json:"instanceStatus,omitempty"`
+	Events            []InstanceStatusEvent            `json:"events,omitempty"`
+	InstanceType      string                           `json:"instanceType,omitempty"`
+	AvailabilityZone  string                           `json:"availabilityZone,omitempty"`
+	LaunchTime        *time.Time                       `json:"launchTime,omitempty"`
+	PublicIP          string                           `json:"publicIp,omitempty"`
+	PrivateIP         string                           `json:"privateIp,omitempty"`
 	NetworkInterfaces []InstanceStatusNetworkInterface `json:"networkInterfaces,omitempty"`
-	Status            string                           `json:"status,omitempty"`
+	Spot              *InstanceStatusSpot              `json:"spot,omitempty"`
+	Volumes           []InstanceStatusVolume           `json:"volumes,omitempty"`
+}
+
+// InstanceStatusEvent is an event scheduled for the instance, e.g. a reboot
+// for maintenance
+type InstanceStatusEvent struct {
+	Code        string     `json:"code"`
+	Description string     `json:"description,omitempty"`
+	NotBefore   *time.Time `json:"notBefore,omitempty"`
 }
 
 type InstanceStatusNetworkInterface struct {
+	ID            string                       `json:"id"`
+	PrivateIP     string                       `json:"privateIp,omitempty"`
 	AccessConfigs []InstanceStatusAccessConfig `json:"accessConfigs,omitempty"`
 }
 
@@ -24,8 +54,29 @@ type InstanceStatusAccessConfig struct {
 	NatIP string `json:"natIP,omitempty"`
 }
 
+// InstanceStatusSpot describes the spot request of a spot instance, its
+// status code is e.g. marked-for-stop once the instance is interrupted
+type InstanceStatusSpot struct {
+	RequestID           string `json:"requestId"`
+	InterruptionCode    string `json:"interruptionCode,omitempty"`
+	InterruptionMessage string `json:"interruptionMessage,omitempty"`
+}
+
+type InstanceStatusVolume struct {
+	ID         string `json:"id"`
+	Device     string `json:"device,omitempty"`
+	Type       string `json:"type,omitempty"`
+	SizeGB     int32  `json:"sizeGB"`
+	State      string `json:"state,omitempty"`
+	Encrypted  bool   `json:"encrypted"`
+	IOPS       int32  `json:"iops,omitempty"`
+	Throughput int32  `json:"throughput,omitempty"`
+}
+
 // StatusCmd holds the cmd flags
-type StatusCmd struct{}
+type StatusCmd struct {
+	Output string
+}
 
 // NewStatusCmd defines a command
 func NewStatusCmd() *cobra.Command {
@@ -33,14 +84,14 @@ func NewStatusCmd() *cobra.Command {
 	statusCmd := &cobra.Command{
 		Use:   "status",
 		Short: "Status an instance",
-		RunE: func(_ *cobra.Command, args []string) error {
-			awsProvider, err := aws.NewProvider(context.Background(), log.Default)
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			awsProvider, err := aws.NewProvider(cobraCmd.Context(), log.Default)
 			if err != nil {
 				return err
 			}
 
 			return cmd.Run(
-				context.Background(),
+				cobraCmd.Context(),
 				awsProvider,
 				provider.FromEnvironment(),
 				log.Default,
@@ -48,6 +99,8 @@ func NewStatusCmd() *cobra.Command {
 		},
 	}
 
+	statusCmd.Flags().StringVarP(&cmd.Output, "output", "o", "plain", "Output format, one of plain or json")
+
 	return statusCmd
 }
 
@@ -58,12 +111,133 @@ func (cmd *StatusCmd) Run(
 	machine *provider.Machine,
 	logs log.Logger,
 ) error {
-	status, err := aws.Status(ctx, providerAws.AwsConfig, providerAws.Config.MachineID)
+	if cmd.Output == "" || cmd.Output == "plain" {
+		status, err := aws.Status(ctx, providerAws.EC2, providerAws.Config.MachineID)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprint(os.Stdout, status)
+		return err
+	} else if cmd.Output != "json" {
+		return fmt.Errorf("unsupported output %q, expected plain or json", cmd.Output)
+	}
+
+	// stdout carries the status, so only log to stderr
+	logs = logs.ErrorStreamOnly()
+
+	instances, err := aws.GetDevpodInstance(ctx, providerAws.EC2, providerAws.Config.MachineID)
 	if err != nil {
 		return err
 	}
 
-	_, err = fmt.Fprint(os.Stdout, status)
+	status := &InstanceStatus{Status: string(client.StatusNotFound)}
+	if len(instances.Reservations) > 0 {
+		status = getInstanceStatus(ctx, providerAws.EC2, instances.Reservations[0].Instances[0], logs)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(status)
+}
+
+// getInstanceStatus describes the instance, details that cannot be
+// retrieved are left out
+func getInstanceStatus(
+	ctx context.Context,
+	svc aws.EC2Client,
+	instance types.Instance,
+	logs log.Logger,
+) *InstanceStatus {
+	instanceID := awsSDK.ToString(instance.InstanceId)
+	status := &InstanceStatus{
+		Status:       string(aws.GetClientStatus(instance.State.Name)),
+		InstanceID:   instanceID,
+		State:        string(instance.State.Name),
+		InstanceType: string(instance.InstanceType),
+		LaunchTime:   instance.LaunchTime,
+		PublicIP:     awsSDK.ToString(instance.PublicIpAddress),
+		PrivateIP:    awsSDK.ToString(instance.PrivateIpAddress),
+	}
+
+	if instance.StateReason != nil {
+		status.StateReason = awsSDK.ToString(instance.StateReason.Message)
+	}
+
+	if instance.Placement != nil {
+		status.AvailabilityZone = awsSDK.ToString(instance.Placement.AvailabilityZone)
+	}
+
+	for _, networkInterface := range instance.NetworkInterfaces {
+		statusInterface := InstanceStatusNetworkInterface{
+			ID:        awsSDK.ToString(networkInterface.NetworkInterfaceId),
+			PrivateIP: awsSDK.ToString(networkInterface.PrivateIpAddress),
+		}
+		if networkInterface.Association != nil && networkInterface.Association.PublicIp != nil {
+			statusInterface.AccessConfigs = append(statusInterface.AccessConfigs, InstanceStatusAccessConfig{
+				NatIP: awsSDK.ToString(networkInterface.Association.PublicIp),
+			})
+		}
+
+		status.NetworkInterfaces = append(status.NetworkInterfaces, statusInterface)
+	}
+
+	checks, err := aws.GetInstanceStatusChecks(ctx, svc, instanceID)
+	if err != nil {
+		logs.Warnf("Unable to get the status checks of %s: %v", instanceID, err)
+	} else if checks != nil {
+		if checks.SystemStatus != nil {
+			status.SystemStatus = string(checks.SystemStatus.Status)
+		}
+		if checks.InstanceStatus != nil {
+			status.InstanceStatus = string(checks.InstanceStatus.Status)
+		}
+
+		for _, event := range checks.Events {
+			status.Events = append(status.Events, InstanceStatusEvent{
+				Code:        string(event.Code),
+				Description: awsSDK.ToString(event.Description),
+				NotBefore:   event.NotBefore,
+			})
+		}
+	}
+
+	if requestID := awsSDK.ToString(instance.SpotInstanceRequestId); requestID != "" {
+		status.Spot = &InstanceStatusSpot{RequestID: requestID}
+
+		request, err := aws.GetSpotInstanceRequest(ctx, svc, requestID)
+		if err != nil {
+			logs.Warnf("Unable to get the spot request %s: %v", requestID, err)
+		} else if request != nil && request.Status != nil {
+			status.Spot.InterruptionCode = awsSDK.ToString(request.Status.Code)
+			status.Spot.InterruptionMessage = awsSDK.ToString(request.Status.Message)
+		}
+	}
+
+	volumes, err := aws.GetInstanceVolumes(ctx, svc, instanceID)
+	if err != nil {
+		logs.Warnf("Unable to get the volumes of %s: %v", instanceID, err)
+	}
+
+	for _, volume := range volumes {
+		statusVolume := InstanceStatusVolume{
+			ID:         awsSDK.ToString(volume.VolumeId),
+			Type:       string(volume.VolumeType),
+			SizeGB:     awsSDK.ToInt32(volume.Size),
+			State:      string(volume.State),
+			Encrypted:  awsSDK.ToBool(volume.Encrypted),
+			IOPS:       awsSDK.ToInt32(volume.Iops),
+			Throughput: awsSDK.ToInt32(volume.Throughput),
+		}
+		for _, attachment := range volume.Attachments {
+			if awsSDK.ToString(attachment.InstanceId) == instanceID {
+				statusVolume.Device = awsSDK.ToString(attachment.Device)
+			}
+		}
+
+		status.Volumes = append(status.Volumes, statusVolume)
+	}
 
-	return err
+	return status
 }
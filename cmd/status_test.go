@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+)
+
+func TestStatusCmd(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		instances []types.Instance
+		errors    map[string]error
+		expected  string
+		err       string
+	}{
+		{
+			name:      "running",
+			instances: []types.Instance{fake.Instance("i-1", testMachineID, now)},
+			expected:  "Running",
+		},
+		{
+			name:      "stopped",
+			instances: []types.Instance{withState(fake.Instance("i-1", testMachineID, now), types.InstanceStateNameStopped)},
+			expected:  "Stopped",
+		},
+		{
+			name:      "stopping",
+			instances: []types.Instance{withState(fake.Instance("i-1", testMachineID, now), types.InstanceStateNameStopping)},
+			expected:  "Busy",
+		},
+		{
+			name:     "not found",
+			expected: "NotFound",
+		},
+		{
+			name:   "describe fails",
+			errors: map[string]error{"DescribeInstances": fake.APIError("RequestLimitExceeded", "Request limit exceeded.")},
+			err:    "RequestLimitExceeded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec2Client := fake.NewDefaultEC2()
+			ec2Client.Instances = test.instances
+			for name, err := range test.errors {
+				ec2Client.Errors[name] = err
+			}
+
+			providerAws := newTestProvider(t, ec2Client)
+			out, err := captureStdout(t, func() error {
+				return (&StatusCmd{}).Run(context.Background(), providerAws, testMachine(providerAws), testLog)
+			})
+			checkError(t, err, test.err)
+
+			if out != test.expected {
+				t.Errorf("expected output %q, got %q", test.expected, out)
+			}
+		})
+	}
+}
+
+func TestStatusCmdJSON(t *testing.T) {
+	instance := fake.Instance("i-1", testMachineID, time.Now())
+	instance.PublicIpAddress = awsSDK.String("203.0.113.1")
+	instance.Placement = &types.Placement{AvailabilityZone: awsSDK.String("us-east-1a")}
+	instance.SpotInstanceRequestId = awsSDK.String("sir-1")
+
+	ec2Client := fake.NewDefaultEC2()
+	ec2Client.Instances = []types.Instance{instance}
+	ec2Client.Volumes = []types.Volume{
+		{
+			VolumeId:    awsSDK.String("vol-1"),
+			Size:        awsSDK.Int32(40),
+			VolumeType:  types.VolumeTypeGp3,
+			State:       types.VolumeStateInUse,
+			Attachments: []types.VolumeAttachment{{InstanceId: awsSDK.String("i-1"), Device: awsSDK.String("/dev/sda1")}},
+		},
+	}
+	ec2Client.SpotInstanceRequests = []types.SpotInstanceRequest{
+		{
+			SpotInstanceRequestId: awsSDK.String("sir-1"),
+			Status:                &types.SpotInstanceStatus{Code: awsSDK.String("marked-for-stop"), Message: awsSDK.String("Spot capacity is no longer available")},
+		},
+	}
+
+	providerAws := newTestProvider(t, ec2Client)
+	out, err := captureStdout(t, func() error {
+		return (&StatusCmd{Output: "json"}).Run(context.Background(), providerAws, testMachine(providerAws), testLog)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := &InstanceStatus{}
+	err = json.Unmarshal([]byte(out), status)
+	if err != nil {
+		t.Fatalf("invalid json %q: %v", out, err)
+	}
+
+	if status.Status != "Running" || status.State != "running" || status.InstanceID != "i-1" {
+		t.Errorf("unexpected state %+v", status)
+	}
+	if status.SystemStatus != "ok" || status.InstanceStatus != "ok" {
+		t.Errorf("expected passing status checks, got %q and %q", status.SystemStatus, status.InstanceStatus)
+	}
+	if status.PublicIP != "203.0.113.1" || status.AvailabilityZone != "us-east-1a" {
+		t.Errorf("unexpected network details %+v", status)
+	}
+	if status.Spot == nil || status.Spot.InterruptionCode != "marked-for-stop" {
+		t.Errorf("expected spot interruption, got %+v", status.Spot)
+	}
+	if len(status.Volumes) != 1 || status.Volumes[0].SizeGB != 40 || status.Volumes[0].Device != "/dev/sda1" {
+		t.Errorf("unexpected volumes %+v", status.Volumes)
+	}
+}
+
+func TestStatusCmdJSONNotFound(t *testing.T) {
+	providerAws := newTestProvider(t, fake.NewDefaultEC2())
+	out, err := captureStdout(t, func() error {
+		return (&StatusCmd{Output: "json"}).Run(context.Background(), providerAws, testMachine(providerAws), testLog)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.TrimSpace(out) != "{\n  \"status\": \"NotFound\"\n}" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"context"
 
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/loft-sh/devpod-provider-aws/pkg/aws"
 	"github.com/loft-sh/devpod/pkg/log"
 	"github.com/loft-sh/devpod/pkg/provider"
@@ -11,7 +12,9 @@ import (
 )
 
 // StopCmd holds the cmd flags
-type StopCmd struct{}
+type StopCmd struct {
+	NoWait bool
+}
 
 // NewStopCmd defines a command
 func NewStopCmd() *cobra.Command {
@@ -19,14 +22,14 @@ func NewStopCmd() *cobra.Command {
 	stopCmd := &cobra.Command{
 		Use:   "stop",
 		Short: "Stop an instance",
-		RunE: func(_ *cobra.Command, args []string) error {
-			awsProvider, err := aws.NewProvider(context.Background(), log.Default)
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			awsProvider, err := aws.NewProvider(cobraCmd.Context(), log.Default)
 			if err != nil {
 				return err
 			}
 
 			return cmd.Run(
-				context.Background(),
+				cobraCmd.Context(),
 				awsProvider,
 				provider.FromEnvironment(),
 				log.Default,
@@ -34,6 +37,8 @@ func NewStopCmd() *cobra.Command {
 		},
 	}
 
+	stopCmd.Flags().BoolVar(&cmd.NoWait, "no-wait", false, "Return without waiting for the instance to be stopped")
+
 	return stopCmd
 }
 
@@ -44,25 +49,57 @@ func (cmd *StopCmd) Run(
 	machine *provider.Machine,
 	logs log.Logger,
 ) error {
-	instances, err := aws.GetDevpodRunningInstance(
+	instances, err := aws.GetDevpodInstance(
 		ctx,
-		providerAws.AwsConfig,
+		providerAws.EC2,
 		providerAws.Config.MachineID,
 	)
 	if err != nil {
 		return err
+	} else if len(instances.Reservations) == 0 {
+		return errors.Errorf("No devpod instance %s found", providerAws.Config.MachineID)
 	}
 
-	if len(instances.Reservations) > 0 {
-		targetID := instances.Reservations[0].Instances[0].InstanceId
+	instance := instances.Reservations[0].Instances[0]
+	instanceID := *instance.InstanceId
 
-		err = aws.Stop(ctx, providerAws.AwsConfig, *targetID)
+	switch instance.State.Name {
+	case types.InstanceStateNameStopped:
+		logs.Infof("Instance %s is already stopped", instanceID)
+		return nil
+	case types.InstanceStateNameStopping:
+		return cmd.waitForStopped(ctx, providerAws, instanceID, logs)
+	case types.InstanceStateNamePending:
+		// an instance can only be stopped once it is running
+		logs.Infof("Waiting for instance %s to be running", instanceID)
+		err := aws.WaitForInstanceState(ctx, providerAws.EC2, instanceID, types.InstanceStateNameRunning)
 		if err != nil {
 			return err
 		}
-	} else {
-		return errors.Errorf("No running instance found")
+	case types.InstanceStateNameRunning:
+	default:
+		return errors.Errorf("Instance %s is %s and cannot be stopped", instanceID, instance.State.Name)
+	}
+
+	err = aws.Stop(ctx, providerAws.EC2, instanceID)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return cmd.waitForStopped(ctx, providerAws, instanceID, logs)
+}
+
+func (cmd *StopCmd) waitForStopped(
+	ctx context.Context,
+	providerAws *aws.AwsProvider,
+	instanceID string,
+	logs log.Logger,
+) error {
+	if cmd.NoWait {
+		return nil
+	}
+
+	logs.Infof("Waiting for instance %s to stop", instanceID)
+
+	return aws.WaitForInstanceState(ctx, providerAws.EC2, instanceID, types.InstanceStateNameStopped)
 }
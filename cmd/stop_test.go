@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+)
+
+func TestStopCmd(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		instances []types.Instance
+		noWait    bool
+		expected  types.InstanceStateName
+		err       string
+	}{
+		{
+			name:      "running instance",
+			instances: []types.Instance{fake.Instance("i-1", testMachineID, now)},
+			expected:  types.InstanceStateNameStopped,
+		},
+		{
+			name:      "stopped instance",
+			instances: []types.Instance{withState(fake.Instance("i-1", testMachineID, now), types.InstanceStateNameStopped)},
+			expected:  types.InstanceStateNameStopped,
+		},
+		{
+			name:      "stopping instance",
+			instances: []types.Instance{withState(fake.Instance("i-1", testMachineID, now), types.InstanceStateNameStopping)},
+			expected:  types.InstanceStateNameStopped,
+		},
+		{
+			name:      "stopping instance without waiting",
+			instances: []types.Instance{withState(fake.Instance("i-1", testMachineID, now), types.InstanceStateNameStopping)},
+			noWait:    true,
+			expected:  types.InstanceStateNameStopping,
+		},
+		{
+			name:      "pending instance",
+			instances: []types.Instance{withState(fake.Instance("i-1", testMachineID, now), types.InstanceStateNamePending)},
+			expected:  types.InstanceStateNameStopped,
+		},
+		{
+			name:      "terminating instance",
+			instances: []types.Instance{withState(fake.Instance("i-1", testMachineID, now), types.InstanceStateNameShuttingDown)},
+			err:       "Instance i-1 is shutting-down and cannot be stopped",
+		},
+		{
+			name:      "other machine",
+			instances: []types.Instance{fake.Instance("i-1", "devpod-other", now)},
+			err:       "No devpod instance devpod-test found",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec2Client := fake.NewDefaultEC2()
+			ec2Client.Instances = test.instances
+
+			providerAws := newTestProvider(t, ec2Client)
+			err := (&StopCmd{NoWait: test.noWait}).Run(context.Background(), providerAws, testMachine(providerAws), testLog)
+			checkError(t, err, test.err)
+			if err != nil {
+				return
+			}
+
+			instance, _ := ec2Client.Instance("i-1")
+			if instance.State.Name != test.expected {
+				t.Errorf("expected state %s, got %s", test.expected, instance.State.Name)
+			}
+		})
+	}
+}
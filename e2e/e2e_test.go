@@ -0,0 +1,158 @@
+//go:build e2e
+
+// Package e2e drives the provider subcommands against a local moto server,
+// started e.g. with `docker run --rm -p 5000:5000 motoserver/moto`.
+//
+// Run the suite with `go test -tags e2e ./e2e/...`, MOTO_ENDPOINT overrides
+// the default endpoint http://localhost:5000.
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	awsSDK "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/cmd"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws"
+	"github.com/loft-sh/devpod-provider-aws/pkg/options"
+	"github.com/loft-sh/devpod/pkg/client"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/loft-sh/devpod/pkg/provider"
+)
+
+func TestLifecycle(t *testing.T) {
+	ctx := context.Background()
+
+	endpoint := os.Getenv("MOTO_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "http://localhost:5000"
+	}
+
+	machineFolder := t.TempDir()
+	t.Setenv("AWS_ENDPOINT_URL", endpoint)
+	t.Setenv("AWS_ACCESS_KEY_ID", "testing")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "testing")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(machineFolder, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(machineFolder, "credentials"))
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_INSTANCE_TYPE", "t3.micro")
+	t.Setenv("AWS_DISK_SIZE", "10")
+	t.Setenv("MACHINE_ID", fmt.Sprintf("e2e-%d", time.Now().UnixNano()))
+	t.Setenv("MACHINE_FOLDER", machineFolder)
+
+	cfg, err := aws.NewAWSConfig(ctx, options.Retry{})
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+
+	// fail fast if the server is not running, instead of retrying
+	probeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	images, err := ec2.NewFromConfig(cfg, func(o *ec2.Options) {
+		o.Retryer = awsSDK.NopRetryer{}
+	}).DescribeImages(probeCtx, &ec2.DescribeImagesInput{
+		Owners: []string{"amazon"},
+		Filters: []types.Filter{
+			{
+				Name:   awsSDK.String("architecture"),
+				Values: []string{"x86_64"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("moto server not reachable at %s: %v", endpoint, err)
+	}
+	if len(images.Images) == 0 {
+		t.Fatalf("moto server at %s has no x86_64 images", endpoint)
+	}
+	t.Setenv("AWS_AMI", *images.Images[0].ImageId)
+
+	steps := []struct {
+		name   string
+		run    func(ctx context.Context, providerAws *aws.AwsProvider, machine *provider.Machine) error
+		status client.Status
+	}{
+		{
+			name: "create",
+			run: func(ctx context.Context, providerAws *aws.AwsProvider, machine *provider.Machine) error {
+				return (&cmd.CreateCmd{}).Run(ctx, providerAws, machine, log.Default)
+			},
+			status: client.StatusRunning,
+		},
+		{
+			name: "status",
+			run: func(ctx context.Context, providerAws *aws.AwsProvider, machine *provider.Machine) error {
+				return (&cmd.StatusCmd{}).Run(ctx, providerAws, machine, log.Default)
+			},
+			status: client.StatusRunning,
+		},
+		{
+			name: "stop",
+			run: func(ctx context.Context, providerAws *aws.AwsProvider, machine *provider.Machine) error {
+				return (&cmd.StopCmd{}).Run(ctx, providerAws, machine, log.Default)
+			},
+			status: client.StatusStopped,
+		},
+		{
+			name: "start",
+			run: func(ctx context.Context, providerAws *aws.AwsProvider, machine *provider.Machine) error {
+				return (&cmd.StartCmd{}).Run(ctx, providerAws, machine, log.Default)
+			},
+			status: client.StatusRunning,
+		},
+		{
+			name: "delete",
+			run: func(ctx context.Context, providerAws *aws.AwsProvider, machine *provider.Machine) error {
+				return (&cmd.DeleteCmd{}).Run(ctx, providerAws, machine, log.Default)
+			},
+			status: client.StatusNotFound,
+		},
+	}
+
+	for _, step := range steps {
+		// every step builds a fresh provider, like a separate CLI invocation
+		providerAws, err := aws.NewProvider(ctx, log.Default)
+		if err != nil {
+			t.Fatalf("%s: new provider: %v", step.name, err)
+		}
+
+		if err := step.run(ctx, providerAws, provider.FromEnvironment()); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		waitForStatus(ctx, t, providerAws, step.name, step.status)
+	}
+}
+
+func waitForStatus(
+	ctx context.Context,
+	t *testing.T,
+	providerAws *aws.AwsProvider,
+	step string,
+	expected client.Status,
+) {
+	t.Helper()
+
+	var (
+		status client.Status
+		err    error
+	)
+
+	for deadline := time.Now().Add(time.Minute); time.Now().Before(deadline); time.Sleep(time.Second) {
+		status, err = aws.Status(ctx, providerAws.EC2, providerAws.Config.MachineID)
+		if err == nil && status == expected {
+			return
+		}
+	}
+
+	t.Fatalf("%s: expected status %s, got %s (error: %v)", step, expected, status, err)
+}
@@ -21,6 +21,9 @@ fi
 
 GO_BUILD_CMD="go build"
 GO_BUILD_LDFLAGS="-s -w"
+if [[ -n "${RELEASE_VERSION}" ]]; then
+  GO_BUILD_LDFLAGS="${GO_BUILD_LDFLAGS} -X github.com/loft-sh/devpod-provider-aws/pkg/version.Version=${RELEASE_VERSION}"
+fi
 
 BUILD_VERSION="prod"
 for arg in "$@"; do
@@ -2,80 +2,89 @@ package aws
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
-	"net/http"
-	"regexp"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	awsConfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamTypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
 	"github.com/loft-sh/devpod-provider-aws/pkg/options"
+	"github.com/loft-sh/devpod-provider-aws/pkg/userdata"
+	"github.com/loft-sh/devpod-provider-aws/pkg/version"
 	"github.com/loft-sh/devpod/pkg/client"
 	"github.com/loft-sh/devpod/pkg/log"
 	"github.com/loft-sh/devpod/pkg/ssh"
 	"github.com/pkg/errors"
 )
 
-// detect if we're in an ec2 instance
-func isEC2Instance() bool {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", "http://instance-data.ec2.internal", nil)
-	if err != nil {
-		return false
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return false
-	}
-	defer resp.Body.Close()
+// Tags added to every instance and its volumes and network interfaces
+// so that resources in shared accounts can be attributed
+const (
+	TagKeyOwner           = "devpod:owner"
+	TagKeyCreatedAt       = "devpod:created-at"
+	TagKeyProviderVersion = "devpod:provider-version"
+	TagKeyContext         = "devpod:context"
+	TagKeyWorkspace       = "devpod:workspace"
+)
 
-	return true
-}
+// Tags controlling the lifecycle of an instance, enforced by the reap command
+const (
+	TagKeyStopSchedule = "devpod:stop-schedule"
+	TagKeyTTL          = "devpod:ttl"
+	TagKeyKeepAlive    = "devpod:keep-alive"
+)
 
+// instanceProfilePropagationDelay is the time given IAM to propagate a newly
+// created instance profile before it is used
+var instanceProfilePropagationDelay = 10 * time.Second
+
+// NewProvider reads the options from the environment and creates the AWS
+// clients. When the provider runs on EC2, the region and network of the
+// instance are used as defaults.
 func NewProvider(ctx context.Context, logs log.Logger) (*AwsProvider, error) {
 	config, err := options.FromEnv(false)
 	if err != nil {
 		return nil, err
 	}
 
-	cfg, err := awsConfig.LoadDefaultConfig(ctx)
+	cfg, err := NewAWSConfig(ctx, config.Retry)
 	if err != nil {
 		return nil, err
 	}
 
-	isEC2 := isEC2Instance()
-
-	if config.DiskImage == "" && !isEC2 {
-		image, err := GetDefaultAMI(ctx, cfg, config.MachineType)
-		if err != nil {
-			return nil, err
-		}
-
-		config.DiskImage = image
+	// e.g. on a CI runner, default to its region and network. It is
+	// detected once, before the clients are created with the region.
+	if environment := DetectEC2Environment(ctx, NewIMDSClient(cfg)); environment != nil {
+		logs.Debugf("running on EC2 instance %s in %s", environment.InstanceID, environment.Region)
+		ApplyEC2Defaults(config, &cfg, environment, logs)
 	}
 
-	if config.RootDevice == "" && !isEC2 {
-		device, err := GetAMIRootDevice(ctx, cfg, config.DiskImage)
-		if err != nil {
-			return nil, err
-		}
-		config.RootDevice = device
-	}
+	return NewProviderFromConfig(config, cfg, logs), nil
+}
 
-	// create provider
-	provider := &AwsProvider{
+// NewProviderFromConfig creates a provider with clients for the given
+// AWS config, without resolving any defaults
+func NewProviderFromConfig(config *options.Options, cfg aws.Config, logs log.Logger) *AwsProvider {
+	return &AwsProvider{
 		Config:    config,
 		AwsConfig: cfg,
 		Log:       logs,
+		EC2:       ec2.NewFromConfig(cfg),
+		IAM:       iam.NewFromConfig(cfg),
+		STS:       sts.NewFromConfig(cfg),
 	}
-
-	return provider, nil
 }
 
 type AwsProvider struct {
@@ -83,10 +92,43 @@ type AwsProvider struct {
 	AwsConfig        aws.Config
 	Log              log.Logger
 	WorkingDirectory string
+
+	EC2 EC2Client
+	IAM IAMClient
+	STS STSClient
+
+	// RegionalEC2 holds the EC2 clients of regions other than the
+	// configured one, they are created on first use
+	RegionalEC2 map[string]EC2Client
+
+	// Created records the shared resources created by Create
+	Created CreatedResources
+}
+
+// EC2ForRegion returns an EC2 client for the given region
+func (provider *AwsProvider) EC2ForRegion(region string) EC2Client {
+	if region == "" || region == provider.AwsConfig.Region {
+		return provider.EC2
+	}
+
+	if svc, ok := provider.RegionalEC2[region]; ok {
+		return svc
+	}
+
+	cfg := provider.AwsConfig.Copy()
+	cfg.Region = region
+
+	svc := ec2.NewFromConfig(cfg)
+	if provider.RegionalEC2 == nil {
+		provider.RegionalEC2 = map[string]EC2Client{}
+	}
+	provider.RegionalEC2[region] = svc
+
+	return svc
 }
 
 func GetSubnetID(ctx context.Context, provider *AwsProvider) (string, error) {
-	svc := ec2.NewFromConfig(provider.AwsConfig)
+	svc := provider.EC2
 
 	// first search for a default devpod specific subnet, if it fails
 	// we search the subnet with most free IPs that can do also public-ipv4
@@ -151,7 +193,7 @@ func GetDevpodVPC(ctx context.Context, provider *AwsProvider) (string, error) {
 		return provider.Config.VpcID, nil
 	}
 	// Get a list of VPCs so we can associate the group with the first VPC.
-	svc := ec2.NewFromConfig(provider.AwsConfig)
+	svc := provider.EC2
 
 	result, err := svc.DescribeVpcs(ctx, nil)
 	if err != nil {
@@ -164,17 +206,15 @@ func GetDevpodVPC(ctx context.Context, provider *AwsProvider) (string, error) {
 
 	// We need to find a default vpc
 	for _, vpc := range result.Vpcs {
-		if *vpc.IsDefault {
+		if vpc.IsDefault != nil && *vpc.IsDefault {
 			return *vpc.VpcId, nil
 		}
 	}
 
-	return "", nil
+	return "", errors.Errorf("There is no default VPC, please specify %s", options.AWS_VPC_ID)
 }
 
-func GetDefaultAMI(ctx context.Context, cfg aws.Config, instanceType string) (string, error) {
-	svc := ec2.NewFromConfig(cfg)
-
+func GetDefaultAMI(ctx context.Context, svc EC2Client, instanceType string) (string, error) {
 	architecture := "x86_64"
 	// Graviton instances terminate with g
 	if strings.HasSuffix(strings.Split(instanceType, ".")[0], "g") {
@@ -225,6 +265,14 @@ func GetDefaultAMI(ctx context.Context, cfg aws.Config, instanceType string) (st
 		return "", err
 	}
 
+	if len(result.Images) == 0 {
+		return "", errors.Errorf(
+			"no Ubuntu 22.04 %s AMI found in this region, please specify %s",
+			architecture,
+			options.AWS_AMI,
+		)
+	}
+
 	// Sort by date, so we take the latest AMI available for Ubuntu 22.04
 	sort.Slice(result.Images, func(i, j int) bool {
 		iTime, err := time.Parse("2006-01-02T15:04:05.000Z", *result.Images[i].CreationDate)
@@ -242,9 +290,7 @@ func GetDefaultAMI(ctx context.Context, cfg aws.Config, instanceType string) (st
 	return *result.Images[0].ImageId, nil
 }
 
-func GetAMIRootDevice(ctx context.Context, cfg aws.Config, diskImage string) (string, error) {
-	svc := ec2.NewFromConfig(cfg)
-
+func GetAMIRootDevice(ctx context.Context, svc EC2Client, diskImage string) (string, error) {
 	input := &ec2.DescribeImagesInput{
 		ImageIds: []string{
 			diskImage,
@@ -256,7 +302,7 @@ func GetAMIRootDevice(ctx context.Context, cfg aws.Config, diskImage string) (st
 	}
 
 	// Struct spec: https://docs.aws.amazon.com/sdk-for-go/api/service/ec2/#Image
-	if len(result.Images) == 0 || *result.Images[0].RootDeviceName == "" {
+	if len(result.Images) == 0 || result.Images[0].RootDeviceName == nil || *result.Images[0].RootDeviceName == "" {
 		return "/dev/sda1", nil
 	}
 
@@ -268,7 +314,7 @@ func GetDevpodInstanceProfile(ctx context.Context, provider *AwsProvider) (strin
 		return provider.Config.InstanceProfileArn, nil
 	}
 
-	svc := iam.NewFromConfig(provider.AwsConfig)
+	svc := provider.IAM
 
 	roleInput := &iam.GetInstanceProfileInput{
 		InstanceProfileName: aws.String("devpod-ec2-role"),
@@ -279,11 +325,46 @@ func GetDevpodInstanceProfile(ctx context.Context, provider *AwsProvider) (strin
 		return CreateDevpodInstanceProfile(ctx, provider)
 	}
 
+	// the role may have been created before docker bootstrapping was enabled
+	if provider.Config.DockerBootstrap {
+		err := putECRPolicy(ctx, provider)
+		if err != nil {
+			return "", err
+		}
+	}
+
 	return *response.InstanceProfile.Arn, nil
 }
 
+// putECRPolicy allows the instances of the devpod role to pull images from
+// the private ECR registries of the account
+func putECRPolicy(ctx context.Context, provider *AwsProvider) error {
+	_, err := provider.IAM.PutRolePolicy(ctx, &iam.PutRolePolicyInput{
+		PolicyDocument: aws.String(`{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Sid": "ECRRead",
+      "Action": [
+        "ecr:GetAuthorizationToken",
+        "ecr:BatchCheckLayerAvailability",
+        "ecr:GetDownloadUrlForLayer",
+        "ecr:BatchGetImage"
+      ],
+      "Effect": "Allow",
+      "Resource": "*"
+    }
+  ]
+}`),
+		PolicyName: aws.String("devpod-ecr-policy"),
+		RoleName:   aws.String("devpod-ec2-role"),
+	})
+
+	return err
+}
+
 func CreateDevpodInstanceProfile(ctx context.Context, provider *AwsProvider) (string, error) {
-	svc := iam.NewFromConfig(provider.AwsConfig)
+	svc := provider.IAM
 	roleInput := &iam.CreateRoleInput{
 		AssumeRolePolicyDocument: aws.String(`{
     "Version": "2012-10-17",
@@ -298,12 +379,14 @@ func CreateDevpodInstanceProfile(ctx context.Context, provider *AwsProvider) (st
     ]
 }`),
 		RoleName: aws.String("devpod-ec2-role"),
+		Tags:     GetIAMTags(provider),
 	}
 
 	_, err := svc.CreateRole(ctx, roleInput)
 	if err != nil {
 		return "", err
 	}
+	provider.Created.setRole("devpod-ec2-role")
 
 	policyInput := &iam.PutRolePolicyInput{
 		PolicyDocument: aws.String(`{
@@ -341,14 +424,23 @@ func CreateDevpodInstanceProfile(ctx context.Context, provider *AwsProvider) (st
 		return "", err
 	}
 
+	if provider.Config.DockerBootstrap {
+		err = putECRPolicy(ctx, provider)
+		if err != nil {
+			return "", err
+		}
+	}
+
 	instanceProfile := &iam.CreateInstanceProfileInput{
 		InstanceProfileName: aws.String("devpod-ec2-role"),
+		Tags:                GetIAMTags(provider),
 	}
 
 	response, err := svc.CreateInstanceProfile(ctx, instanceProfile)
 	if err != nil {
 		return "", err
 	}
+	provider.Created.setInstanceProfile("devpod-ec2-role")
 
 	instanceRole := &iam.AddRoleToInstanceProfileInput{
 		InstanceProfileName: aws.String("devpod-ec2-role"),
@@ -362,7 +454,7 @@ func CreateDevpodInstanceProfile(ctx context.Context, provider *AwsProvider) (st
 
 	// TODO: need to find a better way to ensure
 	// role/profile propagation has succeeded
-	time.Sleep(time.Second * 10)
+	time.Sleep(instanceProfilePropagationDelay)
 
 	return *response.InstanceProfile.Arn, nil
 }
@@ -372,7 +464,34 @@ func GetDevpodSecurityGroups(ctx context.Context, provider *AwsProvider) ([]stri
 		return strings.Split(provider.Config.SecurityGroupID, ","), nil
 	}
 
-	svc := ec2.NewFromConfig(provider.AwsConfig)
+	sgs, err := findDevpodSecurityGroups(ctx, provider)
+	if err != nil {
+		return nil, err
+	} else if len(sgs) > 0 {
+		return sgs, nil
+	}
+
+	sg, err := CreateDevpodSecurityGroup(ctx, provider)
+	if err != nil {
+		// another machine may have created it in the meantime
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidGroup.Duplicate" {
+			sgs, findErr := findDevpodSecurityGroups(ctx, provider)
+			if findErr == nil && len(sgs) > 0 {
+				return sgs, nil
+			}
+		}
+
+		return nil, err
+	}
+
+	return []string{sg}, nil
+}
+
+// findDevpodSecurityGroups returns the IDs of the devpod security groups of
+// the configured VPC
+func findDevpodSecurityGroups(ctx context.Context, provider *AwsProvider) ([]string, error) {
+	svc := provider.EC2
 	input := &ec2.DescribeSecurityGroupsInput{
 		Filters: []types.Filter{
 			{
@@ -394,14 +513,8 @@ func GetDevpodSecurityGroups(ctx context.Context, provider *AwsProvider) ([]stri
 	}
 
 	result, err := svc.DescribeSecurityGroups(ctx, input)
-	// It it is not created, do it
-	if len(result.SecurityGroups) == 0 || err != nil {
-		sg, err := CreateDevpodSecurityGroup(ctx, provider)
-		if err != nil {
-			return nil, err
-		}
-
-		return []string{sg}, nil
+	if err != nil {
+		return nil, errors.Wrap(err, "describe security groups")
 	}
 
 	sgs := []string{}
@@ -415,7 +528,7 @@ func GetDevpodSecurityGroups(ctx context.Context, provider *AwsProvider) ([]stri
 func CreateDevpodSecurityGroup(ctx context.Context, provider *AwsProvider) (string, error) {
 	var err error
 
-	svc := ec2.NewFromConfig(provider.AwsConfig)
+	svc := provider.EC2
 
 	vpc, err := GetDevpodVPC(ctx, provider)
 	if err != nil {
@@ -427,15 +540,13 @@ func CreateDevpodSecurityGroup(ctx context.Context, provider *AwsProvider) (stri
 		GroupName:   aws.String("devpod"),
 		Description: aws.String("Default Security Group for DevPod"),
 		TagSpecifications: []types.TagSpecification{
-			{
-				ResourceType: "security-group",
-				Tags: []types.Tag{
-					{
-						Key:   aws.String("devpod"),
-						Value: aws.String("devpod"),
-					},
-				},
-			},
+			GetResourceTags(provider, types.ResourceTypeSecurityGroup, types.Tag{
+				Key:   aws.String("devpod"),
+				Value: aws.String("devpod"),
+			}, types.Tag{
+				Key:   aws.String(TagKeyCreatedAt),
+				Value: aws.String(time.Now().UTC().Format(time.RFC3339)),
+			}),
 		},
 		VpcId: aws.String(vpc),
 	})
@@ -444,6 +555,7 @@ func CreateDevpodSecurityGroup(ctx context.Context, provider *AwsProvider) (stri
 	}
 
 	groupID := *result.GroupId
+	provider.Created.addSecurityGroup(groupID)
 
 	// Add permissions to the security group
 	_, err = svc.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
@@ -461,15 +573,10 @@ func CreateDevpodSecurityGroup(ctx context.Context, provider *AwsProvider) (stri
 			},
 		},
 		TagSpecifications: []types.TagSpecification{
-			{
-				ResourceType: "security-group-rule",
-				Tags: []types.Tag{
-					{
-						Key:   aws.String("devpod"),
-						Value: aws.String("devpod-ingress"),
-					},
-				},
-			},
+			GetResourceTags(provider, types.ResourceTypeSecurityGroupRule, types.Tag{
+				Key:   aws.String("devpod"),
+				Value: aws.String("devpod-ingress"),
+			}),
 		},
 	})
 	if err != nil {
@@ -481,11 +588,9 @@ func CreateDevpodSecurityGroup(ctx context.Context, provider *AwsProvider) (stri
 
 func GetDevpodInstance(
 	ctx context.Context,
-	cfg aws.Config,
+	svc EC2Client,
 	name string,
 ) (*ec2.DescribeInstancesOutput, error) {
-	svc := ec2.NewFromConfig(cfg)
-
 	input := &ec2.DescribeInstancesInput{
 		Filters: []types.Filter{
 			{
@@ -522,13 +627,65 @@ func GetDevpodInstance(
 	return result, nil
 }
 
+// ReconcileDuplicateInstances warns about instances sharing the machine ID
+// besides the newest one, which all subcommands operate on, e.g. launched
+// by a retried create. They are terminated if AWS_TERMINATE_DUPLICATES is
+// enabled.
+func ReconcileDuplicateInstances(
+	ctx context.Context,
+	providerAws *AwsProvider,
+	logs log.Logger,
+) error {
+	result, err := GetDevpodInstance(ctx, providerAws.EC2, providerAws.Config.MachineID)
+	if err != nil {
+		return err
+	} else if len(result.Reservations) == 0 {
+		return nil
+	}
+
+	instanceID := aws.ToString(result.Reservations[0].Instances[0].InstanceId)
+	duplicates := []string{}
+	for _, reservation := range result.Reservations {
+		for _, instance := range reservation.Instances {
+			if id := aws.ToString(instance.InstanceId); id != instanceID {
+				duplicates = append(duplicates, id)
+			}
+		}
+	}
+
+	if len(duplicates) == 0 {
+		return nil
+	}
+
+	if !providerAws.Config.TerminateDuplicates {
+		logs.Warnf(
+			"Found duplicate instances %s of machine %s, using the newest instance %s. Set %s=true to terminate them",
+			strings.Join(duplicates, ", "),
+			providerAws.Config.MachineID,
+			instanceID,
+			options.AWS_TERMINATE_DUPLICATES,
+		)
+
+		return nil
+	}
+
+	for _, id := range duplicates {
+		logs.Infof("Terminating duplicate instance %s of machine %s", id, providerAws.Config.MachineID)
+
+		err := Delete(ctx, providerAws.EC2, id)
+		if err != nil {
+			return errors.Wrapf(err, "terminate duplicate instance %s", id)
+		}
+	}
+
+	return nil
+}
+
 func GetDevpodStoppedInstance(
 	ctx context.Context,
-	cfg aws.Config,
+	svc EC2Client,
 	name string,
 ) (*ec2.DescribeInstancesOutput, error) {
-	svc := ec2.NewFromConfig(cfg)
-
 	input := &ec2.DescribeInstancesInput{
 		Filters: []types.Filter{
 			{
@@ -563,11 +720,9 @@ func GetDevpodStoppedInstance(
 
 func GetDevpodRunningInstance(
 	ctx context.Context,
-	cfg aws.Config,
+	svc EC2Client,
 	name string,
 ) (*ec2.DescribeInstancesOutput, error) {
-	svc := ec2.NewFromConfig(cfg)
-
 	input := &ec2.DescribeInstancesInput{
 		Filters: []types.Filter{
 			{
@@ -600,38 +755,130 @@ func GetDevpodRunningInstance(
 	return result, nil
 }
 
-func GetInstanceTags(providerAws *AwsProvider) []types.TagSpecification {
-	result := []types.TagSpecification{
+// GetCallerIdentity returns the ARN of the identity the provider runs as
+func GetCallerIdentity(ctx context.Context, svc STSClient) (string, error) {
+	result, err := svc.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+
+	return *result.Arn, nil
+}
+
+// GetResourceTags returns the tag specification for a resource the provider
+// creates, consisting of the given tags and the configured default tags
+func GetResourceTags(
+	providerAws *AwsProvider,
+	resourceType types.ResourceType,
+	tags ...types.Tag,
+) types.TagSpecification {
+	for _, tag := range providerAws.Config.DefaultTags {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(tag.Key),
+			Value: aws.String(tag.Value),
+		})
+	}
+
+	return types.TagSpecification{
+		ResourceType: resourceType,
+		Tags:         tags,
+	}
+}
+
+// GetIAMTags returns the tags for the IAM role and instance profile
+func GetIAMTags(providerAws *AwsProvider) []iamTypes.Tag {
+	tags := []iamTypes.Tag{
 		{
-			ResourceType: "instance",
-			Tags: []types.Tag{
-				{
-					Key:   aws.String("devpod"),
-					Value: aws.String(providerAws.Config.MachineID),
-				},
-			},
+			Key:   aws.String("devpod"),
+			Value: aws.String("devpod"),
 		},
 	}
 
-	reg := regexp.MustCompile(`Name=([A-Za-z0-9!"#$%&'()*+\-./:;<>?@[\\\]^_{|}~]+),Value=([A-Za-z0-9!"#$%&'()*+\-./:;<>?@[\\\]^_{|}~]+)`)
+	for _, tag := range providerAws.Config.DefaultTags {
+		tags = append(tags, iamTypes.Tag{
+			Key:   aws.String(tag.Key),
+			Value: aws.String(tag.Value),
+		})
+	}
 
-	tagList := reg.FindAllString(providerAws.Config.InstanceTags, -1)
-	if tagList == nil {
-		return result
+	return tags
+}
+
+// GetInstanceTags returns the tag specifications for an instance and the
+// volumes and network interfaces created along with it
+func GetInstanceTags(providerAws *AwsProvider, owner string) []types.TagSpecification {
+	tags := []types.Tag{
+		{
+			Key:   aws.String("devpod"),
+			Value: aws.String(providerAws.Config.MachineID),
+		},
+		{
+			Key:   aws.String(TagKeyProviderVersion),
+			Value: aws.String(version.Version),
+		},
+	}
+
+	if owner != "" {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(TagKeyOwner),
+			Value: aws.String(owner),
+		})
 	}
 
-	for _, tag := range tagList {
-		tagSplit := strings.Split(tag, ",")
+	if providerAws.Config.MachineContext != "" {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(TagKeyContext),
+			Value: aws.String(providerAws.Config.MachineContext),
+		})
+	}
 
-		name := strings.ReplaceAll(tagSplit[0], "Name=", "")
-		value := strings.ReplaceAll(tagSplit[1], "Value=", "")
+	if providerAws.Config.WorkspaceID != "" {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(TagKeyWorkspace),
+			Value: aws.String(providerAws.Config.WorkspaceID),
+		})
+	}
 
-		tagSpec := types.Tag{
-			Key:   aws.String(name),
-			Value: aws.String(value),
-		}
+	if providerAws.Config.StopSchedule != nil {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(TagKeyStopSchedule),
+			Value: aws.String(providerAws.Config.StopSchedule.String()),
+		})
+	}
 
-		result[0].Tags = append(result[0].Tags, tagSpec)
+	if providerAws.Config.TTL > 0 {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(TagKeyTTL),
+			Value: aws.String(options.FormatTTL(providerAws.Config.TTL)),
+		})
+	}
+
+	if providerAws.Config.StopAction != "" {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(TagKeyStopAction),
+			Value: aws.String(providerAws.Config.StopAction),
+		})
+	}
+
+	for _, tag := range options.MergeTags(providerAws.Config.DefaultTags, providerAws.Config.InstanceTags) {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(tag.Key),
+			Value: aws.String(tag.Value),
+		})
+	}
+
+	// the volumes and network interfaces created with the instance
+	// carry the same tags, so they can be attributed as well
+	result := []types.TagSpecification{}
+	for _, resourceType := range []types.ResourceType{
+		types.ResourceTypeInstance,
+		types.ResourceTypeVolume,
+		types.ResourceTypeNetworkInterface,
+	} {
+		result = append(result, types.TagSpecification{
+			ResourceType: resourceType,
+			Tags:         tags,
+		})
 	}
 
 	return result
@@ -639,81 +886,211 @@ func GetInstanceTags(providerAws *AwsProvider) []types.TagSpecification {
 
 func Create(
 	ctx context.Context,
-	cfg aws.Config,
 	providerAws *AwsProvider,
 ) (*ec2.RunInstancesOutput, error) {
-	svc := ec2.NewFromConfig(cfg)
+	svc := providerAws.EC2
 
-	devpodSG, err := GetDevpodSecurityGroups(ctx, providerAws)
-	if err != nil {
-		return nil, err
+	if providerAws.Config.StopAction == options.StopActionHibernate {
+		err := CheckHibernationSupport(ctx, svc, providerAws.Config.MachineType, providerAws.Config.DiskSizeGB)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the lookups are independent, so they run concurrently
+	var (
+		discovery                 *Discovery
+		owner, userData           string
+		discoveryErr, userDataErr error
+		wg                        sync.WaitGroup
+	)
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		discovery, discoveryErr = Discover(ctx, providerAws)
+	}()
+	go func() {
+		defer wg.Done()
+
+		var err error
+		owner, err = GetCallerIdentity(ctx, providerAws.STS)
+		if err != nil {
+			providerAws.Log.Warnf("unable to determine caller identity for the %s tag: %v", TagKeyOwner, err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		userData, userDataErr = GetUserData(ctx, providerAws)
+	}()
+	wg.Wait()
+
+	if discoveryErr != nil {
+		return nil, discoveryErr
+	} else if userDataErr != nil {
+		return nil, userDataErr
 	}
 
 	volSizeI32 := int32(providerAws.Config.DiskSizeGB)
 
-	userData, err := GetInjectKeypairScript(providerAws.Config.MachineFolder)
+	publicKey, err := ssh.GetPublicKeyBase(providerAws.Config.MachineFolder)
 	if err != nil {
 		return nil, err
 	}
 
 	instance := &ec2.RunInstancesInput{
-		ImageId:          aws.String(providerAws.Config.DiskImage),
+		ImageId:          aws.String(discovery.Image),
 		InstanceType:     types.InstanceType(providerAws.Config.MachineType),
 		MinCount:         aws.Int32(1),
 		MaxCount:         aws.Int32(1),
-		SecurityGroupIds: devpodSG,
-		MetadataOptions: &types.InstanceMetadataOptionsRequest{
-			HttpEndpoint:            types.InstanceMetadataEndpointStateEnabled,
-			HttpTokens:              types.HttpTokensStateRequired,
-			HttpPutResponseHopLimit: aws.Int32(1),
-		},
+		SecurityGroupIds: discovery.SecurityGroupIDs,
+		MetadataOptions:  GetMetadataOptions(providerAws.Config),
 		BlockDeviceMappings: []types.BlockDeviceMapping{
 			{
-				DeviceName: aws.String(providerAws.Config.RootDevice),
+				DeviceName: aws.String(discovery.RootDevice),
 				Ebs: &types.EbsBlockDevice{
 					VolumeSize: &volSizeI32,
 				},
 			},
 		},
-		TagSpecifications: GetInstanceTags(providerAws),
+		TagSpecifications: GetInstanceTags(providerAws, owner),
 		UserData:          &userData,
+		ClientToken:       aws.String(GetClientToken(providerAws.Config.MachineID, publicKey)),
+	}
+
+	// hibernation stores the memory on the root volume, which has to be
+	// encrypted
+	if providerAws.Config.StopAction == options.StopActionHibernate {
+		instance.HibernationOptions = &types.HibernationOptionsRequest{Configured: aws.Bool(true)}
+		instance.BlockDeviceMappings[0].Ebs.Encrypted = aws.Bool(true)
 	}
 
-	profile, err := GetDevpodInstanceProfile(ctx, providerAws)
-	if err == nil {
+	if discovery.InstanceProfileArn != "" {
 		instance.IamInstanceProfile = &types.IamInstanceProfileSpecification{
-			Arn: aws.String(profile),
+			Arn: aws.String(discovery.InstanceProfileArn),
 		}
 	}
 
-	if providerAws.Config.VpcID != "" && providerAws.Config.SubnetID == "" {
-		subnetID, err := GetSubnetID(ctx, providerAws)
-		if err != nil {
-			return nil, err
+	if discovery.SubnetID != "" {
+		instance.SubnetId = aws.String(discovery.SubnetID)
+	}
+
+	result, err := svc.RunInstances(ctx, instance)
+	if isIdempotentParameterMismatch(err) {
+		// an earlier attempt launched the instance with other parameters,
+		// e.g. before the discovered resources changed
+		result, err = getLaunchedInstances(ctx, svc, providerAws.Config.MachineID, aws.ToString(instance.ClientToken), err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = tagCreatedAt(ctx, svc, result.Instances)
+	if err != nil {
+		providerAws.Log.Warnf("unable to tag the instance with %s: %v", TagKeyCreatedAt, err)
+	}
+
+	return result, nil
+}
+
+// tagCreatedAt tags the launched instances and the volumes and network
+// interfaces created with them with their launch time. The tag is not part
+// of the launch request, which has to be the same for every attempt with
+// the client token.
+func tagCreatedAt(ctx context.Context, svc EC2Client, instances []types.Instance) error {
+	for _, instance := range instances {
+		if getTagValue(instance.Tags, TagKeyCreatedAt) != "" {
+			continue
 		}
 
-		if subnetID == "" {
-			return nil, fmt.Errorf("could not find a matching SubnetID in VPC %s, please specify one", providerAws.Config.VpcID)
+		resources := []string{aws.ToString(instance.InstanceId)}
+		for _, mapping := range instance.BlockDeviceMappings {
+			if mapping.Ebs != nil && mapping.Ebs.VolumeId != nil {
+				resources = append(resources, aws.ToString(mapping.Ebs.VolumeId))
+			}
+		}
+		for _, networkInterface := range instance.NetworkInterfaces {
+			resources = append(resources, aws.ToString(networkInterface.NetworkInterfaceId))
 		}
 
-		instance.SubnetId = &subnetID
+		createdAt := aws.ToTime(instance.LaunchTime)
+		if createdAt.IsZero() {
+			createdAt = time.Now()
+		}
+
+		_, err := svc.CreateTags(ctx, &ec2.CreateTagsInput{
+			Resources: resources,
+			Tags: []types.Tag{
+				{
+					Key:   aws.String(TagKeyCreatedAt),
+					Value: aws.String(createdAt.UTC().Format(time.RFC3339)),
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
 	}
 
-	if providerAws.Config.SubnetID != "" {
-		instance.SubnetId = &providerAws.Config.SubnetID
+	return nil
+}
+
+// getLaunchedInstances returns the instances of the machine launched with the
+// client token, or err if there are none
+func getLaunchedInstances(
+	ctx context.Context,
+	svc EC2Client,
+	machineID string,
+	clientToken string,
+	err error,
+) (*ec2.RunInstancesOutput, error) {
+	instances, describeErr := GetDevpodInstance(ctx, svc, machineID)
+	if describeErr != nil {
+		return nil, err
 	}
 
-	result, err := svc.RunInstances(ctx, instance)
-	if err != nil {
+	result := &ec2.RunInstancesOutput{}
+	for _, reservation := range instances.Reservations {
+		for _, instance := range reservation.Instances {
+			if aws.ToString(instance.ClientToken) == clientToken {
+				result.Instances = append(result.Instances, instance)
+			}
+		}
+	}
+	if len(result.Instances) == 0 {
 		return nil, err
 	}
 
 	return result, nil
 }
 
-func Start(ctx context.Context, cfg aws.Config, instanceID string) error {
-	svc := ec2.NewFromConfig(cfg)
+// isIdempotentParameterMismatch reports whether err is caused by a client
+// token that was used with other parameters before
+func isIdempotentParameterMismatch(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "IdempotentParameterMismatch"
+}
+
+// GetClientToken returns the idempotency token to launch the instance of a
+// machine with. It is derived from the machine ID and the SSH key of the
+// machine, so a retried create returns the instance launched by the first
+// attempt, while a machine recreated with the same ID gets a new instance.
+func GetClientToken(machineID, publicKey string) string {
+	hash := sha256.Sum256([]byte(machineID + "\n" + publicKey))
 
+	return hex.EncodeToString(hash[:])
+}
+
+// GetCredentialsToken returns the secret the devcontainer of a machine
+// fetches the credentials of the instance role with. It is derived from the
+// private SSH key of the machine, so the user data of a retried create stays
+// the same.
+func GetCredentialsToken(privateKey []byte) string {
+	hash := sha256.Sum256(append([]byte("devpod-credentials\n"), privateKey...))
+
+	return hex.EncodeToString(hash[:])
+}
+
+func Start(ctx context.Context, svc EC2Client, instanceID string) error {
 	input := &ec2.StartInstancesInput{
 		InstanceIds: []string{
 			instanceID,
@@ -728,9 +1105,7 @@ func Start(ctx context.Context, cfg aws.Config, instanceID string) error {
 	return err
 }
 
-func Stop(ctx context.Context, cfg aws.Config, instanceID string) error {
-	svc := ec2.NewFromConfig(cfg)
-
+func Stop(ctx context.Context, svc EC2Client, instanceID string) error {
 	input := &ec2.StopInstancesInput{
 		InstanceIds: []string{
 			instanceID,
@@ -745,8 +1120,38 @@ func Stop(ctx context.Context, cfg aws.Config, instanceID string) error {
 	return err
 }
 
-func Status(ctx context.Context, cfg aws.Config, name string) (client.Status, error) {
-	result, err := GetDevpodInstance(ctx, cfg, name)
+// WaitForInstanceState waits until the instance is running, stopped or
+// terminated
+func WaitForInstanceState(
+	ctx context.Context,
+	svc EC2Client,
+	instanceID string,
+	state types.InstanceStateName,
+) error {
+	input := &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	}
+
+	var err error
+	switch state {
+	case types.InstanceStateNameRunning:
+		err = ec2.NewInstanceRunningWaiter(svc, jitterRunningWaiter).Wait(ctx, input, instanceStateTimeout)
+	case types.InstanceStateNameStopped:
+		err = ec2.NewInstanceStoppedWaiter(svc, jitterStoppedWaiter).Wait(ctx, input, instanceStateTimeout)
+	case types.InstanceStateNameTerminated:
+		err = ec2.NewInstanceTerminatedWaiter(svc, jitterTerminatedWaiter).Wait(ctx, input, instanceStateTimeout)
+	default:
+		return fmt.Errorf("cannot wait for instance state %s", state)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "wait for instance %s to be %s", instanceID, state)
+	}
+
+	return nil
+}
+
+func Status(ctx context.Context, svc EC2Client, name string) (client.Status, error) {
+	result, err := GetDevpodInstance(ctx, svc, name)
 	if err != nil {
 		return client.StatusNotFound, err
 	}
@@ -755,23 +1160,10 @@ func Status(ctx context.Context, cfg aws.Config, name string) (client.Status, er
 		return client.StatusNotFound, nil
 	}
 
-	status := result.Reservations[0].Instances[0].State.Name
-
-	switch {
-	case status == "running":
-		return client.StatusRunning, nil
-	case status == "stopped":
-		return client.StatusStopped, nil
-	case status == "terminated":
-		return client.StatusNotFound, nil
-	default:
-		return client.StatusBusy, nil
-	}
+	return GetClientStatus(result.Reservations[0].Instances[0].State.Name), nil
 }
 
-func Delete(ctx context.Context, cfg aws.Config, instanceID string) error {
-	svc := ec2.NewFromConfig(cfg)
-
+func Delete(ctx context.Context, svc EC2Client, instanceID string) error {
 	input := &ec2.TerminateInstancesInput{
 		InstanceIds: []string{
 			instanceID,
@@ -786,8 +1178,15 @@ func Delete(ctx context.Context, cfg aws.Config, instanceID string) error {
 	return err
 }
 
-func GetInjectKeypairScript(dir string) (string, error) {
-	publicKeyBase, err := ssh.GetPublicKeyBase(dir)
+// GetUserData returns the base64 encoded user data of the machine, which
+// creates the devpod user with the SSH key of the machine, mounts the NVMe
+// instance store if AWS_INSTANCE_STORE is enabled and the instance type has
+// one, bootstraps docker if AWS_DOCKER_BOOTSTRAP is enabled and runs the
+// parts of AWS_USER_DATA_EXTRA and AWS_USER_DATA_FILE
+func GetUserData(ctx context.Context, providerAws *AwsProvider) (string, error) {
+	config := providerAws.Config
+
+	publicKeyBase, err := ssh.GetPublicKeyBase(config.MachineFolder)
 	if err != nil {
 		return "", err
 	}
@@ -797,20 +1196,79 @@ func GetInjectKeypairScript(dir string) (string, error) {
 		return "", err
 	}
 
-	resultScript := `#!/bin/sh
-useradd devpod -d /home/devpod
-mkdir -p /home/devpod
-if grep -q sudo /etc/groups; then
-	usermod -aG sudo devpod
-elif grep -q wheel /etc/groups; then
-	usermod -aG wheel devpod
-fi
-echo "devpod ALL=(ALL) NOPASSWD:ALL" > /etc/sudoers.d/91-devpod
-mkdir -p /home/devpod/.ssh
-echo "` + string(publicKey) + `" >> /home/devpod/.ssh/authorized_keys
-chmod 0700 /home/devpod/.ssh
-chmod 0600 /home/devpod/.ssh/authorized_keys
-chown -R devpod:devpod /home/devpod`
-
-	return base64.StdEncoding.EncodeToString([]byte(resultScript)), nil
+	userDataConfig := userdata.Config{
+		User:      "devpod",
+		PublicKey: strings.TrimSpace(string(publicKey)),
+	}
+
+	if config.DockerBootstrap {
+		userDataConfig.Docker = &userdata.Docker{
+			RegistryMirrors: config.DockerRegistryMirrors,
+			DataRoot:        config.DockerDataRoot,
+		}
+	}
+
+	if config.ForwardCredentials {
+		privateKey, err := ssh.GetPrivateKeyRawBase(config.MachineFolder)
+		if err != nil {
+			return "", err
+		}
+
+		userDataConfig.Credentials = &userdata.Credentials{
+			Token: GetCredentialsToken(privateKey),
+		}
+	}
+
+	if config.InstanceStore {
+		storage, err := GetInstanceStoreInfo(ctx, providerAws.EC2, config.MachineType)
+		if err != nil {
+			return "", err
+		}
+
+		if storage == nil {
+			providerAws.Log.Warnf("Instance type %s has no NVMe instance store, ignoring %s", config.MachineType, options.AWS_INSTANCE_STORE)
+		} else {
+			providerAws.Log.Infof(
+				"Mounting the %dGB NVMe instance store of %s at %s",
+				aws.ToInt64(storage.TotalSizeInGB),
+				config.MachineType,
+				userdata.InstanceStoreMountPath,
+			)
+
+			userDataConfig.InstanceStore = &userdata.InstanceStore{
+				Docker: config.InstanceStoreDocker,
+				Tmp:    config.InstanceStoreTmp,
+			}
+		}
+	}
+
+	if config.UserDataExtra != "" {
+		part, err := userdata.NewPart("user-data-extra", config.UserDataExtra)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", options.AWS_USER_DATA_EXTRA, err)
+		}
+
+		userDataConfig.Extra = append(userDataConfig.Extra, part)
+	}
+
+	if config.UserDataFile != "" {
+		content, err := os.ReadFile(config.UserDataFile)
+		if err != nil {
+			return "", fmt.Errorf("read %s: %w", options.AWS_USER_DATA_FILE, err)
+		}
+
+		part, err := userdata.NewPart(filepath.Base(config.UserDataFile), string(content))
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", options.AWS_USER_DATA_FILE, err)
+		}
+
+		userDataConfig.Extra = append(userDataConfig.Extra, part)
+	}
+
+	rendered, err := userdata.Render(userDataConfig)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString([]byte(rendered)), nil
 }
@@ -0,0 +1,936 @@
+package aws
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+	"github.com/loft-sh/devpod-provider-aws/pkg/options"
+	"github.com/loft-sh/devpod-provider-aws/pkg/version"
+	"github.com/loft-sh/devpod/pkg/client"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const testOwner = "arn:aws:iam::123456789012:user/alice"
+
+func init() {
+	instanceProfilePropagationDelay = 0
+}
+
+func newTestProvider(t *testing.T, ec2Client *fake.EC2) (*AwsProvider, *fake.IAM) {
+	t.Helper()
+
+	iamClient := fake.NewIAM()
+
+	return &AwsProvider{
+		Config: &options.Options{
+			DiskImage:     "ami-ubuntu-x86",
+			DiskSizeGB:    40,
+			RootDevice:    "/dev/sda1",
+			MachineFolder: t.TempDir(),
+			MachineID:     "devpod-test",
+			MachineType:   "c5.xlarge",
+		},
+		Log: log.NewStreamLogger(io.Discard, io.Discard, logrus.DebugLevel),
+		EC2: ec2Client,
+		IAM: iamClient,
+		STS: fake.NewSTS(testOwner),
+	}, iamClient
+}
+
+func tag(key, value string) types.Tag {
+	return types.Tag{Key: aws.String(key), Value: aws.String(value)}
+}
+
+func TestGetSubnetID(t *testing.T) {
+	tests := []struct {
+		name     string
+		subnets  []types.Subnet
+		expected string
+	}{
+		{
+			name: "devpod tagged subnet",
+			subnets: []types.Subnet{
+				{SubnetId: aws.String("subnet-public"), VpcId: aws.String("vpc-1"), AvailableIpAddressCount: aws.Int32(100), MapPublicIpOnLaunch: aws.Bool(true)},
+				{SubnetId: aws.String("subnet-devpod"), VpcId: aws.String("vpc-2"), AvailableIpAddressCount: aws.Int32(1), Tags: []types.Tag{tag("devpod", "devpod")}},
+			},
+			expected: "subnet-devpod",
+		},
+		{
+			name: "public subnet with most free IPs",
+			subnets: []types.Subnet{
+				{SubnetId: aws.String("subnet-small"), VpcId: aws.String("vpc-1"), AvailableIpAddressCount: aws.Int32(10), MapPublicIpOnLaunch: aws.Bool(true)},
+				{SubnetId: aws.String("subnet-large"), VpcId: aws.String("vpc-1"), AvailableIpAddressCount: aws.Int32(200), MapPublicIpOnLaunch: aws.Bool(true)},
+				{SubnetId: aws.String("subnet-private"), VpcId: aws.String("vpc-1"), AvailableIpAddressCount: aws.Int32(1000), MapPublicIpOnLaunch: aws.Bool(false)},
+				{SubnetId: aws.String("subnet-other-vpc"), VpcId: aws.String("vpc-2"), AvailableIpAddressCount: aws.Int32(1000), MapPublicIpOnLaunch: aws.Bool(true)},
+			},
+			expected: "subnet-large",
+		},
+		{
+			name: "no public subnet",
+			subnets: []types.Subnet{
+				{SubnetId: aws.String("subnet-private"), VpcId: aws.String("vpc-1"), AvailableIpAddressCount: aws.Int32(1000), MapPublicIpOnLaunch: aws.Bool(false)},
+			},
+			expected: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec2Client := fake.NewEC2()
+			ec2Client.Subnets = test.subnets
+
+			provider, _ := newTestProvider(t, ec2Client)
+			provider.Config.VpcID = "vpc-1"
+
+			subnetID, err := GetSubnetID(context.Background(), provider)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if subnetID != test.expected {
+				t.Errorf("expected subnet %q, got %q", test.expected, subnetID)
+			}
+		})
+	}
+}
+
+func TestGetDevpodVPC(t *testing.T) {
+	tests := []struct {
+		name     string
+		vpcID    string
+		vpcs     []types.Vpc
+		expected string
+		err      string
+	}{
+		{
+			name:     "configured",
+			vpcID:    "vpc-configured",
+			expected: "vpc-configured",
+		},
+		{
+			name: "default",
+			vpcs: []types.Vpc{
+				{VpcId: aws.String("vpc-custom"), IsDefault: aws.Bool(false)},
+				{VpcId: aws.String("vpc-default"), IsDefault: aws.Bool(true)},
+			},
+			expected: "vpc-default",
+		},
+		{
+			name: "no default VPC",
+			vpcs: []types.Vpc{
+				{VpcId: aws.String("vpc-custom"), IsDefault: aws.Bool(false)},
+			},
+			err: "There is no default VPC",
+		},
+		{
+			name: "no VPCs",
+			err:  "There are no VPCs",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec2Client := fake.NewEC2()
+			ec2Client.Vpcs = test.vpcs
+
+			provider, _ := newTestProvider(t, ec2Client)
+			provider.Config.VpcID = test.vpcID
+
+			vpcID, err := GetDevpodVPC(context.Background(), provider)
+			checkError(t, err, test.err)
+
+			if vpcID != test.expected {
+				t.Errorf("expected VPC %q, got %q", test.expected, vpcID)
+			}
+		})
+	}
+}
+
+func TestGetDefaultAMI(t *testing.T) {
+	tests := []struct {
+		name         string
+		instanceType string
+		images       []types.Image
+		expected     string
+		err          string
+	}{
+		{
+			name:         "latest x86_64 image",
+			instanceType: "c5.xlarge",
+			images: []types.Image{
+				fake.Image("ami-old", "x86_64", "2023-01-01T00:00:00.000Z"),
+				fake.Image("ami-new", "x86_64", "2023-05-01T00:00:00.000Z"),
+				fake.Image("ami-arm", "arm64", "2023-06-01T00:00:00.000Z"),
+			},
+			expected: "ami-new",
+		},
+		{
+			name:         "graviton instance",
+			instanceType: "c6g.xlarge",
+			images: []types.Image{
+				fake.Image("ami-x86", "x86_64", "2023-06-01T00:00:00.000Z"),
+				fake.Image("ami-arm", "arm64", "2023-05-01T00:00:00.000Z"),
+			},
+			expected: "ami-arm",
+		},
+		{
+			name:         "no matching image",
+			instanceType: "c6g.xlarge",
+			images: []types.Image{
+				fake.Image("ami-x86", "x86_64", "2023-06-01T00:00:00.000Z"),
+			},
+			err: "no Ubuntu 22.04 arm64 AMI found",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec2Client := fake.NewEC2()
+			ec2Client.Images = test.images
+
+			image, err := GetDefaultAMI(context.Background(), ec2Client, test.instanceType)
+			checkError(t, err, test.err)
+
+			if image != test.expected {
+				t.Errorf("expected image %q, got %q", test.expected, image)
+			}
+		})
+	}
+}
+
+func TestGetDevpodSecurityGroups(t *testing.T) {
+	tests := []struct {
+		name            string
+		securityGroupID string
+		groups          []types.SecurityGroup
+		vpcs            []types.Vpc
+		errors          map[string]error
+		expected        []string
+		created         bool
+		err             string
+	}{
+		{
+			name:            "configured",
+			securityGroupID: "sg-1,sg-2",
+			expected:        []string{"sg-1", "sg-2"},
+		},
+		{
+			name: "existing devpod group",
+			groups: []types.SecurityGroup{
+				{GroupId: aws.String("sg-other"), GroupName: aws.String("other")},
+				{GroupId: aws.String("sg-devpod"), GroupName: aws.String("devpod"), Tags: []types.Tag{tag("devpod", "devpod")}},
+			},
+			expected: []string{"sg-devpod"},
+		},
+		{
+			name:    "create devpod group",
+			vpcs:    []types.Vpc{{VpcId: aws.String("vpc-default"), IsDefault: aws.Bool(true)}},
+			created: true,
+		},
+		{
+			name: "create without default VPC",
+			vpcs: []types.Vpc{{VpcId: aws.String("vpc-custom"), IsDefault: aws.Bool(false)}},
+			err:  "There is no default VPC",
+		},
+		{
+			name:   "describe fails",
+			vpcs:   []types.Vpc{{VpcId: aws.String("vpc-default"), IsDefault: aws.Bool(true)}},
+			errors: map[string]error{"DescribeSecurityGroups": fake.APIError("RequestLimitExceeded", "Request limit exceeded.")},
+			err:    "RequestLimitExceeded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec2Client := fake.NewEC2()
+			ec2Client.SecurityGroups = test.groups
+			ec2Client.Vpcs = test.vpcs
+			for operation, err := range test.errors {
+				ec2Client.Errors[operation] = err
+			}
+
+			provider, _ := newTestProvider(t, ec2Client)
+			provider.Config.SecurityGroupID = test.securityGroupID
+
+			groups, err := GetDevpodSecurityGroups(context.Background(), provider)
+			checkError(t, err, test.err)
+			if err != nil {
+				return
+			}
+
+			if test.created {
+				if len(ec2Client.SecurityGroups) != 1 || len(groups) != 1 {
+					t.Fatalf("expected one security group to be created, got %v", groups)
+				}
+
+				group := ec2Client.SecurityGroups[0]
+				if aws.ToString(group.GroupId) != groups[0] || aws.ToString(group.VpcId) != "vpc-default" {
+					t.Errorf("unexpected security group %s in %s", aws.ToString(group.GroupId), aws.ToString(group.VpcId))
+				}
+				if len(group.IpPermissions) != 1 || aws.ToInt32(group.IpPermissions[0].FromPort) != 22 {
+					t.Errorf("expected ssh ingress rule, got %v", group.IpPermissions)
+				}
+				if getTagValue(group.Tags, TagKeyCreatedAt) == "" {
+					t.Errorf("expected a %s tag for gc, got %v", TagKeyCreatedAt, group.Tags)
+				}
+
+				return
+			}
+
+			if strings.Join(groups, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("expected groups %v, got %v", test.expected, groups)
+			}
+		})
+	}
+}
+
+// racingEC2 creates the devpod security group of another machine right
+// before its own creation
+type racingEC2 struct {
+	*fake.EC2
+}
+
+func (r *racingEC2) CreateSecurityGroup(ctx context.Context, params *ec2.CreateSecurityGroupInput, optFns ...func(*ec2.Options)) (*ec2.CreateSecurityGroupOutput, error) {
+	_, err := r.EC2.CreateSecurityGroup(ctx, params, optFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.EC2.CreateSecurityGroup(ctx, params, optFns...)
+}
+
+func TestGetDevpodSecurityGroupsConcurrentCreation(t *testing.T) {
+	ec2Client := fake.NewDefaultEC2()
+	provider, _ := newTestProvider(t, ec2Client)
+	provider.EC2 = &racingEC2{EC2: ec2Client}
+
+	groups, err := GetDevpodSecurityGroups(context.Background(), provider)
+	checkError(t, err, "")
+
+	if len(ec2Client.SecurityGroups) != 1 || len(groups) != 1 || groups[0] != aws.ToString(ec2Client.SecurityGroups[0].GroupId) {
+		t.Errorf("expected the concurrently created group, got %v", groups)
+	}
+}
+
+func TestGetDevpodInstanceProfile(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured string
+		existing   bool
+		docker     bool
+		expected   string
+	}{
+		{
+			name:       "configured",
+			configured: "arn:aws:iam::123456789012:instance-profile/custom",
+			expected:   "arn:aws:iam::123456789012:instance-profile/custom",
+		},
+		{
+			name:     "existing",
+			existing: true,
+			expected: "arn:aws:iam::123456789012:instance-profile/devpod-ec2-role",
+		},
+		{
+			name:     "created",
+			expected: "arn:aws:iam::123456789012:instance-profile/devpod-ec2-role",
+		},
+		{
+			name:     "created with docker",
+			docker:   true,
+			expected: "arn:aws:iam::123456789012:instance-profile/devpod-ec2-role",
+		},
+		{
+			name:     "existing with docker",
+			existing: true,
+			docker:   true,
+			expected: "arn:aws:iam::123456789012:instance-profile/devpod-ec2-role",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider, iamClient := newTestProvider(t, fake.NewEC2())
+			provider.Config.InstanceProfileArn = test.configured
+			provider.Config.DockerBootstrap = test.docker
+			if test.existing {
+				iamClient.AddInstanceProfile("devpod-ec2-role")
+			}
+
+			arn, err := GetDevpodInstanceProfile(context.Background(), provider)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if arn != test.expected {
+				t.Errorf("expected profile %q, got %q", test.expected, arn)
+			}
+
+			if test.configured == "" && !test.existing {
+				profile := iamClient.InstanceProfiles["devpod-ec2-role"]
+				if profile == nil || len(profile.Roles) != 1 {
+					t.Fatalf("expected instance profile with role, got %v", profile)
+				}
+				if _, ok := iamClient.RolePolicies["devpod-ec2-role"]["devpod-ec2-policy"]; !ok {
+					t.Errorf("expected devpod-ec2-policy on role")
+				}
+			}
+
+			_, ok := iamClient.RolePolicies["devpod-ec2-role"]["devpod-ecr-policy"]
+			if ok != test.docker {
+				t.Errorf("expected devpod-ecr-policy on role: %v, got %v", test.docker, ok)
+			}
+		})
+	}
+}
+
+func TestGetInstanceTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		owner    string
+		context  string
+		instance []options.Tag
+		expected map[string]string
+		absent   []string
+	}{
+		{
+			name:    "attribution",
+			owner:   testOwner,
+			context: "default",
+			instance: []options.Tag{
+				{Key: "team", Value: "platform"},
+			},
+			expected: map[string]string{
+				"devpod":              "devpod-test",
+				TagKeyOwner:           testOwner,
+				TagKeyContext:         "default",
+				TagKeyWorkspace:       "my-workspace",
+				TagKeyProviderVersion: version.Version,
+				"team":                "platform",
+			},
+		},
+		{
+			name: "unknown owner",
+			expected: map[string]string{
+				"devpod":        "devpod-test",
+				TagKeyWorkspace: "my-workspace",
+			},
+			absent: []string{TagKeyOwner, TagKeyContext},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider, _ := newTestProvider(t, fake.NewEC2())
+			provider.Config.MachineContext = test.context
+			provider.Config.WorkspaceID = "my-workspace"
+			provider.Config.InstanceTags = test.instance
+
+			specifications := GetInstanceTags(provider, test.owner)
+
+			resourceTypes := []types.ResourceType{}
+			for _, specification := range specifications {
+				resourceTypes = append(resourceTypes, specification.ResourceType)
+
+				for key, value := range test.expected {
+					if actual := getTag(specification.Tags, key); actual != value {
+						t.Errorf("%s: expected tag %s=%q, got %q", specification.ResourceType, key, value, actual)
+					}
+				}
+				for _, key := range test.absent {
+					if actual := getTag(specification.Tags, key); actual != "" {
+						t.Errorf("%s: expected no tag %s, got %q", specification.ResourceType, key, actual)
+					}
+				}
+
+				// tagged after the launch, the launch request does not
+				// depend on the time
+				if actual := getTag(specification.Tags, TagKeyCreatedAt); actual != "" {
+					t.Errorf("%s: expected no tag %s, got %q", specification.ResourceType, TagKeyCreatedAt, actual)
+				}
+			}
+
+			expectedTypes := []types.ResourceType{
+				types.ResourceTypeInstance,
+				types.ResourceTypeVolume,
+				types.ResourceTypeNetworkInterface,
+			}
+			if fmt.Sprint(resourceTypes) != fmt.Sprint(expectedTypes) {
+				t.Errorf("expected tag specifications for %v, got %v", expectedTypes, resourceTypes)
+			}
+		})
+	}
+}
+
+func TestCreate(t *testing.T) {
+	tests := []struct {
+		name     string
+		vpcID    string
+		subnetID string
+		image    string
+		expected string
+		err      string
+	}{
+		{
+			name:     "default VPC",
+			expected: "subnet-default",
+		},
+		{
+			name:     "configured subnet",
+			subnetID: "subnet-default",
+			expected: "subnet-default",
+		},
+		{
+			name:  "VPC without public subnet",
+			vpcID: "vpc-private",
+			err:   "could not find a matching SubnetID in VPC vpc-private",
+		},
+		{
+			name:  "unknown image",
+			image: "ami-unknown",
+			err:   "InvalidAMIID.NotFound",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec2Client := fake.NewDefaultEC2()
+			ec2Client.Vpcs = append(ec2Client.Vpcs, types.Vpc{VpcId: aws.String("vpc-private"), IsDefault: aws.Bool(false)})
+
+			provider, iamClient := newTestProvider(t, ec2Client)
+			iamClient.AddInstanceProfile("devpod-ec2-role")
+			provider.Config.VpcID = test.vpcID
+			provider.Config.SubnetID = test.subnetID
+			if test.image != "" {
+				provider.Config.DiskImage = test.image
+			}
+
+			result, err := Create(context.Background(), provider)
+			checkError(t, err, test.err)
+			if err != nil {
+				return
+			}
+
+			instance := result.Instances[0]
+			if aws.ToString(instance.SubnetId) != test.expected {
+				t.Errorf("expected subnet %q, got %q", test.expected, aws.ToString(instance.SubnetId))
+			}
+			if getTag(instance.Tags, "devpod") != "devpod-test" {
+				t.Errorf("expected devpod tag, got %v", instance.Tags)
+			}
+			if getTag(instance.Tags, TagKeyOwner) != testOwner {
+				t.Errorf("expected owner tag %q, got %v", testOwner, instance.Tags)
+			}
+			if instance.IamInstanceProfile == nil {
+				t.Errorf("expected instance profile")
+			}
+		})
+	}
+}
+
+func TestCreateIsIdempotent(t *testing.T) {
+	launchedAt := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	now := launchedAt
+
+	ec2Client := fake.NewDefaultEC2()
+	ec2Client.Now = func() time.Time { return now }
+	provider, iamClient := newTestProvider(t, ec2Client)
+	iamClient.AddInstanceProfile("devpod-ec2-role")
+
+	first, err := Create(context.Background(), provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a retry after a lost response returns the same instance, also at a
+	// later time
+	now = now.Add(time.Minute)
+	second, err := Create(context.Background(), provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// and if the parameters changed in between
+	provider.Config.DiskSizeGB = 80
+	third, err := Create(context.Background(), provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ec2Client.Instances) != 1 {
+		t.Fatalf("expected one instance, got %d", len(ec2Client.Instances))
+	}
+	for _, retry := range []*ec2.RunInstancesOutput{second, third} {
+		if aws.ToString(first.Instances[0].InstanceId) != aws.ToString(retry.Instances[0].InstanceId) {
+			t.Errorf("expected instance %s, got %s", aws.ToString(first.Instances[0].InstanceId), aws.ToString(retry.Instances[0].InstanceId))
+		}
+	}
+
+	instance := ec2Client.Instances[0]
+	if createdAt := getTag(instance.Tags, TagKeyCreatedAt); createdAt != launchedAt.Format(time.RFC3339) {
+		t.Errorf("expected %s=%s, got %q", TagKeyCreatedAt, launchedAt.Format(time.RFC3339), createdAt)
+	}
+	if createdAt := getTag(ec2Client.Volumes[0].Tags, TagKeyCreatedAt); createdAt != launchedAt.Format(time.RFC3339) {
+		t.Errorf("expected the volume to be tagged with %s=%s, got %q", TagKeyCreatedAt, launchedAt.Format(time.RFC3339), createdAt)
+	}
+
+	// the fake rejects a client token with other parameters, like EC2
+	input := *ec2Client.RunInstancesInputs[0]
+	input.InstanceType = types.InstanceTypeC54xlarge
+	_, err = ec2Client.RunInstances(context.Background(), &input)
+	checkError(t, err, "IdempotentParameterMismatch")
+
+	// a recreated machine has a new key
+	if GetClientToken("devpod-test", "key-1") == GetClientToken("devpod-test", "key-2") {
+		t.Error("expected different client tokens for different keys")
+	}
+}
+
+func TestGetUserDataForwardCredentials(t *testing.T) {
+	for _, forward := range []bool{false, true} {
+		provider, _ := newTestProvider(t, fake.NewDefaultEC2())
+		provider.Config.ForwardCredentials = forward
+
+		encoded, err := GetUserData(context.Background(), provider)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		userData, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		forwarded := strings.Contains(string(userData), "30-devpod-credentials.sh")
+		if forwarded != forward {
+			t.Errorf("expected the credentials to be forwarded: %v, got %v", forward, forwarded)
+		}
+
+		// a retried create launches the instance with the same user data
+		again, err := GetUserData(context.Background(), provider)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if again != encoded {
+			t.Error("expected the same user data for the same machine")
+		}
+	}
+
+	if GetCredentialsToken([]byte("key-1")) == GetCredentialsToken([]byte("key-2")) {
+		t.Error("expected different credentials tokens for different keys")
+	}
+}
+
+func TestReconcileDuplicateInstances(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name       string
+		terminate  bool
+		instances  []types.Instance
+		terminated []string
+	}{
+		{
+			name:      "single instance",
+			terminate: true,
+			instances: []types.Instance{fake.Instance("i-1", "devpod-test", now)},
+		},
+		{
+			name: "duplicates are kept",
+			instances: []types.Instance{
+				fake.Instance("i-old", "devpod-test", now.Add(-time.Minute)),
+				fake.Instance("i-new", "devpod-test", now),
+			},
+		},
+		{
+			name:      "duplicates are terminated",
+			terminate: true,
+			instances: []types.Instance{
+				fake.Instance("i-old", "devpod-test", now.Add(-time.Minute)),
+				fake.Instance("i-new", "devpod-test", now),
+				fake.Instance("i-other", "devpod-other", now.Add(-time.Hour)),
+			},
+			terminated: []string{"i-old"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec2Client := fake.NewDefaultEC2()
+			ec2Client.Instances = test.instances
+
+			provider, _ := newTestProvider(t, ec2Client)
+			provider.Config.TerminateDuplicates = test.terminate
+
+			err := ReconcileDuplicateInstances(context.Background(), provider, provider.Log)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			terminated := []string{}
+			for _, instance := range ec2Client.Instances {
+				if instance.State.Name == types.InstanceStateNameTerminated {
+					terminated = append(terminated, aws.ToString(instance.InstanceId))
+				}
+			}
+
+			if strings.Join(terminated, ",") != strings.Join(test.terminated, ",") {
+				t.Errorf("expected terminated instances %v, got %v", test.terminated, terminated)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	now := time.Now()
+
+	withState := func(instance types.Instance, state types.InstanceStateName) types.Instance {
+		instance.State = &types.InstanceState{Name: state}
+		return instance
+	}
+
+	tests := []struct {
+		name      string
+		instances []types.Instance
+		expected  client.Status
+	}{
+		{
+			name:     "not found",
+			expected: client.StatusNotFound,
+		},
+		{
+			name:      "running",
+			instances: []types.Instance{fake.Instance("i-1", "devpod-test", now)},
+			expected:  client.StatusRunning,
+		},
+		{
+			name:      "stopped",
+			instances: []types.Instance{withState(fake.Instance("i-1", "devpod-test", now), types.InstanceStateNameStopped)},
+			expected:  client.StatusStopped,
+		},
+		{
+			name:      "pending",
+			instances: []types.Instance{withState(fake.Instance("i-1", "devpod-test", now), types.InstanceStateNamePending)},
+			expected:  client.StatusBusy,
+		},
+		{
+			name:      "terminated",
+			instances: []types.Instance{withState(fake.Instance("i-1", "devpod-test", now), types.InstanceStateNameTerminated)},
+			expected:  client.StatusNotFound,
+		},
+		{
+			name:      "other machine",
+			instances: []types.Instance{fake.Instance("i-1", "devpod-other", now)},
+			expected:  client.StatusNotFound,
+		},
+		{
+			name: "duplicate instances",
+			instances: []types.Instance{
+				withState(fake.Instance("i-old", "devpod-test", now.Add(-time.Hour)), types.InstanceStateNameStopped),
+				fake.Instance("i-new", "devpod-test", now),
+			},
+			expected: client.StatusRunning,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec2Client := fake.NewEC2()
+			ec2Client.Instances = test.instances
+
+			status, err := Status(context.Background(), ec2Client, "devpod-test")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if status != test.expected {
+				t.Errorf("expected status %q, got %q", test.expected, status)
+			}
+		})
+	}
+}
+
+func TestDecodeAuthorizationError(t *testing.T) {
+	unauthorized := fake.APIError(
+		"UnauthorizedOperation",
+		"You are not authorized to perform this operation. Encoded authorization failure message: abc-123_XYZ",
+	)
+
+	tests := []struct {
+		name     string
+		err      error
+		decoded  map[string]string
+		expected string
+	}{
+		{
+			name:     "other error",
+			err:      fake.APIError("InvalidAMIID.NotFound", "not found"),
+			expected: "not found",
+		},
+		{
+			name: "explicit deny",
+			err:  unauthorized,
+			decoded: map[string]string{
+				"abc-123_XYZ": `{"allowed":false,"explicitDeny":true,"matchedStatements":{"items":[{"statementId":"DenyRunInstances","effect":"DENY","actions":{"items":[{"value":"ec2:RunInstances"}]},"resources":{"items":[{"value":"*"}]}}]},"context":{"principal":{"arn":"arn:aws:iam::123456789012:user/alice"},"action":"ec2:RunInstances","resource":"arn:aws:ec2:us-east-1:123456789012:instance/*"}}`,
+			},
+			expected: "matched statement DenyRunInstances: DENY ec2:RunInstances on *",
+		},
+		{
+			name: "implicit deny",
+			err:  unauthorized,
+			decoded: map[string]string{
+				"abc-123_XYZ": `{"allowed":false,"explicitDeny":false,"matchedStatements":{"items":[]},"context":{"action":"ec2:RunInstances","resource":"*"}}`,
+			},
+			expected: "not authorized to perform ec2:RunInstances on *\n  no policy statement allows this action",
+		},
+		{
+			name:     "decoding denied",
+			err:      unauthorized,
+			expected: "grant sts:DecodeAuthorizationMessage",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stsClient := fake.NewSTS(testOwner)
+			stsClient.DecodedMessages = test.decoded
+
+			err := decodeAuthorizationError(context.Background(), stsClient, test.err)
+			checkError(t, err, test.expected)
+
+			// rendered like Execute does, with at most one hint
+			if hints := strings.Count(ClassifyError(err).Error(), "hint:"); hints > 1 {
+				t.Errorf("expected a single hint, got %d in %q", hints, ClassifyError(err).Error())
+			}
+		})
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		kind     ErrorKind
+		exitCode int
+	}{
+		{
+			name:     "expired credentials",
+			err:      fake.APIError("ExpiredToken", "The security token included in the request is expired"),
+			kind:     ErrorKindCredentials,
+			exitCode: 10,
+		},
+		{
+			name:     "missing credentials",
+			err:      &v4.SigningError{Err: fmt.Errorf("failed to retrieve credentials: no EC2 IMDS role found")},
+			kind:     ErrorKindCredentials,
+			exitCode: 10,
+		},
+		{
+			name:     "unauthorized",
+			err:      &AuthorizationError{Action: "ec2:RunInstances", Err: fake.APIError("UnauthorizedOperation", "denied")},
+			kind:     ErrorKindUnauthorized,
+			exitCode: 11,
+		},
+		{
+			name:     "vCPU limit",
+			err:      errors.Wrap(fake.APIError("VcpuLimitExceeded", "You have requested more vCPU capacity"), "run instance"),
+			kind:     ErrorKindVcpuLimitExceeded,
+			exitCode: 12,
+		},
+		{
+			name:     "insufficient capacity",
+			err:      &ResizeRollbackError{From: "c5.xlarge", To: "p4d.24xlarge", Err: fake.APIError("InsufficientInstanceCapacity", "no capacity")},
+			kind:     ErrorKindInsufficientCapacity,
+			exitCode: 13,
+		},
+		{
+			name:     "invalid AMI",
+			err:      fake.APIError("InvalidAMIID.Malformed", "Invalid id"),
+			kind:     ErrorKindInvalidAMI,
+			exitCode: 14,
+		},
+		{
+			name:     "invalid subnet",
+			err:      fake.APIError("InvalidSubnetID.NotFound", "The subnet ID 'subnet-1' does not exist"),
+			kind:     ErrorKindInvalidSubnet,
+			exitCode: 15,
+		},
+		{
+			name:     "duplicate security group",
+			err:      fake.APIError("InvalidGroup.Duplicate", "The security group 'devpod' already exists"),
+			kind:     ErrorKindDuplicateSecurityGroup,
+			exitCode: 16,
+		},
+		{
+			name:     "timed out while retrieving credentials",
+			err:      &v4.SigningError{Err: fmt.Errorf("failed to retrieve credentials: %w", context.DeadlineExceeded)},
+			exitCode: ExitCodeUnknown,
+		},
+		{
+			name:     "other",
+			err:      fake.APIError("InvalidParameterValue", "invalid"),
+			exitCode: ExitCodeUnknown,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ClassifyError(test.err)
+
+			var providerErr *ProviderError
+			if !errors.As(err, &providerErr) {
+				if test.kind != "" {
+					t.Fatalf("expected %s error, got %v", test.kind, err)
+				}
+			} else {
+				if providerErr.Kind != test.kind {
+					t.Errorf("expected kind %q, got %q", test.kind, providerErr.Kind)
+				}
+				if !strings.Contains(err.Error(), test.err.Error()) || !strings.Contains(err.Error(), "\nhint: ") {
+					t.Errorf("expected the error with a hint, got %q", err.Error())
+				}
+				if ClassifyError(err) != err {
+					t.Errorf("expected a classified error to be returned unchanged")
+				}
+			}
+
+			if code := ExitCode(test.err); code != test.exitCode {
+				t.Errorf("expected exit code %d, got %d", test.exitCode, code)
+			}
+		})
+	}
+}
+
+func getTag(tags []types.Tag, key string) string {
+	for _, tag := range tags {
+		if aws.ToString(tag.Key) == key {
+			return aws.ToString(tag.Value)
+		}
+	}
+
+	return ""
+}
+
+func checkError(t *testing.T, err error, expected string) {
+	t.Helper()
+
+	switch {
+	case expected == "" && err != nil:
+		t.Fatalf("unexpected error: %v", err)
+	case expected != "" && err == nil:
+		t.Fatalf("expected error containing %q, got nil", expected)
+	case expected != "" && !strings.Contains(err.Error(), expected):
+		t.Fatalf("expected error containing %q, got %v", expected, err)
+	}
+}
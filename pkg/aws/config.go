@@ -0,0 +1,128 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/loft-sh/devpod-provider-aws/pkg/options"
+)
+
+// NewAWSConfig loads the default AWS config and routes requests to the
+// custom endpoints configured through AWS_ENDPOINT_URL or the per-service
+// AWS_ENDPOINT_URL_EC2, AWS_ENDPOINT_URL_IAM and AWS_ENDPOINT_URL_STS, e.g.
+// for LocalStack/moto or VPC interface endpoints. Requests are retried as
+// configured by retryOptions, see getRetryer.
+func NewAWSConfig(ctx context.Context, retryOptions options.Retry) (aws.Config, error) {
+	loadOptions := []func(*awsConfig.LoadOptions) error{
+		awsConfig.WithRetryer(getRetryer(retryOptions)),
+	}
+
+	resolver, err := getEndpointResolver()
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	if resolver != nil {
+		loadOptions = append(loadOptions, awsConfig.WithEndpointResolverWithOptions(resolver))
+	}
+
+	return awsConfig.LoadDefaultConfig(ctx, loadOptions...)
+}
+
+// getRetryer returns the retryer of the AWS clients. It is adaptive unless
+// standard mode is configured, so many machines slow down their requests
+// once they are throttled. Limits that are not configured keep the defaults
+// of the SDK, its exponential backoff is jittered, so throttled requests of
+// many machines do not retry at the same time.
+func getRetryer(retryOptions options.Retry) func() aws.Retryer {
+	standardOptions := func(o *retry.StandardOptions) {
+		if retryOptions.MaxAttempts > 0 {
+			o.MaxAttempts = retryOptions.MaxAttempts
+		}
+		if retryOptions.MaxBackoff > 0 {
+			o.MaxBackoff = retryOptions.MaxBackoff
+		}
+	}
+
+	return func() aws.Retryer {
+		if retryOptions.Mode == options.RetryModeStandard {
+			return retry.NewStandard(standardOptions)
+		}
+
+		return retry.NewAdaptiveMode(func(o *retry.AdaptiveModeOptions) {
+			o.StandardOptions = append(o.StandardOptions, standardOptions)
+		})
+	}
+}
+
+func getEndpointResolver() (aws.EndpointResolverWithOptions, error) {
+	globalEndpoint := os.Getenv(options.AWS_ENDPOINT_URL)
+	serviceEndpoints := map[string]string{
+		ec2.ServiceID: os.Getenv(options.AWS_ENDPOINT_URL_EC2),
+		iam.ServiceID: os.Getenv(options.AWS_ENDPOINT_URL_IAM),
+		sts.ServiceID: os.Getenv(options.AWS_ENDPOINT_URL_STS),
+	}
+
+	configured := globalEndpoint != ""
+	for service, endpoint := range serviceEndpoints {
+		if endpoint == "" {
+			continue
+		}
+
+		configured = true
+		if err := validateEndpoint(endpoint); err != nil {
+			return nil, fmt.Errorf("endpoint for %s: %w", service, err)
+		}
+	}
+
+	if !configured {
+		return nil, nil
+	}
+
+	if globalEndpoint != "" {
+		if err := validateEndpoint(globalEndpoint); err != nil {
+			return nil, fmt.Errorf("%s: %w", options.AWS_ENDPOINT_URL, err)
+		}
+	}
+
+	return aws.EndpointResolverWithOptionsFunc(
+		func(service, region string, _ ...interface{}) (aws.Endpoint, error) {
+			endpoint := serviceEndpoints[service]
+			if endpoint == "" {
+				endpoint = globalEndpoint
+			}
+
+			// fall back to the default endpoint resolution
+			if endpoint == "" {
+				return aws.Endpoint{}, &aws.EndpointNotFoundError{}
+			}
+
+			return aws.Endpoint{
+				URL:               endpoint,
+				HostnameImmutable: true,
+				Source:            aws.EndpointSourceCustom,
+			}, nil
+		},
+	), nil
+}
+
+func validateEndpoint(endpoint string) error {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return fmt.Errorf("invalid endpoint %q, expected an URL like https://host:port", endpoint)
+	}
+
+	return nil
+}
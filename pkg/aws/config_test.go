@@ -0,0 +1,113 @@
+package aws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/loft-sh/devpod-provider-aws/pkg/options"
+)
+
+func TestNewAWSConfigRetryer(t *testing.T) {
+	for _, name := range []string{options.AWS_RETRY_MODE, options.AWS_RETRY_MAX_ATTEMPTS, "AWS_MAX_ATTEMPTS"} {
+		t.Setenv(name, "")
+	}
+
+	tests := []struct {
+		name     string
+		retry    options.Retry
+		adaptive bool
+	}{
+		{
+			name:     "default",
+			retry:    options.Retry{},
+			adaptive: true,
+		},
+		{
+			name:     "adaptive",
+			retry:    options.Retry{Mode: options.RetryModeAdaptive},
+			adaptive: true,
+		},
+		{
+			name:  "standard",
+			retry: options.Retry{Mode: options.RetryModeStandard},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := NewAWSConfig(context.Background(), test.retry)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.Retryer == nil {
+				t.Fatal("expected the retryer of the provider")
+			}
+
+			retryer := cfg.Retryer()
+			if _, adaptive := retryer.(*retry.AdaptiveMode); adaptive != test.adaptive {
+				t.Errorf("expected adaptive %v, got %T", test.adaptive, retryer)
+			}
+		})
+	}
+}
+
+func TestGetRetryer(t *testing.T) {
+	tests := []struct {
+		name        string
+		retry       options.Retry
+		adaptive    bool
+		maxAttempts int
+		maxBackoff  time.Duration
+	}{
+		{
+			name:        "standard with limits",
+			retry:       options.Retry{Mode: options.RetryModeStandard, MaxAttempts: 5, MaxBackoff: time.Minute},
+			maxAttempts: 5,
+			maxBackoff:  time.Minute,
+		},
+		{
+			name:        "default",
+			retry:       options.Retry{},
+			adaptive:    true,
+			maxAttempts: retry.DefaultMaxAttempts,
+			maxBackoff:  retry.DefaultMaxBackoff,
+		},
+		{
+			name:        "adaptive",
+			retry:       options.Retry{Mode: options.RetryModeAdaptive},
+			adaptive:    true,
+			maxAttempts: retry.DefaultMaxAttempts,
+			maxBackoff:  retry.DefaultMaxBackoff,
+		},
+		{
+			name:        "adaptive with limits",
+			retry:       options.Retry{Mode: options.RetryModeAdaptive, MaxAttempts: 10, MaxBackoff: 5 * time.Second},
+			adaptive:    true,
+			maxAttempts: 10,
+			maxBackoff:  5 * time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			retryer := getRetryer(test.retry)()
+			if _, adaptive := retryer.(*retry.AdaptiveMode); adaptive != test.adaptive {
+				t.Errorf("expected adaptive %v, got %T", test.adaptive, retryer)
+			}
+			if retryer.MaxAttempts() != test.maxAttempts {
+				t.Errorf("expected %d attempts, got %d", test.maxAttempts, retryer.MaxAttempts())
+			}
+
+			// the backoff is capped and jittered
+			delay, err := retryer.RetryDelay(30, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if delay > test.maxBackoff {
+				t.Errorf("expected a delay of at most %s, got %s", test.maxBackoff, delay)
+			}
+		})
+	}
+}
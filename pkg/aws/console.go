@@ -0,0 +1,43 @@
+package aws
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// CloudInitOutputLog is the log cloud-init writes the output of the user
+// data to
+const CloudInitOutputLog = "/var/log/cloud-init-output.log"
+
+// GetConsoleOutput returns the latest serial console output of the instance.
+// It is empty until the instance has written to the console.
+func GetConsoleOutput(ctx context.Context, svc EC2Client, instanceID string) (string, error) {
+	result, err := svc.GetConsoleOutput(ctx, &ec2.GetConsoleOutputInput{
+		InstanceId: aws.String(instanceID),
+		Latest:     aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	output, err := base64.StdEncoding.DecodeString(aws.ToString(result.Output))
+	if err != nil {
+		return "", err
+	}
+
+	return string(output), nil
+}
+
+// TailLines returns the last n lines of output
+func TailLines(output string, n int) string {
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+
+	return strings.Join(lines, "\n")
+}
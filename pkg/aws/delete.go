@@ -0,0 +1,258 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// machineStateFiles are the files the provider caches in the machine folder
+var machineStateFiles = []string{diskSizeFile}
+
+// DeleteMachine terminates every instance of the machine, waits until they
+// are terminated and deletes the resources created for the machine: elastic
+// IPs, volumes unless AWS_KEEP_VOLUMES is enabled, snapshots and security
+// groups tagged with the machine ID, and the state cached in the machine
+// folder. Kept volumes are tagged devpod:keep-alive, so gc keeps them too.
+// It succeeds if nothing is left, so a half-deleted machine can be deleted
+// again.
+func DeleteMachine(
+	ctx context.Context,
+	providerAws *AwsProvider,
+	logs log.Logger,
+) error {
+	svc := providerAws.EC2
+	machineID := providerAws.Config.MachineID
+
+	result, err := GetDevpodInstance(ctx, svc, machineID)
+	if err != nil {
+		return err
+	}
+
+	instanceIDs := []string{}
+	for _, reservation := range result.Reservations {
+		for _, instance := range reservation.Instances {
+			instanceIDs = append(instanceIDs, aws.ToString(instance.InstanceId))
+		}
+	}
+
+	if len(instanceIDs) == 0 {
+		logs.Infof("No instance of machine %s found", machineID)
+	} else {
+		logs.Infof("Terminating instances %s of machine %s", strings.Join(instanceIDs, ", "), machineID)
+
+		_, err := svc.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
+			InstanceIds: instanceIDs,
+		})
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+
+		err = ec2.NewInstanceTerminatedWaiter(svc, jitterTerminatedWaiter).Wait(ctx, &ec2.DescribeInstancesInput{
+			InstanceIds: instanceIDs,
+		}, instanceStateTimeout)
+		if err != nil {
+			return errors.Wrapf(err, "wait for instances %s to terminate", strings.Join(instanceIDs, ", "))
+		}
+	}
+
+	resources, err := findMachineResources(ctx, svc, machineID, providerAws.Config.KeepVolumes)
+	if err != nil {
+		return err
+	}
+
+	if providerAws.Config.KeepVolumes {
+		err := keepMachineVolumes(ctx, svc, machineID, logs)
+		if err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	for _, resource := range resources {
+		logs.Infof("Deleting %s %s of machine %s", resource.Kind, resource.ID, machineID)
+
+		err := DeleteOrphanedResource(ctx, svc, providerAws.IAM, resource, false)
+		if err != nil && !isNotFound(err) {
+			failed++
+			logs.Errorf("Failed to delete %s %s: %v", resource.Kind, resource.ID, err)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to delete %d of %d resources of machine %s", failed, len(resources), machineID)
+	}
+
+	return ClearMachineState(providerAws.Config.MachineFolder)
+}
+
+// keepMachineVolumes tags the unattached volumes of the machine with
+// devpod:keep-alive, as gc deletes unattached devpod volumes otherwise
+func keepMachineVolumes(ctx context.Context, svc EC2Client, machineID string, logs log.Logger) error {
+	volumes, err := svc.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("tag:devpod"),
+				Values: []string{machineID},
+			},
+			{
+				Name:   aws.String("status"),
+				Values: []string{string(types.VolumeStateAvailable)},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	volumeIDs := []string{}
+	for _, volume := range volumes.Volumes {
+		volumeIDs = append(volumeIDs, aws.ToString(volume.VolumeId))
+	}
+
+	if len(volumeIDs) == 0 {
+		return nil
+	}
+
+	logs.Infof("Keeping volumes %s of machine %s, tagged %s=true", strings.Join(volumeIDs, ", "), machineID, TagKeyKeepAlive)
+	_, err = svc.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: volumeIDs,
+		Tags: []types.Tag{
+			{
+				Key:   aws.String(TagKeyKeepAlive),
+				Value: aws.String("true"),
+			},
+		},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "tag kept volumes %s", strings.Join(volumeIDs, ", "))
+	}
+
+	return nil
+}
+
+// ClearMachineState removes the state the provider cached in the machine
+// folder
+func ClearMachineState(machineFolder string) error {
+	if machineFolder == "" {
+		return nil
+	}
+
+	for _, name := range machineStateFiles {
+		err := os.Remove(filepath.Join(machineFolder, name))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findMachineResources returns the elastic IPs, volumes, snapshots and
+// security groups tagged with the machine ID, in the order they can be
+// deleted
+func findMachineResources(
+	ctx context.Context,
+	svc EC2Client,
+	machineID string,
+	keepVolumes bool,
+) ([]OrphanedResource, error) {
+	resources := []OrphanedResource{}
+	filters := []types.Filter{
+		{
+			Name:   aws.String("tag:devpod"),
+			Values: []string{machineID},
+		},
+	}
+	reason := fmt.Sprintf("machine %s is deleted", machineID)
+
+	addresses, err := svc.DescribeAddresses(ctx, &ec2.DescribeAddressesInput{
+		Filters: filters,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, address := range addresses.Addresses {
+		resources = append(resources, OrphanedResource{
+			Kind:      OrphanedAddress,
+			ID:        aws.ToString(address.AllocationId),
+			MachineID: machineID,
+			Reason:    reason,
+		})
+	}
+
+	if !keepVolumes {
+		volumes, err := svc.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{
+			Filters: append(filters, types.Filter{
+				Name:   aws.String("status"),
+				Values: []string{string(types.VolumeStateAvailable)},
+			}),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, volume := range volumes.Volumes {
+			resources = append(resources, OrphanedResource{
+				Kind:      OrphanedVolume,
+				ID:        aws.ToString(volume.VolumeId),
+				MachineID: machineID,
+				Reason:    reason,
+			})
+		}
+	}
+
+	snapshots, err := svc.DescribeSnapshots(ctx, &ec2.DescribeSnapshotsInput{
+		OwnerIds: []string{"self"},
+		Filters:  filters,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, snapshot := range snapshots.Snapshots {
+		resources = append(resources, OrphanedResource{
+			Kind:      OrphanedSnapshot,
+			ID:        aws.ToString(snapshot.SnapshotId),
+			MachineID: machineID,
+			Reason:    reason,
+		})
+	}
+
+	groups, err := svc.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+		Filters: filters,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, group := range groups.SecurityGroups {
+		resources = append(resources, OrphanedResource{
+			Kind:      OrphanedSecurityGroup,
+			ID:        aws.ToString(group.GroupId),
+			MachineID: machineID,
+			Reason:    reason,
+		})
+	}
+
+	SortOrphanedResources(resources)
+
+	return resources, nil
+}
+
+// isNotFound reports whether err is caused by a resource that does not
+// exist (anymore)
+func isNotFound(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && strings.HasSuffix(apiErr.ErrorCode(), ".NotFound")
+}
@@ -0,0 +1,105 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Discovery holds the resources a machine is created with
+type Discovery struct {
+	Image              string
+	RootDevice         string
+	SecurityGroupIDs   []string
+	SubnetID           string
+	InstanceProfileArn string
+}
+
+// Discover resolves the AMI, root device, security groups, subnet and
+// instance profile of the machine concurrently. The defaults of the EC2
+// instance the provider runs on are already applied by NewProvider.
+func Discover(ctx context.Context, providerAws *AwsProvider) (*Discovery, error) {
+	config := providerAws.Config
+	discovery := &Discovery{}
+
+	lookups := []func() error{
+		func() error {
+			discovery.Image = config.DiskImage
+			if discovery.Image == "" {
+				image, err := GetDefaultAMI(ctx, providerAws.EC2, config.MachineType)
+				if err != nil {
+					return errors.Wrap(err, "find AMI")
+				}
+				discovery.Image = image
+			}
+
+			discovery.RootDevice = config.RootDevice
+			if discovery.RootDevice == "" {
+				device, err := GetAMIRootDevice(ctx, providerAws.EC2, discovery.Image)
+				if err != nil {
+					return errors.Wrap(err, "find root device")
+				}
+				discovery.RootDevice = device
+			}
+
+			return nil
+		},
+		func() error {
+			securityGroupIDs, err := GetDevpodSecurityGroups(ctx, providerAws)
+			if err != nil {
+				return errors.Wrap(err, "find security groups")
+			}
+
+			discovery.SecurityGroupIDs = securityGroupIDs
+			return nil
+		},
+		func() error {
+			discovery.SubnetID = config.SubnetID
+			if config.VpcID == "" || config.SubnetID != "" {
+				return nil
+			}
+
+			subnetID, err := GetSubnetID(ctx, providerAws)
+			if err != nil {
+				return errors.Wrap(err, "find subnet")
+			} else if subnetID == "" {
+				return fmt.Errorf("could not find a matching SubnetID in VPC %s, please specify one", config.VpcID)
+			}
+
+			discovery.SubnetID = subnetID
+			return nil
+		},
+		func() error {
+			profile, err := GetDevpodInstanceProfile(ctx, providerAws)
+			if err != nil {
+				providerAws.Log.Warnf("Unable to find or create the instance profile, the instance will have none: %v", err)
+				return nil
+			}
+
+			discovery.InstanceProfileArn = profile
+			return nil
+		},
+	}
+
+	errs := make([]error, len(lookups))
+	wg := sync.WaitGroup{}
+	for i, lookup := range lookups {
+		wg.Add(1)
+		go func(i int, lookup func() error) {
+			defer wg.Done()
+
+			errs[i] = lookup()
+		}(i, lookup)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return discovery, nil
+}
@@ -0,0 +1,33 @@
+package aws
+
+import (
+	"context"
+	"testing"
+
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+)
+
+func TestDiscover(t *testing.T) {
+	ec2Client := fake.NewDefaultEC2()
+	provider, iamClient := newTestProvider(t, ec2Client)
+	iamClient.AddInstanceProfile("devpod-ec2-role")
+	provider.Config.DiskImage = ""
+	provider.Config.RootDevice = ""
+
+	discovery, err := Discover(context.Background(), provider)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if discovery.Image != "ami-ubuntu-x86" {
+		t.Errorf("expected image ami-ubuntu-x86, got %q", discovery.Image)
+	}
+	if discovery.RootDevice == "" {
+		t.Errorf("expected root device")
+	}
+	if len(discovery.SecurityGroupIDs) != 1 {
+		t.Errorf("expected the devpod security group, got %v", discovery.SecurityGroupIDs)
+	}
+	if discovery.InstanceProfileArn == "" {
+		t.Errorf("expected instance profile")
+	}
+}
@@ -0,0 +1,319 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// ErrVolumeShrink is returned if the configured disk size is smaller than
+// the root volume, EBS volumes cannot shrink
+var ErrVolumeShrink = errors.New("shrinking the root volume is not supported")
+
+// volumeModificationPollInterval is the time between checks of a volume
+// modification
+var volumeModificationPollInterval = 5 * time.Second
+
+// volumeModificationInterval is the time EC2 requires between two
+// modifications of a volume
+const volumeModificationInterval = 6 * time.Hour
+
+// VolumeModificationRateError is returned if the root volume was already
+// modified within volumeModificationInterval
+type VolumeModificationRateError struct {
+	VolumeID string
+
+	// NextAttempt is when the volume can be modified again, zero if the
+	// previous modification is unknown
+	NextAttempt time.Time
+	Err         error
+}
+
+func (e *VolumeModificationRateError) Error() string {
+	if e.NextAttempt.IsZero() {
+		return fmt.Sprintf("volume %s was modified within the last 6 hours and cannot be modified yet", e.VolumeID)
+	}
+
+	return fmt.Sprintf(
+		"volume %s was modified within the last 6 hours and can be modified again after %s",
+		e.VolumeID,
+		e.NextAttempt.Local().Format(time.RFC1123),
+	)
+}
+
+func (e *VolumeModificationRateError) Unwrap() error {
+	return e.Err
+}
+
+// diskSizeFile stores the progress of growing the root volume and
+// filesystem of the machine to AWS_DISK_SIZE, so the volume is only checked
+// again if AWS_DISK_SIZE changes
+const diskSizeFile = "disk-size"
+
+// maxDiskSizeAttempts is how often growing the root volume and filesystem
+// to a disk size is attempted before giving up
+const maxDiskSizeAttempts = 3
+
+// diskSizeState is the progress of growing the root volume and filesystem
+// to a disk size
+type diskSizeState struct {
+	SizeGB int `json:"sizeGB"`
+
+	// FilesystemPending is set once the volume was grown, until the
+	// filesystem is grown over SSH
+	FilesystemPending bool `json:"filesystemPending,omitempty"`
+	Done              bool `json:"done,omitempty"`
+	Failures          int  `json:"failures,omitempty"`
+}
+
+// GrowRootFilesystemScript grows the partition and filesystem of / to the
+// size of the underlying volume. growpart exits with 1 if there is nothing
+// to grow, so its result is ignored.
+const GrowRootFilesystemScript = `set -e
+source=$(findmnt -n -o SOURCE /)
+fstype=$(findmnt -n -o FSTYPE /)
+disk=$(lsblk -n -o PKNAME "$source" | head -n 1)
+partition=$(cat "/sys/class/block/$(basename "$source")/partition" 2>/dev/null || true)
+if [ -n "$disk" ] && [ -n "$partition" ]; then
+  growpart "/dev/$disk" "$partition" || true
+fi
+case "$fstype" in
+  ext2|ext3|ext4) resize2fs "$source" ;;
+  xfs) xfs_growfs -d / ;;
+  *) echo "unsupported root filesystem $fstype" >&2; exit 1 ;;
+esac
+`
+
+// GrowRootVolume grows the root volume of the instance to sizeGB and waits
+// until the new size can be used by the filesystem. It returns whether the
+// volume was modified, ErrVolumeShrink if sizeGB is smaller than the volume
+// and a VolumeModificationRateError if the volume was modified too recently.
+func GrowRootVolume(
+	ctx context.Context,
+	svc EC2Client,
+	instance types.Instance,
+	sizeGB int,
+	logs log.Logger,
+) (bool, error) {
+	volumeID := getRootVolumeID(instance)
+	if volumeID == "" {
+		return false, fmt.Errorf("no root volume found for instance %s", aws.ToString(instance.InstanceId))
+	}
+
+	result, err := svc.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{
+		VolumeIds: []string{volumeID},
+	})
+	if err != nil {
+		return false, err
+	} else if len(result.Volumes) == 0 {
+		return false, fmt.Errorf("root volume %s not found", volumeID)
+	}
+
+	current := int(aws.ToInt32(result.Volumes[0].Size))
+	switch {
+	case sizeGB == current:
+		return false, nil
+	case sizeGB < current:
+		return false, fmt.Errorf("%w: the disk size is %dGB, but the root volume %s already has %dGB", ErrVolumeShrink, sizeGB, volumeID, current)
+	}
+
+	// a volume can only be modified once the previous modification is
+	// optimizing, and at most once every 6 hours. A previous modification
+	// that failed does not block a new one.
+	err = waitForVolumeModification(ctx, svc, volumeID, nil)
+	if err != nil {
+		return false, err
+	}
+
+	logs.Infof("Growing root volume %s from %dGB to %dGB", volumeID, current, sizeGB)
+	modification, err := svc.ModifyVolume(ctx, &ec2.ModifyVolumeInput{
+		VolumeId: aws.String(volumeID),
+		Size:     aws.Int32(int32(sizeGB)),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "VolumeModificationRateExceeded" {
+			return false, &VolumeModificationRateError{
+				VolumeID:    volumeID,
+				NextAttempt: getNextVolumeModification(ctx, svc, volumeID),
+				Err:         err,
+			}
+		}
+
+		return false, errors.Wrapf(err, "modify volume %s", volumeID)
+	}
+
+	err = waitForVolumeModification(ctx, svc, volumeID, modification.VolumeModification)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// IsDiskSizeReconciled reports whether the root volume and filesystem of the
+// machine were already grown to sizeGB, or growing them failed too often
+func IsDiskSizeReconciled(machineFolder string, sizeGB int) bool {
+	state := loadDiskSizeState(machineFolder, sizeGB)
+
+	return state.Done || state.Failures >= maxDiskSizeAttempts
+}
+
+// SetDiskSizeReconciled records that the root volume and filesystem of the
+// machine were grown to sizeGB
+func SetDiskSizeReconciled(machineFolder string, sizeGB int) error {
+	return saveDiskSizeState(machineFolder, &diskSizeState{SizeGB: sizeGB, Done: true})
+}
+
+// IsFilesystemGrowPending reports whether the root volume was grown to
+// sizeGB, but the filesystem not yet
+func IsFilesystemGrowPending(machineFolder string, sizeGB int) bool {
+	return loadDiskSizeState(machineFolder, sizeGB).FilesystemPending
+}
+
+// SetFilesystemGrowPending records that the root volume was grown to sizeGB,
+// so the filesystem has to be grown
+func SetFilesystemGrowPending(machineFolder string, sizeGB int) error {
+	return saveDiskSizeState(machineFolder, &diskSizeState{SizeGB: sizeGB, FilesystemPending: true})
+}
+
+// RecordDiskSizeFailure records a failed attempt to grow the root volume or
+// filesystem to sizeGB. It returns true once growing is given up.
+func RecordDiskSizeFailure(machineFolder string, sizeGB int) (bool, error) {
+	state := loadDiskSizeState(machineFolder, sizeGB)
+	state.Failures++
+
+	return state.Failures >= maxDiskSizeAttempts, saveDiskSizeState(machineFolder, state)
+}
+
+// loadDiskSizeState returns the progress of growing to sizeGB, a fresh state
+// if none is stored or it belongs to another size
+func loadDiskSizeState(machineFolder string, sizeGB int) *diskSizeState {
+	content, err := os.ReadFile(filepath.Join(machineFolder, diskSizeFile))
+	if err != nil {
+		return &diskSizeState{SizeGB: sizeGB}
+	}
+
+	state := &diskSizeState{}
+	if size, err := strconv.Atoi(strings.TrimSpace(string(content))); err == nil {
+		// stored as a plain size by earlier versions once it was reached
+		state = &diskSizeState{SizeGB: size, Done: true}
+	} else if err := json.Unmarshal(content, state); err != nil {
+		return &diskSizeState{SizeGB: sizeGB}
+	}
+
+	if state.SizeGB != sizeGB {
+		return &diskSizeState{SizeGB: sizeGB}
+	}
+
+	return state
+}
+
+func saveDiskSizeState(machineFolder string, state *diskSizeState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(machineFolder, diskSizeFile), content, 0o644)
+}
+
+func getRootVolumeID(instance types.Instance) string {
+	for _, mapping := range instance.BlockDeviceMappings {
+		if mapping.Ebs == nil {
+			continue
+		}
+
+		if aws.ToString(mapping.DeviceName) == aws.ToString(instance.RootDeviceName) {
+			return aws.ToString(mapping.Ebs.VolumeId)
+		}
+	}
+
+	return ""
+}
+
+// getNextVolumeModification returns when the volume can be modified again,
+// zero if its last modification cannot be determined
+func getNextVolumeModification(ctx context.Context, svc EC2Client, volumeID string) time.Time {
+	result, err := svc.DescribeVolumesModifications(ctx, &ec2.DescribeVolumesModificationsInput{
+		VolumeIds: []string{volumeID},
+	})
+	if err != nil {
+		return time.Time{}
+	}
+
+	last := time.Time{}
+	for _, modification := range result.VolumesModifications {
+		if startTime := aws.ToTime(modification.StartTime); startTime.After(last) {
+			last = startTime
+		}
+	}
+	if last.IsZero() {
+		return time.Time{}
+	}
+
+	return last.Add(volumeModificationInterval)
+}
+
+// waitForVolumeModification waits until the modifications of the volume are
+// optimizing or completed. Only a failure of current is returned as an
+// error, earlier modifications that failed do not matter.
+func waitForVolumeModification(
+	ctx context.Context,
+	svc EC2Client,
+	volumeID string,
+	current *types.VolumeModification,
+) error {
+	timeoutCtx, cancel := context.WithTimeout(ctx, instanceStateTimeout)
+	defer cancel()
+
+	for {
+		result, err := svc.DescribeVolumesModifications(timeoutCtx, &ec2.DescribeVolumesModificationsInput{
+			VolumeIds: []string{volumeID},
+		})
+		if err != nil {
+			var apiErr smithy.APIError
+			if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidVolumeModification.NotFound" {
+				return nil
+			}
+
+			return errors.Wrapf(err, "describe modifications of volume %s", volumeID)
+		}
+
+		done := true
+		for _, modification := range result.VolumesModifications {
+			switch modification.ModificationState {
+			case types.VolumeModificationStateFailed:
+				if current == nil || !aws.ToTime(modification.StartTime).Equal(aws.ToTime(current.StartTime)) {
+					continue
+				}
+
+				return fmt.Errorf("modification of volume %s failed: %s", volumeID, aws.ToString(modification.StatusMessage))
+			case types.VolumeModificationStateModifying:
+				done = false
+			}
+		}
+
+		if done {
+			return nil
+		}
+
+		select {
+		case <-timeoutCtx.Done():
+			return fmt.Errorf("timed out waiting for the modification of volume %s", volumeID)
+		case <-time.After(jitter(volumeModificationPollInterval)):
+		}
+	}
+}
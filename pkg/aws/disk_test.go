@@ -0,0 +1,200 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/sirupsen/logrus"
+)
+
+func TestGrowRootVolume(t *testing.T) {
+	volumeModificationPollInterval = time.Millisecond
+
+	tests := []struct {
+		name          string
+		volumeSize    int32
+		sizeGB        int
+		modifications []types.VolumeModification
+		expected      int32
+		modified      bool
+		err           error
+	}{
+		{
+			name:       "grow volume",
+			volumeSize: 40,
+			sizeGB:     80,
+			expected:   80,
+			modified:   true,
+		},
+		{
+			name:       "same size",
+			volumeSize: 40,
+			sizeGB:     40,
+			expected:   40,
+		},
+		{
+			name:       "refuse shrink",
+			volumeSize: 80,
+			sizeGB:     40,
+			expected:   80,
+			err:        ErrVolumeShrink,
+		},
+		{
+			name:       "previous modification still optimizing",
+			volumeSize: 40,
+			sizeGB:     80,
+			modifications: []types.VolumeModification{
+				{VolumeId: aws.String("vol-1"), ModificationState: types.VolumeModificationStateOptimizing},
+			},
+			expected: 80,
+			modified: true,
+		},
+		{
+			name:       "previous modification failed",
+			volumeSize: 40,
+			sizeGB:     80,
+			modifications: []types.VolumeModification{
+				{VolumeId: aws.String("vol-1"), ModificationState: types.VolumeModificationStateFailed},
+			},
+			expected: 80,
+			modified: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec2Client := fake.NewDefaultEC2()
+			ec2Client.Volumes = []types.Volume{{VolumeId: aws.String("vol-1"), Size: aws.Int32(test.volumeSize)}}
+			ec2Client.VolumeModifications = test.modifications
+
+			instance := types.Instance{
+				InstanceId:     aws.String("i-1"),
+				RootDeviceName: aws.String("/dev/sda1"),
+				BlockDeviceMappings: []types.InstanceBlockDeviceMapping{
+					{DeviceName: aws.String("/dev/sda1"), Ebs: &types.EbsInstanceBlockDevice{VolumeId: aws.String("vol-1")}},
+				},
+			}
+
+			logs := log.NewStreamLogger(io.Discard, io.Discard, logrus.DebugLevel)
+			modified, err := GrowRootVolume(context.Background(), ec2Client, instance, test.sizeGB, logs)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+
+			if modified != test.modified {
+				t.Errorf("expected modified %v, got %v", test.modified, modified)
+			}
+
+			if size := aws.ToInt32(ec2Client.Volumes[0].Size); size != test.expected {
+				t.Errorf("expected size %d, got %d", test.expected, size)
+			}
+		})
+	}
+}
+
+func TestGrowRootVolumeRateExceeded(t *testing.T) {
+	lastModified := time.Now().Add(-time.Hour)
+
+	ec2Client := fake.NewDefaultEC2()
+	ec2Client.Volumes = []types.Volume{{VolumeId: aws.String("vol-1"), Size: aws.Int32(40)}}
+	ec2Client.VolumeModifications = []types.VolumeModification{{
+		VolumeId:          aws.String("vol-1"),
+		ModificationState: types.VolumeModificationStateCompleted,
+		StartTime:         aws.Time(lastModified),
+	}}
+
+	instance := types.Instance{
+		InstanceId:     aws.String("i-1"),
+		RootDeviceName: aws.String("/dev/sda1"),
+		BlockDeviceMappings: []types.InstanceBlockDeviceMapping{
+			{DeviceName: aws.String("/dev/sda1"), Ebs: &types.EbsInstanceBlockDevice{VolumeId: aws.String("vol-1")}},
+		},
+	}
+
+	logs := log.NewStreamLogger(io.Discard, io.Discard, logrus.DebugLevel)
+	_, err := GrowRootVolume(context.Background(), ec2Client, instance, 80, logs)
+
+	var rateErr *VolumeModificationRateError
+	if !errors.As(err, &rateErr) {
+		t.Fatalf("expected a rate error, got %v", err)
+	}
+	if !rateErr.NextAttempt.Equal(lastModified.Add(6 * time.Hour)) {
+		t.Errorf("expected the next attempt at %s, got %s", lastModified.Add(6*time.Hour), rateErr.NextAttempt)
+	}
+}
+
+func TestGrowRootVolumeWithoutRootVolume(t *testing.T) {
+	logs := log.NewStreamLogger(io.Discard, io.Discard, logrus.DebugLevel)
+	_, err := GrowRootVolume(context.Background(), fake.NewDefaultEC2(), types.Instance{InstanceId: aws.String("i-1")}, 80, logs)
+	if err == nil || err.Error() != "no root volume found for instance i-1" {
+		t.Fatalf("expected missing root volume error, got %v", err)
+	}
+}
+
+func TestDiskSizeReconciled(t *testing.T) {
+	machineFolder := t.TempDir()
+	if IsDiskSizeReconciled(machineFolder, 40) {
+		t.Fatal("expected disk size not to be reconciled")
+	}
+
+	err := SetDiskSizeReconciled(machineFolder, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsDiskSizeReconciled(machineFolder, 40) {
+		t.Error("expected disk size 40 to be reconciled")
+	}
+	if IsDiskSizeReconciled(machineFolder, 80) {
+		t.Error("expected disk size 80 not to be reconciled")
+	}
+}
+
+func TestDiskSizeState(t *testing.T) {
+	machineFolder := t.TempDir()
+
+	err := SetFilesystemGrowPending(machineFolder, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if IsDiskSizeReconciled(machineFolder, 40) || !IsFilesystemGrowPending(machineFolder, 40) {
+		t.Error("expected the filesystem of 40GB to be pending")
+	}
+	if IsFilesystemGrowPending(machineFolder, 80) {
+		t.Error("expected no pending filesystem for 80GB")
+	}
+
+	for attempt := 1; attempt <= maxDiskSizeAttempts; attempt++ {
+		gaveUp, err := RecordDiskSizeFailure(machineFolder, 40)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gaveUp != (attempt == maxDiskSizeAttempts) {
+			t.Errorf("attempt %d: expected given up %v, got %v", attempt, attempt == maxDiskSizeAttempts, gaveUp)
+		}
+	}
+	if !IsDiskSizeReconciled(machineFolder, 40) {
+		t.Error("expected disk size 40 not to be retried after the failures")
+	}
+	if IsDiskSizeReconciled(machineFolder, 80) {
+		t.Error("expected a new disk size to be tried again")
+	}
+
+	// stored by earlier versions
+	err = os.WriteFile(filepath.Join(machineFolder, diskSizeFile), []byte("40"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsDiskSizeReconciled(machineFolder, 40) {
+		t.Error("expected the legacy disk size 40 to be reconciled")
+	}
+}
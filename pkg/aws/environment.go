@@ -0,0 +1,114 @@
+package aws
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/loft-sh/devpod-provider-aws/pkg/options"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// ec2DetectionTimeout is the maximum time to wait for the instance metadata
+// service, so hosts outside of EC2 are not slowed down
+var ec2DetectionTimeout = time.Second
+
+// EC2Environment describes the EC2 instance the provider runs on, e.g. a CI
+// runner
+type EC2Environment struct {
+	InstanceID       string
+	Region           string
+	VpcID            string
+	SubnetID         string
+	SecurityGroupIDs []string
+}
+
+// NewIMDSClient returns a client for the instance metadata service that
+// fails fast instead of retrying, as the provider mostly runs outside of EC2
+func NewIMDSClient(cfg aws.Config) *imds.Client {
+	return imds.NewFromConfig(cfg, func(o *imds.Options) {
+		o.Retryer = aws.NopRetryer{}
+	})
+}
+
+// DetectEC2Environment returns the EC2 instance the provider runs on from
+// the instance metadata service (IMDSv2), or nil if it does not run on EC2
+// or the service does not answer within ec2DetectionTimeout
+func DetectEC2Environment(ctx context.Context, metadata IMDSClient) *EC2Environment {
+	ctx, cancel := context.WithTimeout(ctx, ec2DetectionTimeout)
+	defer cancel()
+
+	document, err := metadata.GetInstanceIdentityDocument(ctx, &imds.GetInstanceIdentityDocumentInput{})
+	if err != nil || document.InstanceID == "" {
+		return nil
+	}
+
+	environment := &EC2Environment{
+		InstanceID: document.InstanceID,
+		Region:     document.Region,
+	}
+
+	// the network of the primary network interface
+	mac, err := getMetadata(ctx, metadata, "mac")
+	if err != nil {
+		return environment
+	}
+
+	prefix := "network/interfaces/macs/" + mac + "/"
+	environment.VpcID, _ = getMetadata(ctx, metadata, prefix+"vpc-id")
+	environment.SubnetID, _ = getMetadata(ctx, metadata, prefix+"subnet-id")
+
+	securityGroupIDs, _ := getMetadata(ctx, metadata, prefix+"security-group-ids")
+	environment.SecurityGroupIDs = strings.Fields(securityGroupIDs)
+
+	return environment
+}
+
+// ApplyEC2Defaults uses the region and network of the EC2 instance the
+// provider runs on for the options that are not set. Its subnet is used if
+// neither a VPC nor a subnet is configured, its security groups if the
+// instance is created in its VPC.
+func ApplyEC2Defaults(config *options.Options, cfg *aws.Config, environment *EC2Environment, logs log.Logger) {
+	if environment.Region != "" {
+		if cfg.Region == "" {
+			cfg.Region = environment.Region
+		}
+		if config.Zone == "" {
+			config.Zone = environment.Region
+		}
+	}
+
+	if cfg.Region != environment.Region || environment.VpcID == "" {
+		return
+	}
+
+	if config.SubnetID == "" && config.VpcID == "" && environment.SubnetID != "" {
+		logs.Debugf("using subnet %s of instance %s", environment.SubnetID, environment.InstanceID)
+		config.VpcID = environment.VpcID
+		config.SubnetID = environment.SubnetID
+	}
+
+	if config.SecurityGroupID == "" && config.VpcID == environment.VpcID && len(environment.SecurityGroupIDs) > 0 {
+		logs.Debugf("using security groups %s of instance %s", strings.Join(environment.SecurityGroupIDs, ","), environment.InstanceID)
+		config.SecurityGroupID = strings.Join(environment.SecurityGroupIDs, ",")
+	}
+}
+
+func getMetadata(ctx context.Context, metadata IMDSClient, path string) (string, error) {
+	result, err := metadata.GetMetadata(ctx, &imds.GetMetadataInput{Path: path})
+	if err != nil {
+		return "", errors.Wrapf(err, "get metadata %s", path)
+	}
+	defer result.Content.Close()
+
+	content, err := io.ReadAll(result.Content)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(content)), nil
+}
@@ -0,0 +1,111 @@
+package aws
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+	"github.com/loft-sh/devpod-provider-aws/pkg/options"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/sirupsen/logrus"
+)
+
+func newTestIMDS() *fake.IMDS {
+	metadata := fake.NewIMDS("i-runner", "eu-west-1")
+	metadata.Metadata["mac"] = "0a:00:00:00:00:01"
+	metadata.Metadata["network/interfaces/macs/0a:00:00:00:00:01/vpc-id"] = "vpc-runner"
+	metadata.Metadata["network/interfaces/macs/0a:00:00:00:00:01/subnet-id"] = "subnet-runner"
+	metadata.Metadata["network/interfaces/macs/0a:00:00:00:00:01/security-group-ids"] = "sg-1\nsg-2\n"
+
+	return metadata
+}
+
+func TestDetectEC2Environment(t *testing.T) {
+	environment := DetectEC2Environment(context.Background(), newTestIMDS())
+	expected := &EC2Environment{
+		InstanceID:       "i-runner",
+		Region:           "eu-west-1",
+		VpcID:            "vpc-runner",
+		SubnetID:         "subnet-runner",
+		SecurityGroupIDs: []string{"sg-1", "sg-2"},
+	}
+	if !reflect.DeepEqual(environment, expected) {
+		t.Errorf("expected %+v, got %+v", expected, environment)
+	}
+
+	notEC2 := fake.NewIMDS("", "")
+	notEC2.Errors["GetInstanceIdentityDocument"] = context.DeadlineExceeded
+	if environment := DetectEC2Environment(context.Background(), notEC2); environment != nil {
+		t.Errorf("expected no EC2 environment, got %+v", environment)
+	}
+}
+
+func TestApplyEC2Defaults(t *testing.T) {
+	environment := DetectEC2Environment(context.Background(), newTestIMDS())
+
+	tests := []struct {
+		name     string
+		region   string
+		config   options.Options
+		expected options.Options
+	}{
+		{
+			name: "defaults",
+			expected: options.Options{
+				Zone:            "eu-west-1",
+				VpcID:           "vpc-runner",
+				SubnetID:        "subnet-runner",
+				SecurityGroupID: "sg-1,sg-2",
+			},
+		},
+		{
+			name:   "other region",
+			region: "us-east-1",
+			config: options.Options{Zone: "us-east-1"},
+			expected: options.Options{
+				Zone: "us-east-1",
+			},
+		},
+		{
+			name:   "same VPC",
+			config: options.Options{VpcID: "vpc-runner"},
+			expected: options.Options{
+				Zone:            "eu-west-1",
+				VpcID:           "vpc-runner",
+				SecurityGroupID: "sg-1,sg-2",
+			},
+		},
+		{
+			name:   "other VPC",
+			config: options.Options{VpcID: "vpc-other"},
+			expected: options.Options{
+				Zone:  "eu-west-1",
+				VpcID: "vpc-other",
+			},
+		},
+		{
+			name:   "configured subnet and security groups",
+			config: options.Options{SubnetID: "subnet-other", SecurityGroupID: "sg-other"},
+			expected: options.Options{
+				Zone:            "eu-west-1",
+				SubnetID:        "subnet-other",
+				SecurityGroupID: "sg-other",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := aws.Config{Region: test.region}
+			config := test.config
+
+			ApplyEC2Defaults(&config, &cfg, environment, log.NewStreamLogger(io.Discard, io.Discard, logrus.DebugLevel))
+			if !reflect.DeepEqual(config, test.expected) {
+				t.Errorf("expected %+v, got %+v", test.expected, config)
+			}
+		})
+	}
+}
@@ -0,0 +1,345 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"github.com/loft-sh/devpod-provider-aws/pkg/options"
+	"github.com/pkg/errors"
+)
+
+var encodedMessageRegex = regexp.MustCompile(`Encoded authorization failure message: ([A-Za-z0-9_\-]+)`)
+
+// AuthorizationError is an UnauthorizedOperation error whose encoded
+// authorization message has been decoded via STS
+type AuthorizationError struct {
+	Action     string
+	Resource   string
+	Principal  string
+	Statements []AuthorizationStatement
+
+	Err error
+}
+
+// AuthorizationStatement is a policy statement that matched a denied request
+type AuthorizationStatement struct {
+	ID        string
+	Effect    string
+	Actions   []string
+	Resources []string
+}
+
+func (e *AuthorizationError) Error() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "not authorized to perform %s on %s", e.Action, e.Resource)
+	if e.Principal != "" {
+		fmt.Fprintf(&b, "\n  principal: %s", e.Principal)
+	}
+
+	if len(e.Statements) == 0 {
+		b.WriteString("\n  no policy statement allows this action (implicit deny)")
+	}
+
+	for _, statement := range e.Statements {
+		id := statement.ID
+		if id == "" {
+			id = "<no sid>"
+		}
+
+		fmt.Fprintf(
+			&b,
+			"\n  matched statement %s: %s %s on %s",
+			id,
+			statement.Effect,
+			strings.Join(statement.Actions, ","),
+			strings.Join(statement.Resources, ","),
+		)
+	}
+
+	return b.String()
+}
+
+func (e *AuthorizationError) Unwrap() error {
+	return e.Err
+}
+
+// decodedAuthorizationMessage is the JSON document returned by
+// sts:DecodeAuthorizationMessage
+type decodedAuthorizationMessage struct {
+	MatchedStatements struct {
+		Items []struct {
+			StatementID string          `json:"statementId"`
+			Effect      string          `json:"effect"`
+			Actions     decodedItemList `json:"actions"`
+			Resources   decodedItemList `json:"resources"`
+		} `json:"items"`
+	} `json:"matchedStatements"`
+	Context struct {
+		Principal struct {
+			ID  string `json:"id"`
+			Arn string `json:"arn"`
+		} `json:"principal"`
+		Action   string `json:"action"`
+		Resource string `json:"resource"`
+	} `json:"context"`
+}
+
+type decodedItemList struct {
+	Items []struct {
+		Value string `json:"value"`
+	} `json:"items"`
+}
+
+func (l decodedItemList) values() []string {
+	values := []string{}
+	for _, item := range l.Items {
+		values = append(values, item.Value)
+	}
+
+	return values
+}
+
+// GetEncodedAuthorizationMessage returns the encoded authorization failure
+// message contained in an UnauthorizedOperation error, if any
+func GetEncodedAuthorizationMessage(err error) (string, bool) {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "UnauthorizedOperation" {
+		return "", false
+	}
+
+	match := encodedMessageRegex.FindStringSubmatch(apiErr.ErrorMessage())
+	if match == nil {
+		return "", false
+	}
+
+	return match[1], true
+}
+
+// DecodeAuthorizationError decodes the encoded message of an
+// UnauthorizedOperation error, errors of other kinds are returned unchanged.
+// If decoding is not permitted the raw error is returned with a hint.
+func DecodeAuthorizationError(ctx context.Context, err error) error {
+	if _, ok := GetEncodedAuthorizationMessage(err); !ok {
+		return err
+	}
+
+	retryOptions, cfgErr := options.RetryFromEnv()
+	if cfgErr != nil {
+		return authorizationHint(err)
+	}
+
+	cfg, cfgErr := NewAWSConfig(ctx, retryOptions)
+	if cfgErr != nil {
+		return authorizationHint(err)
+	}
+
+	return decodeAuthorizationError(ctx, sts.NewFromConfig(cfg), err)
+}
+
+// authorizationHint returns the undecoded error with a single hint, which
+// also tells how to decode the message
+func authorizationHint(err error) error {
+	return &ProviderError{
+		Kind: ErrorKindUnauthorized,
+		Hint: errorKindHints[ErrorKindUnauthorized] + "; to see which policy denied the request, grant " +
+			"sts:DecodeAuthorizationMessage or ask an administrator to run " +
+			"`aws sts decode-authorization-message --encoded-message <message>`",
+		Err: err,
+	}
+}
+
+func decodeAuthorizationError(ctx context.Context, svc STSClient, err error) error {
+	encodedMessage, ok := GetEncodedAuthorizationMessage(err)
+	if !ok {
+		return err
+	}
+
+	result, decodeErr := svc.DecodeAuthorizationMessage(ctx, &sts.DecodeAuthorizationMessageInput{
+		EncodedMessage: &encodedMessage,
+	})
+	if decodeErr != nil || result.DecodedMessage == nil {
+		return authorizationHint(err)
+	}
+
+	message := &decodedAuthorizationMessage{}
+	if jsonErr := json.Unmarshal([]byte(*result.DecodedMessage), message); jsonErr != nil {
+		return authorizationHint(err)
+	}
+
+	authErr := &AuthorizationError{
+		Action:    message.Context.Action,
+		Resource:  message.Context.Resource,
+		Principal: message.Context.Principal.Arn,
+		Err:       err,
+	}
+
+	for _, statement := range message.MatchedStatements.Items {
+		authErr.Statements = append(authErr.Statements, AuthorizationStatement{
+			ID:        statement.StatementID,
+			Effect:    statement.Effect,
+			Actions:   statement.Actions.values(),
+			Resources: statement.Resources.values(),
+		})
+	}
+
+	return authErr
+}
+
+// ErrorKind classifies a common failure, so it is rendered with a hint on
+// how to fix it and exits with a distinct code
+type ErrorKind string
+
+const (
+	ErrorKindCredentials            ErrorKind = "credentials"
+	ErrorKindUnauthorized           ErrorKind = "unauthorized"
+	ErrorKindVcpuLimitExceeded      ErrorKind = "vcpu-limit-exceeded"
+	ErrorKindInsufficientCapacity   ErrorKind = "insufficient-capacity"
+	ErrorKindInvalidAMI             ErrorKind = "invalid-ami"
+	ErrorKindInvalidSubnet          ErrorKind = "invalid-subnet"
+	ErrorKindDuplicateSecurityGroup ErrorKind = "duplicate-security-group"
+)
+
+// ExitCodeUnknown is the exit code of errors that are not classified
+const ExitCodeUnknown = 1
+
+// errorKindExitCodes are the exit codes of the classified errors, they must
+// not change as scripts depend on them
+var errorKindExitCodes = map[ErrorKind]int{
+	ErrorKindCredentials:            10,
+	ErrorKindUnauthorized:           11,
+	ErrorKindVcpuLimitExceeded:      12,
+	ErrorKindInsufficientCapacity:   13,
+	ErrorKindInvalidAMI:             14,
+	ErrorKindInvalidSubnet:          15,
+	ErrorKindDuplicateSecurityGroup: 16,
+}
+
+var errorKindHints = map[ErrorKind]string{
+	ErrorKindCredentials: "the AWS credentials are missing, invalid or expired, refresh them " +
+		"(e.g. `aws sso login`) or check AWS_PROFILE and AWS_ACCESS_KEY_ID",
+	ErrorKindUnauthorized: "the credentials lack a permission the provider needs, grant it or " +
+		"provide the IDs of existing resources, see the required permissions in the README",
+	ErrorKindVcpuLimitExceeded: "the vCPU quota of the instance family is used up, delete unused " +
+		"machines (see `gc`) or request a quota increase in the Service Quotas console",
+	ErrorKindInsufficientCapacity: "AWS has no capacity for the instance type right now, try again " +
+		"later or choose another " + options.AWS_INSTANCE_TYPE + " or " + options.AWS_SUBNET_ID,
+	ErrorKindInvalidAMI: "the AMI does not exist in the region or is not shared with the account, " +
+		"check " + options.AWS_AMI + " or leave it empty to use the default Ubuntu image",
+	ErrorKindInvalidSubnet: "the subnet does not exist in the region, check " +
+		options.AWS_SUBNET_ID + " and " + options.AWS_VPC_ID,
+	ErrorKindDuplicateSecurityGroup: "the devpod security group was created concurrently, " +
+		"run the command again",
+}
+
+// credentialsErrorCodes are the error codes of requests with missing,
+// invalid or expired credentials
+var credentialsErrorCodes = map[string]bool{
+	"AuthFailure":                 true,
+	"ExpiredToken":                true,
+	"ExpiredTokenException":       true,
+	"InvalidClientTokenId":        true,
+	"InvalidToken":                true,
+	"RequestExpired":              true,
+	"SignatureDoesNotMatch":       true,
+	"UnrecognizedClientException": true,
+}
+
+// ProviderError is a classified error with a hint on how to fix it
+type ProviderError struct {
+	Kind ErrorKind
+	Hint string
+
+	Err error
+}
+
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("%v\nhint: %s", e.Err, e.Hint)
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode returns the exit code of the kind of the error
+func (e *ProviderError) ExitCode() int {
+	if code, ok := errorKindExitCodes[e.Kind]; ok {
+		return code
+	}
+
+	return ExitCodeUnknown
+}
+
+// ClassifyError wraps common failures into a ProviderError, other errors are
+// returned unchanged
+func ClassifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return err
+	}
+
+	kind, ok := classify(err)
+	if !ok {
+		return err
+	}
+
+	return &ProviderError{Kind: kind, Hint: errorKindHints[kind], Err: err}
+}
+
+// ExitCode returns the exit code for err, ExitCodeUnknown if it is not
+// classified
+func ExitCode(err error) int {
+	var providerErr *ProviderError
+	if errors.As(ClassifyError(err), &providerErr) {
+		return providerErr.ExitCode()
+	}
+
+	return ExitCodeUnknown
+}
+
+func classify(err error) (ErrorKind, bool) {
+	// e.g. a request cancelled while retrieving credentials
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return "", false
+	}
+
+	var signingErr *v4.SigningError
+	if errors.As(err, &signingErr) {
+		return ErrorKindCredentials, true
+	}
+
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return "", false
+	}
+
+	code := apiErr.ErrorCode()
+	switch {
+	case credentialsErrorCodes[code]:
+		return ErrorKindCredentials, true
+	case code == "UnauthorizedOperation" || code == "AccessDenied" || code == "AccessDeniedException":
+		return ErrorKindUnauthorized, true
+	case code == "VcpuLimitExceeded":
+		return ErrorKindVcpuLimitExceeded, true
+	case insufficientCapacityCodes[code]:
+		return ErrorKindInsufficientCapacity, true
+	case strings.HasPrefix(code, "InvalidAMIID."):
+		return ErrorKindInvalidAMI, true
+	case strings.HasPrefix(code, "InvalidSubnet"):
+		return ErrorKindInvalidSubnet, true
+	case code == "InvalidGroup.Duplicate":
+		return ErrorKindDuplicateSecurityGroup, true
+	}
+
+	return "", false
+}
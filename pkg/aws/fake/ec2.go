@@ -0,0 +1,1582 @@
+// Package fake provides stateful in-memory implementations of the EC2, IAM
+// and STS clients used by the provider, so it can be tested hermetically.
+package fake
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+// AccountID is the account all fake resources belong to
+const AccountID = "123456789012"
+
+// EC2 is an in-memory EC2 API. Its resources can be seeded and inspected
+// directly, instances change state immediately. Instances seeded in a
+// transitional state complete their transition once they are described by
+// ID, like an EC2 waiter polls them.
+type EC2 struct {
+	mu sync.Mutex
+
+	Vpcs           []types.Vpc
+	Subnets        []types.Subnet
+	Images         []types.Image
+	InstanceTypes  []types.InstanceTypeInfo
+	SecurityGroups []types.SecurityGroup
+	Instances      []types.Instance
+	Volumes        []types.Volume
+	// VolumeModifications are completed immediately unless their state is
+	// changed
+	VolumeModifications []types.VolumeModification
+	Snapshots           []types.Snapshot
+	Addresses           []types.Address
+	Regions             []types.Region
+
+	// InstanceStatuses are returned instead of the status derived from the
+	// state of an instance
+	InstanceStatuses     []types.InstanceStatus
+	SpotInstanceRequests []types.SpotInstanceRequest
+
+	// ConsoleOutputs is the serial console output of each instance ID
+	ConsoleOutputs map[string]string
+
+	// NetworkInterfaces are returned in addition to the primary network
+	// interfaces of the instances, e.g. those of VPC endpoints
+	NetworkInterfaces []types.NetworkInterface
+
+	// Errors makes the operation with the given name, e.g. "RunInstances",
+	// fail with the error
+	Errors map[string]error
+
+	// UnavailableInstanceTypes fail to launch or start with
+	// InsufficientInstanceCapacity
+	UnavailableInstanceTypes []string
+
+	// RunInstancesInputs records every RunInstances request
+	RunInstancesInputs []*ec2.RunInstancesInput
+
+	// Now returns the current time, defaults to time.Now
+	Now func() time.Time
+
+	nextID int
+
+	// clientTokens are the RunInstances requests by client token, a request
+	// with a known token but other parameters fails
+	clientTokens map[string]*ec2.RunInstancesInput
+}
+
+// NewEC2 returns an empty fake EC2 API
+func NewEC2() *EC2 {
+	return &EC2{
+		Errors: map[string]error{},
+	}
+}
+
+// NewDefaultEC2 returns a fake EC2 API in us-east-1 with a default VPC, a
+// public subnet and an Ubuntu image for each architecture
+func NewDefaultEC2() *EC2 {
+	f := NewEC2()
+	f.Regions = []types.Region{
+		{RegionName: aws.String("us-east-1")},
+	}
+	f.Vpcs = []types.Vpc{
+		{
+			VpcId:     aws.String("vpc-default"),
+			IsDefault: aws.Bool(true),
+		},
+	}
+	f.Subnets = []types.Subnet{
+		{
+			SubnetId:                aws.String("subnet-default"),
+			VpcId:                   aws.String("vpc-default"),
+			AvailabilityZone:        aws.String("us-east-1a"),
+			AvailableIpAddressCount: aws.Int32(4091),
+			DefaultForAz:            aws.Bool(true),
+			MapPublicIpOnLaunch:     aws.Bool(true),
+		},
+	}
+	f.Images = []types.Image{
+		Image("ami-ubuntu-x86", "x86_64", "2023-05-01T00:00:00.000Z"),
+		Image("ami-ubuntu-arm", "arm64", "2023-05-01T00:00:00.000Z"),
+	}
+	f.InstanceTypes = []types.InstanceTypeInfo{
+		InstanceType("c5.xlarge", "x86_64", true),
+		InstanceType("c5.4xlarge", "x86_64", true),
+		InstanceType("m6g.large", "arm64", true),
+		InstanceType("t2.micro", "x86_64", false),
+	}
+
+	return f
+}
+
+// Image returns an Ubuntu 22.04 image as published by Canonical
+func Image(id, architecture, creationDate string) types.Image {
+	return types.Image{
+		ImageId:            aws.String(id),
+		Architecture:       types.ArchitectureValues(architecture),
+		CreationDate:       aws.String(creationDate),
+		Description:        aws.String("Canonical, Ubuntu, 22.04 LTS, " + architecture + " jammy image"),
+		ImageOwnerAlias:    aws.String("amazon"),
+		OwnerId:            aws.String("099720109477"),
+		PlatformDetails:    aws.String("Linux/UNIX"),
+		RootDeviceName:     aws.String("/dev/sda1"),
+		RootDeviceType:     types.DeviceTypeEbs,
+		VirtualizationType: types.VirtualizationTypeHvm,
+		EnaSupport:         aws.Bool(true),
+	}
+}
+
+// InstanceType returns an instance type of the given architecture with 8GiB
+// of memory, nitro instance types require ENA and NVMe and support
+// hibernation
+func InstanceType(name, architecture string, nitro bool) types.InstanceTypeInfo {
+	info := types.InstanceTypeInfo{
+		InstanceType: types.InstanceType(name),
+		ProcessorInfo: &types.ProcessorInfo{
+			SupportedArchitectures: []types.ArchitectureType{types.ArchitectureType(architecture)},
+		},
+		MemoryInfo:           &types.MemoryInfo{SizeInMiB: aws.Int64(8192)},
+		HibernationSupported: aws.Bool(false),
+		NetworkInfo:          &types.NetworkInfo{EnaSupport: types.EnaSupportUnsupported},
+		EbsInfo:              &types.EbsInfo{NvmeSupport: types.EbsNvmeSupportUnsupported},
+		Hypervisor:           types.InstanceTypeHypervisorXen,
+	}
+
+	if nitro {
+		info.NetworkInfo.EnaSupport = types.EnaSupportRequired
+		info.EbsInfo.NvmeSupport = types.EbsNvmeSupportRequired
+		info.Hypervisor = types.InstanceTypeHypervisorNitro
+		info.HibernationSupported = aws.Bool(true)
+	}
+
+	return info
+}
+
+// Instance returns a running instance tagged with the given machine ID
+func Instance(id, machineID string, launchTime time.Time) types.Instance {
+	return types.Instance{
+		InstanceId:   aws.String(id),
+		InstanceType: types.InstanceTypeC5Xlarge,
+		LaunchTime:   aws.Time(launchTime),
+		State:        instanceState(types.InstanceStateNameRunning),
+		Tags: []types.Tag{
+			{
+				Key:   aws.String("devpod"),
+				Value: aws.String(machineID),
+			},
+		},
+	}
+}
+
+// APIError returns an error as returned by the AWS API
+func APIError(code, format string, args ...interface{}) error {
+	return &smithy.GenericAPIError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// Instance returns the instance with the given ID
+func (f *EC2) Instance(id string) (types.Instance, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, instance := range f.Instances {
+		if aws.ToString(instance.InstanceId) == id {
+			return instance, true
+		}
+	}
+
+	return types.Instance{}, false
+}
+
+func (f *EC2) DescribeVpcs(ctx context.Context, params *ec2.DescribeVpcsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeVpcsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["DescribeVpcs"]; err != nil {
+		return nil, err
+	}
+	if params == nil {
+		params = &ec2.DescribeVpcsInput{}
+	}
+
+	result := &ec2.DescribeVpcsOutput{}
+	for _, vpc := range f.Vpcs {
+		if !contains(params.VpcIds, aws.ToString(vpc.VpcId)) {
+			continue
+		}
+
+		if matchFilters(params.Filters, vpc.Tags, map[string]string{
+			"vpc-id":     aws.ToString(vpc.VpcId),
+			"is-default": strconv.FormatBool(aws.ToBool(vpc.IsDefault)),
+		}) {
+			result.Vpcs = append(result.Vpcs, vpc)
+		}
+	}
+
+	return result, nil
+}
+
+func (f *EC2) DescribeSubnets(ctx context.Context, params *ec2.DescribeSubnetsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSubnetsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["DescribeSubnets"]; err != nil {
+		return nil, err
+	}
+
+	result := &ec2.DescribeSubnetsOutput{}
+	for _, subnet := range f.Subnets {
+		if !contains(params.SubnetIds, aws.ToString(subnet.SubnetId)) {
+			continue
+		}
+
+		if matchFilters(params.Filters, subnet.Tags, map[string]string{
+			"subnet-id":               aws.ToString(subnet.SubnetId),
+			"vpc-id":                  aws.ToString(subnet.VpcId),
+			"availability-zone":       aws.ToString(subnet.AvailabilityZone),
+			"default-for-az":          strconv.FormatBool(aws.ToBool(subnet.DefaultForAz)),
+			"map-public-ip-on-launch": strconv.FormatBool(aws.ToBool(subnet.MapPublicIpOnLaunch)),
+		}) {
+			result.Subnets = append(result.Subnets, subnet)
+		}
+	}
+
+	return result, nil
+}
+
+func (f *EC2) DescribeImages(ctx context.Context, params *ec2.DescribeImagesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["DescribeImages"]; err != nil {
+		return nil, err
+	}
+
+	for _, id := range params.ImageIds {
+		if f.image(id) == nil {
+			return nil, APIError("InvalidAMIID.NotFound", "The image id '[%s]' does not exist", id)
+		}
+	}
+
+	result := &ec2.DescribeImagesOutput{}
+	for _, image := range f.Images {
+		if !contains(params.ImageIds, aws.ToString(image.ImageId)) {
+			continue
+		}
+
+		if len(params.Owners) > 0 &&
+			!containsAny(params.Owners, aws.ToString(image.ImageOwnerAlias), aws.ToString(image.OwnerId)) &&
+			!(containsAny(params.Owners, "self") && aws.ToString(image.OwnerId) == AccountID) {
+			continue
+		}
+
+		if matchFilters(params.Filters, image.Tags, map[string]string{
+			"image-id":            aws.ToString(image.ImageId),
+			"architecture":        string(image.Architecture),
+			"description":         aws.ToString(image.Description),
+			"platform-details":    aws.ToString(image.PlatformDetails),
+			"root-device-type":    string(image.RootDeviceType),
+			"virtualization-type": string(image.VirtualizationType),
+		}) {
+			result.Images = append(result.Images, image)
+		}
+	}
+
+	return result, nil
+}
+
+func (f *EC2) DescribeRegions(ctx context.Context, params *ec2.DescribeRegionsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeRegionsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["DescribeRegions"]; err != nil {
+		return nil, err
+	}
+
+	return &ec2.DescribeRegionsOutput{Regions: f.Regions}, nil
+}
+
+func (f *EC2) DescribeSecurityGroups(ctx context.Context, params *ec2.DescribeSecurityGroupsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSecurityGroupsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["DescribeSecurityGroups"]; err != nil {
+		return nil, err
+	}
+
+	result := &ec2.DescribeSecurityGroupsOutput{}
+	for _, group := range f.SecurityGroups {
+		if !contains(params.GroupIds, aws.ToString(group.GroupId)) {
+			continue
+		}
+
+		if matchFilters(params.Filters, group.Tags, map[string]string{
+			"group-id":   aws.ToString(group.GroupId),
+			"group-name": aws.ToString(group.GroupName),
+			"vpc-id":     aws.ToString(group.VpcId),
+		}) {
+			result.SecurityGroups = append(result.SecurityGroups, group)
+		}
+	}
+
+	return result, nil
+}
+
+func (f *EC2) DeleteSecurityGroup(ctx context.Context, params *ec2.DeleteSecurityGroupInput, optFns ...func(*ec2.Options)) (*ec2.DeleteSecurityGroupOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["DeleteSecurityGroup"]; err != nil {
+		return nil, err
+	}
+
+	for i, group := range f.SecurityGroups {
+		if aws.ToString(group.GroupId) != aws.ToString(params.GroupId) {
+			continue
+		}
+
+		for _, instance := range f.Instances {
+			if instance.State.Name == types.InstanceStateNameTerminated {
+				continue
+			}
+
+			for _, instanceGroup := range instance.SecurityGroups {
+				if aws.ToString(instanceGroup.GroupId) == aws.ToString(group.GroupId) {
+					return nil, APIError("DependencyViolation", "resource %s has a dependent object", aws.ToString(group.GroupId))
+				}
+			}
+		}
+
+		if err := dryRun(params.DryRun); err != nil {
+			return nil, err
+		}
+
+		f.SecurityGroups = append(f.SecurityGroups[:i], f.SecurityGroups[i+1:]...)
+
+		return &ec2.DeleteSecurityGroupOutput{}, nil
+	}
+
+	return nil, APIError("InvalidGroup.NotFound", "The security group '%s' does not exist", aws.ToString(params.GroupId))
+}
+
+func (f *EC2) CreateSecurityGroup(ctx context.Context, params *ec2.CreateSecurityGroupInput, optFns ...func(*ec2.Options)) (*ec2.CreateSecurityGroupOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["CreateSecurityGroup"]; err != nil {
+		return nil, err
+	}
+
+	vpcID := aws.ToString(params.VpcId)
+	if vpcID == "" {
+		vpc := f.defaultVpc()
+		if vpc == nil {
+			return nil, APIError("VPCIdNotSpecified", "No default VPC for this user")
+		}
+
+		vpcID = aws.ToString(vpc.VpcId)
+	} else if f.vpc(vpcID) == nil {
+		return nil, APIError("InvalidVpcID.NotFound", "The vpc ID '%s' does not exist", vpcID)
+	}
+
+	for _, group := range f.SecurityGroups {
+		if aws.ToString(group.GroupName) == aws.ToString(params.GroupName) && aws.ToString(group.VpcId) == vpcID {
+			return nil, APIError(
+				"InvalidGroup.Duplicate",
+				"The security group '%s' already exists for VPC '%s'",
+				aws.ToString(params.GroupName),
+				vpcID,
+			)
+		}
+	}
+
+	group := types.SecurityGroup{
+		GroupId:     aws.String(f.newID("sg")),
+		GroupName:   params.GroupName,
+		Description: params.Description,
+		VpcId:       aws.String(vpcID),
+		OwnerId:     aws.String(AccountID),
+		Tags:        tagsFor(params.TagSpecifications, types.ResourceTypeSecurityGroup),
+	}
+	f.SecurityGroups = append(f.SecurityGroups, group)
+
+	return &ec2.CreateSecurityGroupOutput{
+		GroupId: group.GroupId,
+		Tags:    group.Tags,
+	}, nil
+}
+
+func (f *EC2) AuthorizeSecurityGroupIngress(ctx context.Context, params *ec2.AuthorizeSecurityGroupIngressInput, optFns ...func(*ec2.Options)) (*ec2.AuthorizeSecurityGroupIngressOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["AuthorizeSecurityGroupIngress"]; err != nil {
+		return nil, err
+	}
+
+	for i, group := range f.SecurityGroups {
+		if aws.ToString(group.GroupId) == aws.ToString(params.GroupId) {
+			f.SecurityGroups[i].IpPermissions = append(group.IpPermissions, params.IpPermissions...)
+
+			return &ec2.AuthorizeSecurityGroupIngressOutput{
+				Return: aws.Bool(true),
+			}, nil
+		}
+	}
+
+	return nil, APIError("InvalidGroup.NotFound", "The security group '%s' does not exist", aws.ToString(params.GroupId))
+}
+
+func (f *EC2) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["DescribeInstances"]; err != nil {
+		return nil, err
+	}
+
+	for _, id := range params.InstanceIds {
+		if f.instanceIndex(id) < 0 {
+			return nil, APIError("InvalidInstanceID.NotFound", "The instance ID '%s' does not exist", id)
+		}
+	}
+
+	for _, id := range params.InstanceIds {
+		index := f.instanceIndex(id)
+		if target, ok := settledStates[f.Instances[index].State.Name]; ok {
+			f.Instances[index].State = instanceState(target)
+		}
+	}
+
+	result := &ec2.DescribeInstancesOutput{}
+	for _, instance := range f.Instances {
+		if !contains(params.InstanceIds, aws.ToString(instance.InstanceId)) {
+			continue
+		}
+
+		availabilityZone := ""
+		if instance.Placement != nil {
+			availabilityZone = aws.ToString(instance.Placement.AvailabilityZone)
+		}
+
+		if matchFilters(params.Filters, instance.Tags, map[string]string{
+			"instance-id":              aws.ToString(instance.InstanceId),
+			"instance-state-name":      string(instance.State.Name),
+			"instance-type":            string(instance.InstanceType),
+			"availability-zone":        availabilityZone,
+			"subnet-id":                aws.ToString(instance.SubnetId),
+			"vpc-id":                   aws.ToString(instance.VpcId),
+			"iam-instance-profile.arn": instanceProfileArn(instance),
+		}) {
+			result.Reservations = append(result.Reservations, types.Reservation{
+				ReservationId: aws.String("r-" + strings.TrimPrefix(aws.ToString(instance.InstanceId), "i-")),
+				OwnerId:       aws.String(AccountID),
+				Instances:     []types.Instance{instance},
+			})
+		}
+	}
+
+	return result, nil
+}
+
+func (f *EC2) RunInstances(ctx context.Context, params *ec2.RunInstancesInput, optFns ...func(*ec2.Options)) (*ec2.RunInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.RunInstancesInputs = append(f.RunInstancesInputs, params)
+	if err := f.Errors["RunInstances"]; err != nil {
+		return nil, err
+	}
+
+	// a request with a known client token returns the instances it launched
+	if token := aws.ToString(params.ClientToken); token != "" {
+		if previous, ok := f.clientTokens[token]; ok && !reflect.DeepEqual(previous, params) {
+			return nil, APIError(
+				"IdempotentParameterMismatch",
+				"The client token '%s' is already in use with different parameters",
+				token,
+			)
+		}
+
+		result := &ec2.RunInstancesOutput{
+			OwnerId: aws.String(AccountID),
+		}
+		for _, instance := range f.Instances {
+			if aws.ToString(instance.ClientToken) == token {
+				result.Instances = append(result.Instances, instance)
+			}
+		}
+
+		if len(result.Instances) > 0 {
+			return result, nil
+		}
+	}
+
+	image := f.image(aws.ToString(params.ImageId))
+	if image == nil {
+		return nil, APIError("InvalidAMIID.NotFound", "The image id '[%s]' does not exist", aws.ToString(params.ImageId))
+	}
+
+	if containsAny(f.UnavailableInstanceTypes, string(params.InstanceType)) {
+		return nil, insufficientCapacity(params.InstanceType)
+	}
+
+	var subnet *types.Subnet
+	if params.SubnetId != nil {
+		subnet = f.subnet(aws.ToString(params.SubnetId))
+		if subnet == nil {
+			return nil, APIError("InvalidSubnetID.NotFound", "The subnet ID '%s' does not exist", aws.ToString(params.SubnetId))
+		}
+	} else {
+		vpc := f.defaultVpc()
+		if vpc == nil {
+			return nil, APIError("VPCIdNotSpecified", "No default VPC for this user")
+		}
+
+		for i := range f.Subnets {
+			if aws.ToString(f.Subnets[i].VpcId) == aws.ToString(vpc.VpcId) && aws.ToBool(f.Subnets[i].DefaultForAz) {
+				subnet = &f.Subnets[i]
+				break
+			}
+		}
+		if subnet == nil {
+			return nil, APIError("MissingInput", "No subnets found for the default VPC '%s'", aws.ToString(vpc.VpcId))
+		}
+	}
+
+	groups := []types.GroupIdentifier{}
+	for _, id := range params.SecurityGroupIds {
+		group := f.securityGroup(id)
+		if group == nil {
+			return nil, APIError("InvalidGroup.NotFound", "The security group '%s' does not exist", id)
+		}
+
+		groups = append(groups, types.GroupIdentifier{
+			GroupId:   group.GroupId,
+			GroupName: group.GroupName,
+		})
+	}
+
+	count := int(aws.ToInt32(params.MaxCount))
+	if count == 0 {
+		count = 1
+	}
+
+	result := &ec2.RunInstancesOutput{
+		OwnerId: aws.String(AccountID),
+	}
+	for i := 0; i < count; i++ {
+		id := f.newID("i")
+
+		instance := types.Instance{
+			InstanceId:       aws.String(id),
+			ImageId:          params.ImageId,
+			InstanceType:     params.InstanceType,
+			EnaSupport:       image.EnaSupport,
+			RootDeviceName:   image.RootDeviceName,
+			ClientToken:      params.ClientToken,
+			LaunchTime:       aws.Time(f.now()),
+			State:            instanceState(types.InstanceStateNameRunning),
+			SubnetId:         subnet.SubnetId,
+			VpcId:            subnet.VpcId,
+			Placement:        &types.Placement{AvailabilityZone: subnet.AvailabilityZone},
+			PrivateIpAddress: aws.String(fmt.Sprintf("10.0.%d.%d", f.nextID/256, f.nextID%256)),
+			SecurityGroups:   groups,
+			MetadataOptions:  metadataOptions(params.MetadataOptions),
+			Tags:             tagsFor(params.TagSpecifications, types.ResourceTypeInstance),
+		}
+
+		if aws.ToBool(subnet.MapPublicIpOnLaunch) {
+			instance.PublicIpAddress = aws.String(fmt.Sprintf("203.0.113.%d", f.nextID%256))
+		}
+
+		if params.HibernationOptions != nil {
+			instance.HibernationOptions = &types.HibernationOptions{
+				Configured: params.HibernationOptions.Configured,
+			}
+		}
+
+		if params.IamInstanceProfile != nil {
+			instance.IamInstanceProfile = &types.IamInstanceProfile{
+				Arn: params.IamInstanceProfile.Arn,
+			}
+		}
+
+		for _, mapping := range params.BlockDeviceMappings {
+			volumeID := aws.String(f.newID("vol"))
+			volume := types.Volume{
+				VolumeId:         volumeID,
+				AvailabilityZone: subnet.AvailabilityZone,
+				CreateTime:       instance.LaunchTime,
+				Size:             aws.Int32(8),
+				State:            types.VolumeStateInUse,
+				VolumeType:       types.VolumeTypeGp2,
+				Tags:             tagsFor(params.TagSpecifications, types.ResourceTypeVolume),
+				Attachments: []types.VolumeAttachment{
+					{
+						InstanceId:          instance.InstanceId,
+						VolumeId:            volumeID,
+						Device:              mapping.DeviceName,
+						State:               types.VolumeAttachmentStateAttached,
+						AttachTime:          instance.LaunchTime,
+						DeleteOnTermination: aws.Bool(true),
+					},
+				},
+			}
+			if mapping.Ebs != nil && mapping.Ebs.VolumeSize != nil {
+				volume.Size = mapping.Ebs.VolumeSize
+			}
+			if mapping.Ebs != nil && mapping.Ebs.VolumeType != "" {
+				volume.VolumeType = mapping.Ebs.VolumeType
+			}
+			f.Volumes = append(f.Volumes, volume)
+
+			instance.BlockDeviceMappings = append(instance.BlockDeviceMappings, types.InstanceBlockDeviceMapping{
+				DeviceName: mapping.DeviceName,
+				Ebs: &types.EbsInstanceBlockDevice{
+					VolumeId:            volume.VolumeId,
+					Status:              types.AttachmentStatusAttached,
+					AttachTime:          instance.LaunchTime,
+					DeleteOnTermination: aws.Bool(true),
+				},
+			})
+		}
+
+		f.Instances = append(f.Instances, instance)
+		result.Instances = append(result.Instances, instance)
+	}
+
+	if token := aws.ToString(params.ClientToken); token != "" {
+		if f.clientTokens == nil {
+			f.clientTokens = map[string]*ec2.RunInstancesInput{}
+		}
+		f.clientTokens[token] = params
+	}
+
+	return result, nil
+}
+
+// DescribeInstanceStatus returns the seeded InstanceStatuses, or passing
+// status checks for running instances
+func (f *EC2) DescribeInstanceStatus(ctx context.Context, params *ec2.DescribeInstanceStatusInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceStatusOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["DescribeInstanceStatus"]; err != nil {
+		return nil, err
+	}
+
+	result := &ec2.DescribeInstanceStatusOutput{}
+	for _, instance := range f.Instances {
+		if !contains(params.InstanceIds, aws.ToString(instance.InstanceId)) {
+			continue
+		}
+
+		running := instance.State.Name == types.InstanceStateNameRunning
+		if !running && !aws.ToBool(params.IncludeAllInstances) {
+			continue
+		}
+
+		status := types.InstanceStatus{
+			InstanceId:     instance.InstanceId,
+			InstanceState:  instance.State,
+			InstanceStatus: &types.InstanceStatusSummary{Status: types.SummaryStatusNotApplicable},
+			SystemStatus:   &types.InstanceStatusSummary{Status: types.SummaryStatusNotApplicable},
+		}
+		if running {
+			status.InstanceStatus.Status = types.SummaryStatusOk
+			status.SystemStatus.Status = types.SummaryStatusOk
+		}
+
+		for _, seeded := range f.InstanceStatuses {
+			if aws.ToString(seeded.InstanceId) == aws.ToString(instance.InstanceId) {
+				status = seeded
+			}
+		}
+
+		result.InstanceStatuses = append(result.InstanceStatuses, status)
+	}
+
+	return result, nil
+}
+
+func (f *EC2) GetConsoleOutput(ctx context.Context, params *ec2.GetConsoleOutputInput, optFns ...func(*ec2.Options)) (*ec2.GetConsoleOutputOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["GetConsoleOutput"]; err != nil {
+		return nil, err
+	}
+
+	id := aws.ToString(params.InstanceId)
+	if f.instanceIndex(id) < 0 {
+		return nil, APIError("InvalidInstanceID.NotFound", "The instance ID '%s' does not exist", id)
+	}
+
+	return &ec2.GetConsoleOutputOutput{
+		InstanceId: params.InstanceId,
+		Output:     aws.String(base64.StdEncoding.EncodeToString([]byte(f.ConsoleOutputs[id]))),
+		Timestamp:  aws.Time(f.now()),
+	}, nil
+}
+
+func (f *EC2) DescribeSpotInstanceRequests(ctx context.Context, params *ec2.DescribeSpotInstanceRequestsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSpotInstanceRequestsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["DescribeSpotInstanceRequests"]; err != nil {
+		return nil, err
+	}
+
+	result := &ec2.DescribeSpotInstanceRequestsOutput{}
+	for _, request := range f.SpotInstanceRequests {
+		if contains(params.SpotInstanceRequestIds, aws.ToString(request.SpotInstanceRequestId)) {
+			result.SpotInstanceRequests = append(result.SpotInstanceRequests, request)
+		}
+	}
+
+	return result, nil
+}
+
+func (f *EC2) DescribeInstanceTypes(ctx context.Context, params *ec2.DescribeInstanceTypesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["DescribeInstanceTypes"]; err != nil {
+		return nil, err
+	}
+
+	result := &ec2.DescribeInstanceTypesOutput{}
+	for _, instanceType := range params.InstanceTypes {
+		found := false
+		for _, info := range f.InstanceTypes {
+			if info.InstanceType == instanceType {
+				result.InstanceTypes = append(result.InstanceTypes, info)
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, APIError("InvalidInstanceType", "The following supplied instance types do not exist: [%s]", instanceType)
+		}
+	}
+
+	if len(params.InstanceTypes) == 0 {
+		result.InstanceTypes = f.InstanceTypes
+	}
+
+	return result, nil
+}
+
+func (f *EC2) ModifyInstanceAttribute(ctx context.Context, params *ec2.ModifyInstanceAttributeInput, optFns ...func(*ec2.Options)) (*ec2.ModifyInstanceAttributeOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["ModifyInstanceAttribute"]; err != nil {
+		return nil, err
+	}
+
+	index := f.instanceIndex(aws.ToString(params.InstanceId))
+	if index < 0 {
+		return nil, APIError("InvalidInstanceID.NotFound", "The instance ID '%s' does not exist", aws.ToString(params.InstanceId))
+	}
+
+	if params.InstanceType != nil {
+		if f.Instances[index].State.Name != types.InstanceStateNameStopped {
+			return nil, APIError("IncorrectInstanceState", "The instance '%s' is not in the 'stopped' state.", aws.ToString(params.InstanceId))
+		}
+
+		f.Instances[index].InstanceType = types.InstanceType(aws.ToString(params.InstanceType.Value))
+	}
+
+	return &ec2.ModifyInstanceAttributeOutput{}, nil
+}
+
+func (f *EC2) ModifyInstanceMetadataOptions(ctx context.Context, params *ec2.ModifyInstanceMetadataOptionsInput, optFns ...func(*ec2.Options)) (*ec2.ModifyInstanceMetadataOptionsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["ModifyInstanceMetadataOptions"]; err != nil {
+		return nil, err
+	}
+
+	index := f.instanceIndex(aws.ToString(params.InstanceId))
+	if index < 0 {
+		return nil, APIError("InvalidInstanceID.NotFound", "The instance ID '%s' does not exist", aws.ToString(params.InstanceId))
+	}
+
+	options := f.Instances[index].MetadataOptions
+	if options == nil {
+		options = &types.InstanceMetadataOptionsResponse{}
+		f.Instances[index].MetadataOptions = options
+	}
+	if params.HttpEndpoint != "" {
+		options.HttpEndpoint = params.HttpEndpoint
+	}
+	if params.HttpTokens != "" {
+		options.HttpTokens = params.HttpTokens
+	}
+	if params.HttpPutResponseHopLimit != nil {
+		options.HttpPutResponseHopLimit = params.HttpPutResponseHopLimit
+	}
+	if params.InstanceMetadataTags != "" {
+		options.InstanceMetadataTags = params.InstanceMetadataTags
+	}
+
+	return &ec2.ModifyInstanceMetadataOptionsOutput{
+		InstanceId: params.InstanceId,
+	}, nil
+}
+
+func (f *EC2) StartInstances(ctx context.Context, params *ec2.StartInstancesInput, optFns ...func(*ec2.Options)) (*ec2.StartInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["StartInstances"]; err != nil {
+		return nil, err
+	}
+
+	for _, id := range params.InstanceIds {
+		index := f.instanceIndex(id)
+		if index >= 0 && containsAny(f.UnavailableInstanceTypes, string(f.Instances[index].InstanceType)) {
+			return nil, insufficientCapacity(f.Instances[index].InstanceType)
+		}
+	}
+
+	changes, err := f.transition(params.InstanceIds, types.InstanceStateNameRunning, types.InstanceStateNameStopped)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range params.InstanceIds {
+		f.Instances[f.instanceIndex(id)].LaunchTime = aws.Time(f.now())
+	}
+
+	return &ec2.StartInstancesOutput{StartingInstances: changes}, nil
+}
+
+func (f *EC2) StopInstances(ctx context.Context, params *ec2.StopInstancesInput, optFns ...func(*ec2.Options)) (*ec2.StopInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["StopInstances"]; err != nil {
+		return nil, err
+	}
+
+	if err := dryRun(params.DryRun); err != nil {
+		return nil, err
+	}
+
+	if aws.ToBool(params.Hibernate) {
+		for _, id := range params.InstanceIds {
+			index := f.instanceIndex(id)
+			if index >= 0 && (f.Instances[index].HibernationOptions == nil || !aws.ToBool(f.Instances[index].HibernationOptions.Configured)) {
+				return nil, APIError("UnsupportedHibernationConfiguration", "The instance '%s' is not enabled for hibernation.", id)
+			}
+		}
+	}
+
+	changes, err := f.transition(params.InstanceIds, types.InstanceStateNameStopped, types.InstanceStateNameRunning)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ec2.StopInstancesOutput{StoppingInstances: changes}, nil
+}
+
+func (f *EC2) TerminateInstances(ctx context.Context, params *ec2.TerminateInstancesInput, optFns ...func(*ec2.Options)) (*ec2.TerminateInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["TerminateInstances"]; err != nil {
+		return nil, err
+	}
+
+	if err := dryRun(params.DryRun); err != nil {
+		return nil, err
+	}
+
+	changes, err := f.transition(
+		params.InstanceIds,
+		types.InstanceStateNameTerminated,
+		types.InstanceStateNamePending,
+		types.InstanceStateNameRunning,
+		types.InstanceStateNameStopping,
+		types.InstanceStateNameStopped,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// volumes are deleted or detached along with the instance
+	volumes := []types.Volume{}
+	for _, volume := range f.Volumes {
+		if len(volume.Attachments) > 0 && containsAny(params.InstanceIds, aws.ToString(volume.Attachments[0].InstanceId)) {
+			if aws.ToBool(volume.Attachments[0].DeleteOnTermination) {
+				continue
+			}
+
+			volume.Attachments = nil
+			volume.State = types.VolumeStateAvailable
+		}
+
+		volumes = append(volumes, volume)
+	}
+	f.Volumes = volumes
+
+	// elastic IPs are disassociated from terminated instances
+	for i, address := range f.Addresses {
+		if containsAny(params.InstanceIds, aws.ToString(address.InstanceId)) {
+			f.Addresses[i].AssociationId = nil
+			f.Addresses[i].InstanceId = nil
+		}
+	}
+
+	return &ec2.TerminateInstancesOutput{TerminatingInstances: changes}, nil
+}
+
+func (f *EC2) DeleteVolume(ctx context.Context, params *ec2.DeleteVolumeInput, optFns ...func(*ec2.Options)) (*ec2.DeleteVolumeOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["DeleteVolume"]; err != nil {
+		return nil, err
+	}
+
+	for i, volume := range f.Volumes {
+		if aws.ToString(volume.VolumeId) != aws.ToString(params.VolumeId) {
+			continue
+		}
+
+		if len(volume.Attachments) > 0 {
+			return nil, APIError("VolumeInUse", "Volume %s is currently attached to %s", aws.ToString(volume.VolumeId), aws.ToString(volume.Attachments[0].InstanceId))
+		}
+
+		if err := dryRun(params.DryRun); err != nil {
+			return nil, err
+		}
+
+		f.Volumes = append(f.Volumes[:i], f.Volumes[i+1:]...)
+
+		return &ec2.DeleteVolumeOutput{}, nil
+	}
+
+	return nil, APIError("InvalidVolume.NotFound", "The volume '%s' does not exist.", aws.ToString(params.VolumeId))
+}
+
+// CreateTags adds or overwrites the tags of instances, volumes and network
+// interfaces
+func (f *EC2) CreateTags(ctx context.Context, params *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["CreateTags"]; err != nil {
+		return nil, err
+	}
+
+	for _, id := range params.Resources {
+		switch {
+		case f.instanceIndex(id) >= 0:
+			index := f.instanceIndex(id)
+			f.Instances[index].Tags = setTags(f.Instances[index].Tags, params.Tags)
+		case strings.HasPrefix(id, "vol-"):
+			found := false
+			for i := range f.Volumes {
+				if aws.ToString(f.Volumes[i].VolumeId) == id {
+					f.Volumes[i].Tags = setTags(f.Volumes[i].Tags, params.Tags)
+					found = true
+				}
+			}
+
+			if !found {
+				return nil, APIError("InvalidVolume.NotFound", "The volume '%s' does not exist.", id)
+			}
+		case strings.HasPrefix(id, "eni-"):
+			found := false
+			for i := range f.NetworkInterfaces {
+				if aws.ToString(f.NetworkInterfaces[i].NetworkInterfaceId) == id {
+					f.NetworkInterfaces[i].TagSet = setTags(f.NetworkInterfaces[i].TagSet, params.Tags)
+					found = true
+				}
+			}
+
+			if !found {
+				return nil, APIError("InvalidNetworkInterfaceID.NotFound", "The networkInterface ID '%s' does not exist", id)
+			}
+		default:
+			return nil, APIError("InvalidID", "The ID '%s' is not valid", id)
+		}
+	}
+
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+// ModifyVolume changes the size of a volume immediately and records an
+// optimizing modification. Like EC2 it refuses to modify a volume again
+// within 6 hours.
+func (f *EC2) ModifyVolume(ctx context.Context, params *ec2.ModifyVolumeInput, optFns ...func(*ec2.Options)) (*ec2.ModifyVolumeOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["ModifyVolume"]; err != nil {
+		return nil, err
+	}
+
+	for i, volume := range f.Volumes {
+		if aws.ToString(volume.VolumeId) != aws.ToString(params.VolumeId) {
+			continue
+		}
+
+		if params.Size != nil && aws.ToInt32(params.Size) < aws.ToInt32(volume.Size) {
+			return nil, APIError("InvalidParameterValue", "New size cannot be smaller than existing size")
+		}
+
+		for _, modification := range f.VolumeModifications {
+			if aws.ToString(modification.VolumeId) == aws.ToString(params.VolumeId) &&
+				modification.StartTime != nil && f.now().Sub(*modification.StartTime) < 6*time.Hour {
+				return nil, APIError(
+					"VolumeModificationRateExceeded",
+					"You've reached the maximum modification rate per volume limit. Wait at least 6 hours between modifications per EBS volume.",
+				)
+			}
+		}
+
+		modification := types.VolumeModification{
+			VolumeId:          volume.VolumeId,
+			ModificationState: types.VolumeModificationStateOptimizing,
+			OriginalSize:      volume.Size,
+			TargetSize:        volume.Size,
+			StartTime:         aws.Time(f.now()),
+		}
+		if params.Size != nil {
+			modification.TargetSize = params.Size
+			f.Volumes[i].Size = params.Size
+		}
+		f.VolumeModifications = append(f.VolumeModifications, modification)
+
+		return &ec2.ModifyVolumeOutput{VolumeModification: &modification}, nil
+	}
+
+	return nil, APIError("InvalidVolume.NotFound", "The volume '%s' does not exist.", aws.ToString(params.VolumeId))
+}
+
+func (f *EC2) DescribeVolumesModifications(ctx context.Context, params *ec2.DescribeVolumesModificationsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeVolumesModificationsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["DescribeVolumesModifications"]; err != nil {
+		return nil, err
+	}
+
+	result := &ec2.DescribeVolumesModificationsOutput{}
+	for _, id := range params.VolumeIds {
+		found := false
+		for _, modification := range f.VolumeModifications {
+			if aws.ToString(modification.VolumeId) == id {
+				result.VolumesModifications = append(result.VolumesModifications, modification)
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, APIError("InvalidVolumeModification.NotFound", "Modification for volume '%s' does not exist.", id)
+		}
+	}
+
+	return result, nil
+}
+
+func (f *EC2) DescribeSnapshots(ctx context.Context, params *ec2.DescribeSnapshotsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSnapshotsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["DescribeSnapshots"]; err != nil {
+		return nil, err
+	}
+
+	result := &ec2.DescribeSnapshotsOutput{}
+	for _, snapshot := range f.Snapshots {
+		if !contains(params.SnapshotIds, aws.ToString(snapshot.SnapshotId)) {
+			continue
+		}
+
+		if matchFilters(params.Filters, snapshot.Tags, map[string]string{
+			"snapshot-id": aws.ToString(snapshot.SnapshotId),
+			"volume-id":   aws.ToString(snapshot.VolumeId),
+			"status":      string(snapshot.State),
+		}) {
+			result.Snapshots = append(result.Snapshots, snapshot)
+		}
+	}
+
+	return result, nil
+}
+
+func (f *EC2) DeleteSnapshot(ctx context.Context, params *ec2.DeleteSnapshotInput, optFns ...func(*ec2.Options)) (*ec2.DeleteSnapshotOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["DeleteSnapshot"]; err != nil {
+		return nil, err
+	}
+
+	for i, snapshot := range f.Snapshots {
+		if aws.ToString(snapshot.SnapshotId) != aws.ToString(params.SnapshotId) {
+			continue
+		}
+
+		if err := dryRun(params.DryRun); err != nil {
+			return nil, err
+		}
+
+		f.Snapshots = append(f.Snapshots[:i], f.Snapshots[i+1:]...)
+
+		return &ec2.DeleteSnapshotOutput{}, nil
+	}
+
+	return nil, APIError("InvalidSnapshot.NotFound", "The snapshot '%s' does not exist.", aws.ToString(params.SnapshotId))
+}
+
+func (f *EC2) DescribeAddresses(ctx context.Context, params *ec2.DescribeAddressesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeAddressesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["DescribeAddresses"]; err != nil {
+		return nil, err
+	}
+
+	result := &ec2.DescribeAddressesOutput{}
+	for _, address := range f.Addresses {
+		if !contains(params.AllocationIds, aws.ToString(address.AllocationId)) {
+			continue
+		}
+
+		if matchFilters(params.Filters, address.Tags, map[string]string{
+			"allocation-id":  aws.ToString(address.AllocationId),
+			"association-id": aws.ToString(address.AssociationId),
+			"instance-id":    aws.ToString(address.InstanceId),
+			"public-ip":      aws.ToString(address.PublicIp),
+		}) {
+			result.Addresses = append(result.Addresses, address)
+		}
+	}
+
+	return result, nil
+}
+
+func (f *EC2) ReleaseAddress(ctx context.Context, params *ec2.ReleaseAddressInput, optFns ...func(*ec2.Options)) (*ec2.ReleaseAddressOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["ReleaseAddress"]; err != nil {
+		return nil, err
+	}
+
+	for i, address := range f.Addresses {
+		if aws.ToString(address.AllocationId) != aws.ToString(params.AllocationId) {
+			continue
+		}
+
+		if aws.ToString(address.AssociationId) != "" {
+			return nil, APIError("InvalidIPAddress.InUse", "Address %s is in use.", aws.ToString(address.PublicIp))
+		}
+
+		if err := dryRun(params.DryRun); err != nil {
+			return nil, err
+		}
+
+		f.Addresses = append(f.Addresses[:i], f.Addresses[i+1:]...)
+
+		return &ec2.ReleaseAddressOutput{}, nil
+	}
+
+	return nil, APIError("InvalidAllocationID.NotFound", "The allocation ID '%s' does not exist", aws.ToString(params.AllocationId))
+}
+
+// DescribeNetworkInterfaces returns the primary network interfaces of all
+// instances that are not terminated and the seeded NetworkInterfaces
+func (f *EC2) DescribeNetworkInterfaces(ctx context.Context, params *ec2.DescribeNetworkInterfacesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["DescribeNetworkInterfaces"]; err != nil {
+		return nil, err
+	}
+
+	interfaces := []types.NetworkInterface{}
+	for _, instance := range f.Instances {
+		if instance.State.Name == types.InstanceStateNameTerminated {
+			continue
+		}
+
+		interfaces = append(interfaces, types.NetworkInterface{
+			NetworkInterfaceId: aws.String("eni-" + strings.TrimPrefix(aws.ToString(instance.InstanceId), "i-")),
+			Groups:             instance.SecurityGroups,
+			Attachment:         &types.NetworkInterfaceAttachment{InstanceId: instance.InstanceId},
+			Status:             types.NetworkInterfaceStatusInUse,
+		})
+	}
+	interfaces = append(interfaces, f.NetworkInterfaces...)
+
+	result := &ec2.DescribeNetworkInterfacesOutput{}
+	for _, networkInterface := range interfaces {
+		if !contains(params.NetworkInterfaceIds, aws.ToString(networkInterface.NetworkInterfaceId)) {
+			continue
+		}
+
+		// an interface matches a group-id filter if any of its groups does
+		groupIDs := []string{""}
+		if len(networkInterface.Groups) > 0 {
+			groupIDs = []string{}
+			for _, group := range networkInterface.Groups {
+				groupIDs = append(groupIDs, aws.ToString(group.GroupId))
+			}
+		}
+
+		for _, groupID := range groupIDs {
+			if matchFilters(params.Filters, networkInterface.TagSet, map[string]string{
+				"network-interface-id": aws.ToString(networkInterface.NetworkInterfaceId),
+				"group-id":             groupID,
+			}) {
+				result.NetworkInterfaces = append(result.NetworkInterfaces, networkInterface)
+				break
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func (f *EC2) DescribeVolumes(ctx context.Context, params *ec2.DescribeVolumesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeVolumesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["DescribeVolumes"]; err != nil {
+		return nil, err
+	}
+
+	result := &ec2.DescribeVolumesOutput{}
+	for _, volume := range f.Volumes {
+		if !contains(params.VolumeIds, aws.ToString(volume.VolumeId)) {
+			continue
+		}
+
+		attributes := map[string]string{
+			"volume-id":         aws.ToString(volume.VolumeId),
+			"status":            string(volume.State),
+			"availability-zone": aws.ToString(volume.AvailabilityZone),
+		}
+		if len(volume.Attachments) > 0 {
+			attributes["attachment.instance-id"] = aws.ToString(volume.Attachments[0].InstanceId)
+		}
+
+		if matchFilters(params.Filters, volume.Tags, attributes) {
+			result.Volumes = append(result.Volumes, volume)
+		}
+	}
+
+	return result, nil
+}
+
+// transition moves the instances into the target state, instances already in
+// that state are left untouched
+// settledStates are the states instances in a transitional state move to
+var settledStates = map[types.InstanceStateName]types.InstanceStateName{
+	types.InstanceStateNamePending:      types.InstanceStateNameRunning,
+	types.InstanceStateNameStopping:     types.InstanceStateNameStopped,
+	types.InstanceStateNameShuttingDown: types.InstanceStateNameTerminated,
+}
+
+func (f *EC2) transition(
+	ids []string,
+	target types.InstanceStateName,
+	from ...types.InstanceStateName,
+) ([]types.InstanceStateChange, error) {
+	for _, id := range ids {
+		index := f.instanceIndex(id)
+		if index < 0 {
+			return nil, APIError("InvalidInstanceID.NotFound", "The instance ID '%s' does not exist", id)
+		}
+
+		state := f.Instances[index].State.Name
+		if state != target && !containsState(from, state) {
+			return nil, APIError(
+				"IncorrectInstanceState",
+				"The instance '%s' is not in a state from which it can be moved to '%s'",
+				id,
+				target,
+			)
+		}
+	}
+
+	changes := []types.InstanceStateChange{}
+	for _, id := range ids {
+		index := f.instanceIndex(id)
+		previous := f.Instances[index].State
+		f.Instances[index].State = instanceState(target)
+		f.Instances[index].StateTransitionReason = aws.String(
+			fmt.Sprintf("User initiated (%s GMT)", f.now().UTC().Format("2006-01-02 15:04:05")),
+		)
+
+		changes = append(changes, types.InstanceStateChange{
+			InstanceId:    aws.String(id),
+			PreviousState: previous,
+			CurrentState:  f.Instances[index].State,
+		})
+	}
+
+	return changes, nil
+}
+
+func (f *EC2) newID(prefix string) string {
+	f.nextID++
+
+	return fmt.Sprintf("%s-%017x", prefix, f.nextID)
+}
+
+func (f *EC2) now() time.Time {
+	if f.Now != nil {
+		return f.Now()
+	}
+
+	return time.Now()
+}
+
+func (f *EC2) image(id string) *types.Image {
+	for i := range f.Images {
+		if aws.ToString(f.Images[i].ImageId) == id {
+			return &f.Images[i]
+		}
+	}
+
+	return nil
+}
+
+func (f *EC2) vpc(id string) *types.Vpc {
+	for i := range f.Vpcs {
+		if aws.ToString(f.Vpcs[i].VpcId) == id {
+			return &f.Vpcs[i]
+		}
+	}
+
+	return nil
+}
+
+func (f *EC2) defaultVpc() *types.Vpc {
+	for i := range f.Vpcs {
+		if aws.ToBool(f.Vpcs[i].IsDefault) {
+			return &f.Vpcs[i]
+		}
+	}
+
+	return nil
+}
+
+func (f *EC2) subnet(id string) *types.Subnet {
+	for i := range f.Subnets {
+		if aws.ToString(f.Subnets[i].SubnetId) == id {
+			return &f.Subnets[i]
+		}
+	}
+
+	return nil
+}
+
+func (f *EC2) securityGroup(id string) *types.SecurityGroup {
+	for i := range f.SecurityGroups {
+		if aws.ToString(f.SecurityGroups[i].GroupId) == id {
+			return &f.SecurityGroups[i]
+		}
+	}
+
+	return nil
+}
+
+func (f *EC2) instanceIndex(id string) int {
+	for i := range f.Instances {
+		if aws.ToString(f.Instances[i].InstanceId) == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func instanceState(name types.InstanceStateName) *types.InstanceState {
+	codes := map[types.InstanceStateName]int32{
+		types.InstanceStateNamePending:      0,
+		types.InstanceStateNameRunning:      16,
+		types.InstanceStateNameShuttingDown: 32,
+		types.InstanceStateNameTerminated:   48,
+		types.InstanceStateNameStopping:     64,
+		types.InstanceStateNameStopped:      80,
+	}
+
+	return &types.InstanceState{
+		Name: name,
+		Code: aws.Int32(codes[name]),
+	}
+}
+
+func metadataOptions(options *types.InstanceMetadataOptionsRequest) *types.InstanceMetadataOptionsResponse {
+	if options == nil {
+		return nil
+	}
+
+	return &types.InstanceMetadataOptionsResponse{
+		HttpEndpoint:            options.HttpEndpoint,
+		HttpTokens:              options.HttpTokens,
+		HttpPutResponseHopLimit: options.HttpPutResponseHopLimit,
+		InstanceMetadataTags:    options.InstanceMetadataTags,
+	}
+}
+
+func tagsFor(specifications []types.TagSpecification, resourceType types.ResourceType) []types.Tag {
+	tags := []types.Tag{}
+	for _, specification := range specifications {
+		if specification.ResourceType == resourceType {
+			tags = append(tags, specification.Tags...)
+		}
+	}
+
+	return tags
+}
+
+// matchFilters reports whether a resource with the given tags and
+// attributes matches all filters. Filter values may contain the * and ?
+// wildcards, unknown filter names never match.
+func matchFilters(filters []types.Filter, tags []types.Tag, attributes map[string]string) bool {
+	for _, filter := range filters {
+		name := aws.ToString(filter.Name)
+
+		values := []string{}
+		switch {
+		case strings.HasPrefix(name, "tag:"):
+			for _, tag := range tags {
+				if aws.ToString(tag.Key) == strings.TrimPrefix(name, "tag:") {
+					values = append(values, aws.ToString(tag.Value))
+				}
+			}
+		case name == "tag-key":
+			for _, tag := range tags {
+				values = append(values, aws.ToString(tag.Key))
+			}
+		default:
+			if value, ok := attributes[name]; ok {
+				values = append(values, value)
+			}
+		}
+
+		matched := false
+		for _, pattern := range filter.Values {
+			for _, value := range values {
+				if matchWildcard(pattern, value) {
+					matched = true
+				}
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchWildcard(pattern, value string) bool {
+	expression := regexp.QuoteMeta(pattern)
+	expression = strings.ReplaceAll(expression, `\*`, ".*")
+	expression = strings.ReplaceAll(expression, `\?`, ".")
+
+	return regexp.MustCompile("^" + expression + "$").MatchString(value)
+}
+
+// contains reports whether value is in ids, an empty ids list contains
+// every value
+func contains(ids []string, value string) bool {
+	if len(ids) == 0 {
+		return true
+	}
+
+	return containsAny(ids, value)
+}
+
+func containsAny(list []string, values ...string) bool {
+	for _, item := range list {
+		for _, value := range values {
+			if item == value {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// dryRun fails with DryRunOperation if the dry run flag is set, once the
+// request has been validated
+func dryRun(flag *bool) error {
+	if aws.ToBool(flag) {
+		return APIError("DryRunOperation", "Request would have succeeded, but DryRun flag is set.")
+	}
+
+	return nil
+}
+
+func insufficientCapacity(instanceType types.InstanceType) error {
+	return APIError(
+		"InsufficientInstanceCapacity",
+		"We currently do not have sufficient %s capacity in the Availability Zone you requested.",
+		instanceType,
+	)
+}
+
+func instanceProfileArn(instance types.Instance) string {
+	if instance.IamInstanceProfile == nil {
+		return ""
+	}
+
+	return aws.ToString(instance.IamInstanceProfile.Arn)
+}
+
+func containsState(states []types.InstanceStateName, state types.InstanceStateName) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setTags returns tags with the given tags added, overwriting those with the
+// same key
+func setTags(tags []types.Tag, set []types.Tag) []types.Tag {
+	result := []types.Tag{}
+	for _, tag := range tags {
+		overwritten := false
+		for _, setTag := range set {
+			overwritten = overwritten || aws.ToString(setTag.Key) == aws.ToString(tag.Key)
+		}
+
+		if !overwritten {
+			result = append(result, tag)
+		}
+	}
+
+	return append(result, set...)
+}
@@ -0,0 +1,316 @@
+package fake
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+// IAM is an in-memory IAM API for roles and instance profiles
+type IAM struct {
+	mu sync.Mutex
+
+	Roles            map[string]*types.Role
+	InstanceProfiles map[string]*types.InstanceProfile
+
+	// RolePolicies maps role names to their inline policy documents by name
+	RolePolicies map[string]map[string]string
+
+	// Errors makes the operation with the given name, e.g. "CreateRole",
+	// fail with the error
+	Errors map[string]error
+}
+
+// NewIAM returns an empty fake IAM API
+func NewIAM() *IAM {
+	return &IAM{
+		Roles:            map[string]*types.Role{},
+		InstanceProfiles: map[string]*types.InstanceProfile{},
+		RolePolicies:     map[string]map[string]string{},
+		Errors:           map[string]error{},
+	}
+}
+
+// AddInstanceProfile adds an instance profile and its role with the given
+// name and returns the ARN of the profile
+func (f *IAM) AddInstanceProfile(name string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	role := types.Role{
+		RoleName: aws.String(name),
+		Arn:      aws.String("arn:aws:iam::" + AccountID + ":role/" + name),
+	}
+	f.Roles[name] = &role
+
+	profile := &types.InstanceProfile{
+		InstanceProfileName: aws.String(name),
+		Arn:                 aws.String("arn:aws:iam::" + AccountID + ":instance-profile/" + name),
+		Roles:               []types.Role{role},
+	}
+	f.InstanceProfiles[name] = profile
+
+	return *profile.Arn
+}
+
+func (f *IAM) GetInstanceProfile(ctx context.Context, params *iam.GetInstanceProfileInput, optFns ...func(*iam.Options)) (*iam.GetInstanceProfileOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["GetInstanceProfile"]; err != nil {
+		return nil, err
+	}
+
+	profile, ok := f.InstanceProfiles[aws.ToString(params.InstanceProfileName)]
+	if !ok {
+		return nil, &types.NoSuchEntityException{
+			Message: aws.String("Instance Profile " + aws.ToString(params.InstanceProfileName) + " cannot be found."),
+		}
+	}
+
+	result := *profile
+	result.Roles = append([]types.Role{}, profile.Roles...)
+
+	return &iam.GetInstanceProfileOutput{InstanceProfile: &result}, nil
+}
+
+func (f *IAM) CreateInstanceProfile(ctx context.Context, params *iam.CreateInstanceProfileInput, optFns ...func(*iam.Options)) (*iam.CreateInstanceProfileOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["CreateInstanceProfile"]; err != nil {
+		return nil, err
+	}
+
+	name := aws.ToString(params.InstanceProfileName)
+	if _, ok := f.InstanceProfiles[name]; ok {
+		return nil, &types.EntityAlreadyExistsException{
+			Message: aws.String("Instance Profile " + name + " already exists."),
+		}
+	}
+
+	profile := &types.InstanceProfile{
+		InstanceProfileName: params.InstanceProfileName,
+		Arn:                 aws.String("arn:aws:iam::" + AccountID + ":instance-profile/" + name),
+		Tags:                params.Tags,
+	}
+	f.InstanceProfiles[name] = profile
+
+	return &iam.CreateInstanceProfileOutput{InstanceProfile: profile}, nil
+}
+
+func (f *IAM) AddRoleToInstanceProfile(ctx context.Context, params *iam.AddRoleToInstanceProfileInput, optFns ...func(*iam.Options)) (*iam.AddRoleToInstanceProfileOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["AddRoleToInstanceProfile"]; err != nil {
+		return nil, err
+	}
+
+	profile, ok := f.InstanceProfiles[aws.ToString(params.InstanceProfileName)]
+	if !ok {
+		return nil, &types.NoSuchEntityException{
+			Message: aws.String("Instance Profile " + aws.ToString(params.InstanceProfileName) + " cannot be found."),
+		}
+	}
+
+	role, ok := f.Roles[aws.ToString(params.RoleName)]
+	if !ok {
+		return nil, &types.NoSuchEntityException{
+			Message: aws.String("The role with name " + aws.ToString(params.RoleName) + " cannot be found."),
+		}
+	}
+
+	profile.Roles = append(profile.Roles, *role)
+
+	return &iam.AddRoleToInstanceProfileOutput{}, nil
+}
+
+func (f *IAM) CreateRole(ctx context.Context, params *iam.CreateRoleInput, optFns ...func(*iam.Options)) (*iam.CreateRoleOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["CreateRole"]; err != nil {
+		return nil, err
+	}
+
+	name := aws.ToString(params.RoleName)
+	if _, ok := f.Roles[name]; ok {
+		return nil, &types.EntityAlreadyExistsException{
+			Message: aws.String("Role with name " + name + " already exists."),
+		}
+	}
+
+	role := &types.Role{
+		RoleName:                 params.RoleName,
+		Arn:                      aws.String("arn:aws:iam::" + AccountID + ":role/" + name),
+		AssumeRolePolicyDocument: params.AssumeRolePolicyDocument,
+		Tags:                     params.Tags,
+	}
+	f.Roles[name] = role
+
+	return &iam.CreateRoleOutput{Role: role}, nil
+}
+
+func (f *IAM) PutRolePolicy(ctx context.Context, params *iam.PutRolePolicyInput, optFns ...func(*iam.Options)) (*iam.PutRolePolicyOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["PutRolePolicy"]; err != nil {
+		return nil, err
+	}
+
+	name := aws.ToString(params.RoleName)
+	if _, ok := f.Roles[name]; !ok {
+		return nil, &types.NoSuchEntityException{
+			Message: aws.String("The role with name " + name + " cannot be found."),
+		}
+	}
+
+	if f.RolePolicies[name] == nil {
+		f.RolePolicies[name] = map[string]string{}
+	}
+	f.RolePolicies[name][aws.ToString(params.PolicyName)] = aws.ToString(params.PolicyDocument)
+
+	return &iam.PutRolePolicyOutput{}, nil
+}
+
+func (f *IAM) RemoveRoleFromInstanceProfile(ctx context.Context, params *iam.RemoveRoleFromInstanceProfileInput, optFns ...func(*iam.Options)) (*iam.RemoveRoleFromInstanceProfileOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["RemoveRoleFromInstanceProfile"]; err != nil {
+		return nil, err
+	}
+
+	profile, ok := f.InstanceProfiles[aws.ToString(params.InstanceProfileName)]
+	if !ok {
+		return nil, &types.NoSuchEntityException{
+			Message: aws.String("Instance Profile " + aws.ToString(params.InstanceProfileName) + " cannot be found."),
+		}
+	}
+
+	for i, role := range profile.Roles {
+		if aws.ToString(role.RoleName) == aws.ToString(params.RoleName) {
+			profile.Roles = append(profile.Roles[:i], profile.Roles[i+1:]...)
+
+			return &iam.RemoveRoleFromInstanceProfileOutput{}, nil
+		}
+	}
+
+	return nil, &types.NoSuchEntityException{
+		Message: aws.String("The role with name " + aws.ToString(params.RoleName) + " cannot be found."),
+	}
+}
+
+func (f *IAM) DeleteInstanceProfile(ctx context.Context, params *iam.DeleteInstanceProfileInput, optFns ...func(*iam.Options)) (*iam.DeleteInstanceProfileOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["DeleteInstanceProfile"]; err != nil {
+		return nil, err
+	}
+
+	name := aws.ToString(params.InstanceProfileName)
+	profile, ok := f.InstanceProfiles[name]
+	if !ok {
+		return nil, &types.NoSuchEntityException{
+			Message: aws.String("Instance Profile " + name + " cannot be found."),
+		}
+	}
+
+	if len(profile.Roles) > 0 {
+		return nil, &types.DeleteConflictException{
+			Message: aws.String("Cannot delete entity, must remove roles from instance profile first."),
+		}
+	}
+
+	delete(f.InstanceProfiles, name)
+
+	return &iam.DeleteInstanceProfileOutput{}, nil
+}
+
+func (f *IAM) ListRolePolicies(ctx context.Context, params *iam.ListRolePoliciesInput, optFns ...func(*iam.Options)) (*iam.ListRolePoliciesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["ListRolePolicies"]; err != nil {
+		return nil, err
+	}
+
+	name := aws.ToString(params.RoleName)
+	if _, ok := f.Roles[name]; !ok {
+		return nil, &types.NoSuchEntityException{
+			Message: aws.String("The role with name " + name + " cannot be found."),
+		}
+	}
+
+	result := &iam.ListRolePoliciesOutput{}
+	for policy := range f.RolePolicies[name] {
+		result.PolicyNames = append(result.PolicyNames, policy)
+	}
+	sort.Strings(result.PolicyNames)
+
+	return result, nil
+}
+
+func (f *IAM) DeleteRolePolicy(ctx context.Context, params *iam.DeleteRolePolicyInput, optFns ...func(*iam.Options)) (*iam.DeleteRolePolicyOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["DeleteRolePolicy"]; err != nil {
+		return nil, err
+	}
+
+	name := aws.ToString(params.RoleName)
+	if _, ok := f.RolePolicies[name][aws.ToString(params.PolicyName)]; !ok {
+		return nil, &types.NoSuchEntityException{
+			Message: aws.String("The role policy with name " + aws.ToString(params.PolicyName) + " cannot be found."),
+		}
+	}
+
+	delete(f.RolePolicies[name], aws.ToString(params.PolicyName))
+
+	return &iam.DeleteRolePolicyOutput{}, nil
+}
+
+func (f *IAM) DeleteRole(ctx context.Context, params *iam.DeleteRoleInput, optFns ...func(*iam.Options)) (*iam.DeleteRoleOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.Errors["DeleteRole"]; err != nil {
+		return nil, err
+	}
+
+	name := aws.ToString(params.RoleName)
+	if _, ok := f.Roles[name]; !ok {
+		return nil, &types.NoSuchEntityException{
+			Message: aws.String("The role with name " + name + " cannot be found."),
+		}
+	}
+
+	if len(f.RolePolicies[name]) > 0 {
+		return nil, &types.DeleteConflictException{
+			Message: aws.String("Cannot delete entity, must delete policies first."),
+		}
+	}
+
+	for _, profile := range f.InstanceProfiles {
+		for _, role := range profile.Roles {
+			if aws.ToString(role.RoleName) == name {
+				return nil, &types.DeleteConflictException{
+					Message: aws.String("Cannot delete entity, must remove roles from instance profile first."),
+				}
+			}
+		}
+	}
+
+	delete(f.Roles, name)
+	delete(f.RolePolicies, name)
+
+	return &iam.DeleteRoleOutput{}, nil
+}
@@ -0,0 +1,63 @@
+package fake
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+)
+
+// IMDS is an in-memory instance metadata service of a single instance
+type IMDS struct {
+	// Document is the instance identity document of the instance
+	Document imds.InstanceIdentityDocument
+
+	// Metadata maps metadata paths, e.g. "mac", to their content
+	Metadata map[string]string
+
+	// Errors makes the operation with the given name, e.g.
+	// "GetInstanceIdentityDocument", fail with the error
+	Errors map[string]error
+}
+
+// NewIMDS returns a fake instance metadata service of the instance with the
+// given ID in the given region
+func NewIMDS(instanceID, region string) *IMDS {
+	return &IMDS{
+		Document: imds.InstanceIdentityDocument{
+			AccountID:  AccountID,
+			InstanceID: instanceID,
+			Region:     region,
+		},
+		Metadata: map[string]string{
+			"instance-id": instanceID,
+		},
+		Errors: map[string]error{},
+	}
+}
+
+func (f *IMDS) GetInstanceIdentityDocument(ctx context.Context, params *imds.GetInstanceIdentityDocumentInput, optFns ...func(*imds.Options)) (*imds.GetInstanceIdentityDocumentOutput, error) {
+	if err := f.Errors["GetInstanceIdentityDocument"]; err != nil {
+		return nil, err
+	}
+
+	return &imds.GetInstanceIdentityDocumentOutput{
+		InstanceIdentityDocument: f.Document,
+	}, nil
+}
+
+func (f *IMDS) GetMetadata(ctx context.Context, params *imds.GetMetadataInput, optFns ...func(*imds.Options)) (*imds.GetMetadataOutput, error) {
+	if err := f.Errors["GetMetadata"]; err != nil {
+		return nil, err
+	}
+
+	content, ok := f.Metadata[params.Path]
+	if !ok {
+		return nil, APIError("NotFound", "metadata path %s not found", params.Path)
+	}
+
+	return &imds.GetMetadataOutput{
+		Content: io.NopCloser(strings.NewReader(content)),
+	}, nil
+}
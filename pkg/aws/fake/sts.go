@@ -0,0 +1,55 @@
+package fake
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// STS is an in-memory STS API
+type STS struct {
+	// Arn is the ARN of the caller identity
+	Arn string
+
+	// DecodedMessages maps encoded authorization messages to their
+	// decoded JSON documents
+	DecodedMessages map[string]string
+
+	// Errors makes the operation with the given name, e.g.
+	// "GetCallerIdentity", fail with the error
+	Errors map[string]error
+}
+
+// NewSTS returns a fake STS API for the given caller identity
+func NewSTS(arn string) *STS {
+	return &STS{
+		Arn:             arn,
+		DecodedMessages: map[string]string{},
+		Errors:          map[string]error{},
+	}
+}
+
+func (f *STS) GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	if err := f.Errors["GetCallerIdentity"]; err != nil {
+		return nil, err
+	}
+
+	return &sts.GetCallerIdentityOutput{
+		Account: aws.String(AccountID),
+		Arn:     aws.String(f.Arn),
+	}, nil
+}
+
+func (f *STS) DecodeAuthorizationMessage(ctx context.Context, params *sts.DecodeAuthorizationMessageInput, optFns ...func(*sts.Options)) (*sts.DecodeAuthorizationMessageOutput, error) {
+	if err := f.Errors["DecodeAuthorizationMessage"]; err != nil {
+		return nil, err
+	}
+
+	message, ok := f.DecodedMessages[aws.ToString(params.EncodedMessage)]
+	if !ok {
+		return nil, APIError("InvalidAuthorizationMessageException", "Invalid encoded authorization message")
+	}
+
+	return &sts.DecodeAuthorizationMessageOutput{DecodedMessage: aws.String(message)}, nil
+}
@@ -0,0 +1,477 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamTypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/smithy-go"
+	"github.com/pkg/errors"
+)
+
+// Kinds of resources the garbage collection removes, in the order they
+// have to be deleted
+const (
+	OrphanedInstance        = "instance"
+	OrphanedAddress         = "elastic-ip"
+	OrphanedVolume          = "volume"
+	OrphanedSnapshot        = "snapshot"
+	OrphanedSecurityGroup   = "security-group"
+	OrphanedInstanceProfile = "instance-profile"
+)
+
+var orphanedKindOrder = map[string]int{
+	OrphanedInstance:        0,
+	OrphanedAddress:         1,
+	OrphanedVolume:          2,
+	OrphanedSnapshot:        3,
+	OrphanedSecurityGroup:   4,
+	OrphanedInstanceProfile: 5,
+}
+
+// stoppedAtRegexp matches the stop time in the state transition reason of
+// an instance, e.g. "User initiated (2023-06-01 12:00:00 GMT)"
+var stoppedAtRegexp = regexp.MustCompile(`\((\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}) GMT\)`)
+
+// securityGroupGracePeriod is the time a new security group is kept even if
+// no network interface uses it, as a create launches its instance only
+// after creating the group
+const securityGroupGracePeriod = time.Hour
+
+// OrphanedResource is a devpod resource the garbage collection would delete
+type OrphanedResource struct {
+	Region    string `json:"region,omitempty"`
+	Kind      string `json:"kind"`
+	ID        string `json:"id"`
+	MachineID string `json:"machineId,omitempty"`
+	Reason    string `json:"reason"`
+}
+
+// FindOrphanedResources returns the devpod resources of a region that are no
+// longer needed: instances stopped for longer than stoppedFor (disabled if
+// 0), unattached volumes, unassociated elastic IPs and security groups no
+// network interface uses. Instances and volumes with a devpod:keep-alive tag
+// are kept, as are security groups created within securityGroupGracePeriod.
+func FindOrphanedResources(
+	ctx context.Context,
+	svc EC2Client,
+	region string,
+	stoppedFor time.Duration,
+	now time.Time,
+) ([]OrphanedResource, error) {
+	orphans := []OrphanedResource{}
+
+	machines := map[string]bool{}
+	err := describeDevpodInstances(ctx, svc, func(instance types.Instance) {
+		machineID := getTagValue(instance.Tags, "devpod")
+		machines[machineID] = true
+
+		if stoppedFor <= 0 || instance.State == nil || instance.State.Name != types.InstanceStateNameStopped {
+			return
+		}
+
+		if IsKeptAlive(instance.Tags, now) {
+			return
+		}
+
+		stoppedAt, ok := getStoppedAt(instance)
+		if !ok || now.Sub(stoppedAt) < stoppedFor {
+			return
+		}
+
+		orphans = append(orphans, OrphanedResource{
+			Region:    region,
+			Kind:      OrphanedInstance,
+			ID:        aws.ToString(instance.InstanceId),
+			MachineID: machineID,
+			Reason:    fmt.Sprintf("stopped since %s", stoppedAt.UTC().Format(time.RFC3339)),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reason := func(machineID, fallback string) string {
+		if machineID != "" && machineID != "devpod" && !machines[machineID] {
+			return fmt.Sprintf("machine %s no longer exists", machineID)
+		}
+
+		return fallback
+	}
+
+	volumes, err := svc.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("tag-key"),
+				Values: []string{"devpod"},
+			},
+			{
+				Name:   aws.String("status"),
+				Values: []string{string(types.VolumeStateAvailable)},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, volume := range volumes.Volumes {
+		if IsKeptAlive(volume.Tags, now) {
+			continue
+		}
+
+		machineID := getTagValue(volume.Tags, "devpod")
+		orphans = append(orphans, OrphanedResource{
+			Region:    region,
+			Kind:      OrphanedVolume,
+			ID:        aws.ToString(volume.VolumeId),
+			MachineID: machineID,
+			Reason:    reason(machineID, "not attached to any instance"),
+		})
+	}
+
+	addresses, err := svc.DescribeAddresses(ctx, &ec2.DescribeAddressesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("tag-key"),
+				Values: []string{"devpod"},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, address := range addresses.Addresses {
+		if aws.ToString(address.AssociationId) != "" {
+			continue
+		}
+
+		machineID := getTagValue(address.Tags, "devpod")
+		orphans = append(orphans, OrphanedResource{
+			Region:    region,
+			Kind:      OrphanedAddress,
+			ID:        aws.ToString(address.AllocationId),
+			MachineID: machineID,
+			Reason:    reason(machineID, "not associated with any instance"),
+		})
+	}
+
+	groups, err := svc.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("tag-key"),
+				Values: []string{"devpod"},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(groups.SecurityGroups) > 0 {
+		groupIDs := []string{}
+		for _, group := range groups.SecurityGroups {
+			groupIDs = append(groupIDs, aws.ToString(group.GroupId))
+		}
+
+		used, err := getUsedSecurityGroups(ctx, svc, groupIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, group := range groups.SecurityGroups {
+			if used[aws.ToString(group.GroupId)] {
+				continue
+			}
+
+			// a concurrent create may be about to launch an instance with it
+			createdAt, err := time.Parse(time.RFC3339, getTagValue(group.Tags, TagKeyCreatedAt))
+			if err == nil && now.Sub(createdAt) < securityGroupGracePeriod {
+				continue
+			}
+
+			machineID := getTagValue(group.Tags, "devpod")
+			orphans = append(orphans, OrphanedResource{
+				Region:    region,
+				Kind:      OrphanedSecurityGroup,
+				ID:        aws.ToString(group.GroupId),
+				MachineID: machineID,
+				Reason:    reason(machineID, "not used by any network interface"),
+			})
+		}
+	}
+
+	SortOrphanedResources(orphans)
+
+	return orphans, nil
+}
+
+// FindOrphanedInstanceProfile returns the devpod instance profile and role
+// if they exist and no instance in the given regions uses them
+func FindOrphanedInstanceProfile(
+	ctx context.Context,
+	svc IAMClient,
+	regions map[string]EC2Client,
+) (*OrphanedResource, error) {
+	profile, err := svc.GetInstanceProfile(ctx, &iam.GetInstanceProfileInput{
+		InstanceProfileName: aws.String("devpod-ec2-role"),
+	})
+	if err != nil {
+		var notFound *iamTypes.NoSuchEntityException
+		if errors.As(err, &notFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	for region, ec2Svc := range regions {
+		result, err := ec2Svc.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+			Filters: []types.Filter{
+				{
+					Name:   aws.String("iam-instance-profile.arn"),
+					Values: []string{aws.ToString(profile.InstanceProfile.Arn)},
+				},
+				{
+					Name: aws.String("instance-state-name"),
+					Values: []string{
+						"pending",
+						"running",
+						"shutting-down",
+						"stopped",
+						"stopping",
+					},
+				},
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("describe instances in %s: %w", region, err)
+		}
+
+		if len(result.Reservations) > 0 {
+			return nil, nil
+		}
+	}
+
+	return &OrphanedResource{
+		Kind:   OrphanedInstanceProfile,
+		ID:     aws.ToString(profile.InstanceProfile.InstanceProfileName),
+		Reason: "not used by any instance",
+	}, nil
+}
+
+// DeleteOrphanedResource deletes the resource. With dryRun set the EC2
+// requests are only validated, IAM resources are left untouched.
+func DeleteOrphanedResource(
+	ctx context.Context,
+	svc EC2Client,
+	iamSvc IAMClient,
+	resource OrphanedResource,
+	dryRun bool,
+) error {
+	var err error
+
+	switch resource.Kind {
+	case OrphanedInstance:
+		_, err = svc.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
+			InstanceIds: []string{resource.ID},
+			DryRun:      aws.Bool(dryRun),
+		})
+	case OrphanedAddress:
+		_, err = svc.ReleaseAddress(ctx, &ec2.ReleaseAddressInput{
+			AllocationId: aws.String(resource.ID),
+			DryRun:       aws.Bool(dryRun),
+		})
+	case OrphanedVolume:
+		_, err = svc.DeleteVolume(ctx, &ec2.DeleteVolumeInput{
+			VolumeId: aws.String(resource.ID),
+			DryRun:   aws.Bool(dryRun),
+		})
+	case OrphanedSnapshot:
+		_, err = svc.DeleteSnapshot(ctx, &ec2.DeleteSnapshotInput{
+			SnapshotId: aws.String(resource.ID),
+			DryRun:     aws.Bool(dryRun),
+		})
+	case OrphanedSecurityGroup:
+		_, err = svc.DeleteSecurityGroup(ctx, &ec2.DeleteSecurityGroupInput{
+			GroupId: aws.String(resource.ID),
+			DryRun:  aws.Bool(dryRun),
+		})
+	case OrphanedInstanceProfile:
+		if dryRun {
+			return nil
+		}
+
+		err = DeleteDevpodInstanceProfile(ctx, iamSvc, resource.ID)
+	default:
+		return fmt.Errorf("unknown resource kind %s", resource.Kind)
+	}
+
+	if dryRun && IsDryRunSuccess(err) {
+		return nil
+	}
+
+	return err
+}
+
+// DeleteDevpodInstanceProfile deletes the instance profile together with its
+// roles and their inline policies
+func DeleteDevpodInstanceProfile(ctx context.Context, svc IAMClient, name string) error {
+	profile, err := svc.GetInstanceProfile(ctx, &iam.GetInstanceProfileInput{
+		InstanceProfileName: aws.String(name),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, role := range profile.InstanceProfile.Roles {
+		_, err := svc.RemoveRoleFromInstanceProfile(ctx, &iam.RemoveRoleFromInstanceProfileInput{
+			InstanceProfileName: aws.String(name),
+			RoleName:            role.RoleName,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = svc.DeleteInstanceProfile(ctx, &iam.DeleteInstanceProfileInput{
+		InstanceProfileName: aws.String(name),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, role := range profile.InstanceProfile.Roles {
+		err := deleteRole(ctx, svc, aws.ToString(role.RoleName))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SortOrphanedResources sorts the resources in the order they can be deleted
+func SortOrphanedResources(resources []OrphanedResource) {
+	sort.SliceStable(resources, func(i, j int) bool {
+		if resources[i].Kind != resources[j].Kind {
+			return orphanedKindOrder[resources[i].Kind] < orphanedKindOrder[resources[j].Kind]
+		}
+
+		if resources[i].Region != resources[j].Region {
+			return resources[i].Region < resources[j].Region
+		}
+
+		return resources[i].ID < resources[j].ID
+	})
+}
+
+// IsDryRunSuccess reports whether err is the DryRunOperation error EC2
+// returns when a dry run request would have succeeded
+func IsDryRunSuccess(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "DryRunOperation"
+}
+
+// describeDevpodInstances calls fn for every devpod instance that is not
+// terminated
+func describeDevpodInstances(ctx context.Context, svc EC2Client, fn func(types.Instance)) error {
+	input := &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("tag-key"),
+				Values: []string{"devpod"},
+			},
+			{
+				Name: aws.String("instance-state-name"),
+				Values: []string{
+					"pending",
+					"running",
+					"shutting-down",
+					"stopped",
+					"stopping",
+				},
+			},
+		},
+	}
+
+	for {
+		result, err := svc.DescribeInstances(ctx, input)
+		if err != nil {
+			return err
+		}
+
+		for _, reservation := range result.Reservations {
+			for _, instance := range reservation.Instances {
+				fn(instance)
+			}
+		}
+
+		if aws.ToString(result.NextToken) == "" {
+			return nil
+		}
+		input.NextToken = result.NextToken
+	}
+}
+
+func getUsedSecurityGroups(ctx context.Context, svc EC2Client, groupIDs []string) (map[string]bool, error) {
+	used := map[string]bool{}
+	input := &ec2.DescribeNetworkInterfacesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("group-id"),
+				Values: groupIDs,
+			},
+		},
+	}
+
+	for {
+		result, err := svc.DescribeNetworkInterfaces(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, networkInterface := range result.NetworkInterfaces {
+			for _, group := range networkInterface.Groups {
+				used[aws.ToString(group.GroupId)] = true
+			}
+		}
+
+		if aws.ToString(result.NextToken) == "" {
+			return used, nil
+		}
+		input.NextToken = result.NextToken
+	}
+}
+
+func getStoppedAt(instance types.Instance) (time.Time, bool) {
+	match := stoppedAtRegexp.FindStringSubmatch(aws.ToString(instance.StateTransitionReason))
+	if match == nil {
+		return time.Time{}, false
+	}
+
+	stoppedAt, err := time.Parse("2006-01-02 15:04:05", match[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return stoppedAt, true
+}
+
+func getTagValue(tags []types.Tag, key string) string {
+	for _, tag := range tags {
+		if aws.ToString(tag.Key) == key {
+			return aws.ToString(tag.Value)
+		}
+	}
+
+	return ""
+}
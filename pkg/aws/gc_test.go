@@ -0,0 +1,148 @@
+package aws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+)
+
+func TestFindOrphanedResources(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	stopped := func(id, machineID string, stoppedFor time.Duration) types.Instance {
+		instance := fake.Instance(id, machineID, now.Add(-90*24*time.Hour))
+		instance.State = &types.InstanceState{Name: types.InstanceStateNameStopped}
+		instance.StateTransitionReason = aws.String(
+			"User initiated (" + now.Add(-stoppedFor).Format("2006-01-02 15:04:05") + " GMT)",
+		)
+
+		return instance
+	}
+
+	running := fake.Instance("i-running", "devpod-running", now)
+	running.SecurityGroups = []types.GroupIdentifier{{GroupId: aws.String("sg-used")}}
+
+	ec2Client := fake.NewEC2()
+	ec2Client.Instances = []types.Instance{
+		running,
+		stopped("i-old", "devpod-old", 40*24*time.Hour),
+		stopped("i-recent", "devpod-recent", 24*time.Hour),
+	}
+	ec2Client.Volumes = []types.Volume{
+		{
+			VolumeId: aws.String("vol-gone"),
+			State:    types.VolumeStateAvailable,
+			Tags:     []types.Tag{tag("devpod", "devpod-gone")},
+		},
+		{
+			VolumeId:    aws.String("vol-attached"),
+			State:       types.VolumeStateInUse,
+			Tags:        []types.Tag{tag("devpod", "devpod-running")},
+			Attachments: []types.VolumeAttachment{{InstanceId: aws.String("i-running")}},
+		},
+		{
+			VolumeId: aws.String("vol-foreign"),
+			State:    types.VolumeStateAvailable,
+		},
+	}
+	ec2Client.Addresses = []types.Address{
+		{
+			AllocationId: aws.String("eipalloc-free"),
+			Tags:         []types.Tag{tag("devpod", "devpod-running")},
+		},
+		{
+			AllocationId:  aws.String("eipalloc-used"),
+			AssociationId: aws.String("eipassoc-1"),
+			Tags:          []types.Tag{tag("devpod", "devpod-running")},
+		},
+	}
+	ec2Client.SecurityGroups = []types.SecurityGroup{
+		{GroupId: aws.String("sg-used"), Tags: []types.Tag{tag("devpod", "devpod")}},
+		{GroupId: aws.String("sg-unused"), Tags: []types.Tag{tag("devpod", "devpod")}},
+		{GroupId: aws.String("sg-endpoint"), Tags: []types.Tag{tag("devpod", "devpod")}},
+		{GroupId: aws.String("sg-foreign")},
+		{
+			GroupId: aws.String("sg-new"),
+			Tags: []types.Tag{
+				tag("devpod", "devpod"),
+				tag(TagKeyCreatedAt, now.Add(-10*time.Minute).Format(time.RFC3339)),
+			},
+		},
+		{
+			GroupId: aws.String("sg-old"),
+			Tags: []types.Tag{
+				tag("devpod", "devpod"),
+				tag(TagKeyCreatedAt, now.Add(-2*time.Hour).Format(time.RFC3339)),
+			},
+		},
+	}
+	ec2Client.NetworkInterfaces = []types.NetworkInterface{
+		{
+			NetworkInterfaceId: aws.String("eni-endpoint"),
+			Groups:             []types.GroupIdentifier{{GroupId: aws.String("sg-endpoint")}},
+		},
+	}
+
+	orphans, err := FindOrphanedResources(context.Background(), ec2Client, "us-east-1", 30*24*time.Hour, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []OrphanedResource{
+		{Region: "us-east-1", Kind: OrphanedInstance, ID: "i-old", MachineID: "devpod-old", Reason: "stopped since 2023-04-22T12:00:00Z"},
+		{Region: "us-east-1", Kind: OrphanedAddress, ID: "eipalloc-free", MachineID: "devpod-running", Reason: "not associated with any instance"},
+		{Region: "us-east-1", Kind: OrphanedVolume, ID: "vol-gone", MachineID: "devpod-gone", Reason: "machine devpod-gone no longer exists"},
+		{Region: "us-east-1", Kind: OrphanedSecurityGroup, ID: "sg-old", MachineID: "devpod", Reason: "not used by any network interface"},
+		{Region: "us-east-1", Kind: OrphanedSecurityGroup, ID: "sg-unused", MachineID: "devpod", Reason: "not used by any network interface"},
+	}
+
+	if len(orphans) != len(expected) {
+		t.Fatalf("expected %+v, got %+v", expected, orphans)
+	}
+
+	for i := range expected {
+		if orphans[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], orphans[i])
+		}
+	}
+}
+
+func TestDeleteOrphanedResource(t *testing.T) {
+	ec2Client := fake.NewEC2()
+	ec2Client.Volumes = []types.Volume{{VolumeId: aws.String("vol-1"), State: types.VolumeStateAvailable}}
+	providerAws, iamClient := newTestProvider(t, ec2Client)
+
+	_, err := CreateDevpodInstanceProfile(context.Background(), providerAws)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volume := OrphanedResource{Kind: OrphanedVolume, ID: "vol-1"}
+
+	err = DeleteOrphanedResource(context.Background(), ec2Client, iamClient, volume, true)
+	checkError(t, err, "")
+	if len(ec2Client.Volumes) != 1 {
+		t.Fatalf("expected dry run to keep the volume")
+	}
+
+	err = DeleteOrphanedResource(context.Background(), ec2Client, iamClient, volume, false)
+	checkError(t, err, "")
+	if len(ec2Client.Volumes) != 0 {
+		t.Fatalf("expected volume to be deleted")
+	}
+
+	err = DeleteOrphanedResource(context.Background(), ec2Client, iamClient, volume, false)
+	checkError(t, err, "InvalidVolume.NotFound")
+
+	profile := OrphanedResource{Kind: OrphanedInstanceProfile, ID: "devpod-ec2-role"}
+	err = DeleteOrphanedResource(context.Background(), ec2Client, iamClient, profile, false)
+	checkError(t, err, "")
+
+	if len(iamClient.InstanceProfiles) != 0 || len(iamClient.Roles) != 0 || len(iamClient.RolePolicies) != 0 {
+		t.Errorf("expected instance profile, role and policies to be deleted")
+	}
+}
@@ -0,0 +1,63 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/options"
+)
+
+// hibernationHeadroomGB is the space the root volume needs next to the
+// memory that is written to it when the instance hibernates
+const hibernationHeadroomGB = 8
+
+// CheckHibernationSupport verifies that the instance type supports
+// hibernation and that a root volume of the given size can hold its memory
+func CheckHibernationSupport(
+	ctx context.Context,
+	svc EC2Client,
+	instanceType string,
+	diskSizeGB int,
+) error {
+	result, err := svc.DescribeInstanceTypes(ctx, &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: []types.InstanceType{types.InstanceType(instanceType)},
+	})
+	if err != nil {
+		return err
+	} else if len(result.InstanceTypes) == 0 {
+		return fmt.Errorf("%s=%s, but %s is not available in this region", options.AWS_STOP_ACTION, options.StopActionHibernate, instanceType)
+	}
+
+	info := result.InstanceTypes[0]
+	if !aws.ToBool(info.HibernationSupported) {
+		return fmt.Errorf(
+			"%s=%s, but %s does not support hibernation, use a different instance type or %s=%s",
+			options.AWS_STOP_ACTION,
+			options.StopActionHibernate,
+			instanceType,
+			options.AWS_STOP_ACTION,
+			options.StopActionStop,
+		)
+	}
+
+	if info.MemoryInfo != nil {
+		memoryGB := int((aws.ToInt64(info.MemoryInfo.SizeInMiB) + 1023) / 1024)
+		if minimum := memoryGB + hibernationHeadroomGB; diskSizeGB < minimum {
+			return fmt.Errorf(
+				"%s=%s, but the %dGB root volume cannot hold the %dGB memory of %s, set %s to at least %d",
+				options.AWS_STOP_ACTION,
+				options.StopActionHibernate,
+				diskSizeGB,
+				memoryGB,
+				instanceType,
+				options.AWS_DISK_SIZE,
+				minimum,
+			)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package aws
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+	"github.com/loft-sh/devpod-provider-aws/pkg/options"
+)
+
+func TestCreateHibernation(t *testing.T) {
+	tests := []struct {
+		name         string
+		instanceType string
+		diskSizeGB   int
+		err          string
+	}{
+		{
+			name:         "supported",
+			instanceType: "c5.xlarge",
+			diskSizeGB:   40,
+		},
+		{
+			name:         "instance type without hibernation",
+			instanceType: "t2.micro",
+			diskSizeGB:   40,
+			err:          "t2.micro does not support hibernation",
+		},
+		{
+			name:         "root volume smaller than the memory",
+			instanceType: "c5.xlarge",
+			diskSizeGB:   12,
+			err:          "set AWS_DISK_SIZE to at least 16",
+		},
+		{
+			name:         "unknown instance type",
+			instanceType: "x9.unknown",
+			diskSizeGB:   40,
+			err:          "InvalidInstanceType",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec2Client := fake.NewDefaultEC2()
+
+			provider, iamClient := newTestProvider(t, ec2Client)
+			iamClient.AddInstanceProfile("devpod-ec2-role")
+			provider.Config.StopAction = options.StopActionHibernate
+			provider.Config.MachineType = test.instanceType
+			provider.Config.DiskSizeGB = test.diskSizeGB
+
+			result, err := Create(context.Background(), provider)
+			checkError(t, err, test.err)
+			if err != nil {
+				if len(ec2Client.Instances) != 0 {
+					t.Errorf("expected no instance, got %d", len(ec2Client.Instances))
+				}
+				return
+			}
+
+			instance := result.Instances[0]
+			if instance.HibernationOptions == nil || !aws.ToBool(instance.HibernationOptions.Configured) {
+				t.Errorf("expected hibernation to be configured, got %v", instance.HibernationOptions)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package aws
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/options"
+	"github.com/loft-sh/devpod/pkg/log"
+)
+
+// GetMetadataOptions returns the instance metadata service options of
+// AWS_IMDS_HOP_LIMIT, AWS_IMDS_TOKENS and AWS_IMDS_TAGS
+func GetMetadataOptions(config *options.Options) *types.InstanceMetadataOptionsRequest {
+	hopLimit := config.IMDSHopLimit
+	if hopLimit == 0 {
+		hopLimit = 1
+	}
+
+	tokens := types.HttpTokensStateRequired
+	if config.IMDSTokens == options.IMDSTokensOptional {
+		tokens = types.HttpTokensStateOptional
+	}
+
+	tags := types.InstanceMetadataTagsStateDisabled
+	if config.IMDSTags {
+		tags = types.InstanceMetadataTagsStateEnabled
+	}
+
+	return &types.InstanceMetadataOptionsRequest{
+		HttpEndpoint:            types.InstanceMetadataEndpointStateEnabled,
+		HttpTokens:              tokens,
+		HttpPutResponseHopLimit: aws.Int32(hopLimit),
+		InstanceMetadataTags:    tags,
+	}
+}
+
+// ReconcileMetadataOptions changes the instance metadata service options of
+// the instance if they differ from the configured ones, e.g. after
+// AWS_IMDS_HOP_LIMIT was raised for an existing machine
+func ReconcileMetadataOptions(
+	ctx context.Context,
+	svc EC2Client,
+	instance types.Instance,
+	config *options.Options,
+	logs log.Logger,
+) error {
+	expected := GetMetadataOptions(config)
+
+	current := instance.MetadataOptions
+	if current != nil &&
+		current.HttpTokens == expected.HttpTokens &&
+		aws.ToInt32(current.HttpPutResponseHopLimit) == aws.ToInt32(expected.HttpPutResponseHopLimit) &&
+		current.InstanceMetadataTags == expected.InstanceMetadataTags {
+		return nil
+	}
+
+	logs.Infof(
+		"Changing the metadata options of instance %s to hop limit %d, tokens %s and tags %s",
+		aws.ToString(instance.InstanceId),
+		aws.ToInt32(expected.HttpPutResponseHopLimit),
+		expected.HttpTokens,
+		expected.InstanceMetadataTags,
+	)
+
+	_, err := svc.ModifyInstanceMetadataOptions(ctx, &ec2.ModifyInstanceMetadataOptionsInput{
+		InstanceId:              instance.InstanceId,
+		HttpEndpoint:            expected.HttpEndpoint,
+		HttpTokens:              expected.HttpTokens,
+		HttpPutResponseHopLimit: expected.HttpPutResponseHopLimit,
+		InstanceMetadataTags:    expected.InstanceMetadataTags,
+	})
+
+	return err
+}
@@ -0,0 +1,34 @@
+package aws
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// GetInstanceStoreInfo returns the NVMe instance store of the instance type,
+// or nil if it has none
+func GetInstanceStoreInfo(
+	ctx context.Context,
+	svc EC2Client,
+	instanceType string,
+) (*types.InstanceStorageInfo, error) {
+	result, err := svc.DescribeInstanceTypes(ctx, &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: []types.InstanceType{types.InstanceType(instanceType)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, info := range result.InstanceTypes {
+		storage := info.InstanceStorageInfo
+		if storage == nil || len(storage.Disks) == 0 || storage.NvmeSupport == types.EphemeralNvmeSupportUnsupported {
+			continue
+		}
+
+		return storage, nil
+	}
+
+	return nil, nil
+}
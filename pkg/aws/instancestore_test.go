@@ -0,0 +1,87 @@
+package aws
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+)
+
+func TestGetUserDataInstanceStore(t *testing.T) {
+	tests := []struct {
+		name         string
+		instanceType string
+		docker       bool
+		expected     bool
+	}{
+		{
+			name:         "instance store",
+			instanceType: "c5d.xlarge",
+			expected:     true,
+		},
+		{
+			name:         "instance store for docker",
+			instanceType: "c5d.xlarge",
+			docker:       true,
+			expected:     true,
+		},
+		{
+			name:         "no instance store",
+			instanceType: "c5.xlarge",
+		},
+		{
+			name:         "no NVMe instance store",
+			instanceType: "m3.medium",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec2Client := fake.NewDefaultEC2()
+
+			c5d := fake.InstanceType("c5d.xlarge", "x86_64", true)
+			c5d.InstanceStorageInfo = &types.InstanceStorageInfo{
+				Disks:         []types.DiskInfo{{Count: aws.Int32(1), SizeInGB: aws.Int64(100), Type: types.DiskTypeSsd}},
+				NvmeSupport:   types.EphemeralNvmeSupportRequired,
+				TotalSizeInGB: aws.Int64(100),
+			}
+			m3 := fake.InstanceType("m3.medium", "x86_64", false)
+			m3.InstanceStorageInfo = &types.InstanceStorageInfo{
+				Disks:         []types.DiskInfo{{Count: aws.Int32(1), SizeInGB: aws.Int64(4), Type: types.DiskTypeSsd}},
+				NvmeSupport:   types.EphemeralNvmeSupportUnsupported,
+				TotalSizeInGB: aws.Int64(4),
+			}
+			ec2Client.InstanceTypes = append(ec2Client.InstanceTypes, c5d, m3)
+
+			provider, _ := newTestProvider(t, ec2Client)
+			provider.Config.MachineType = test.instanceType
+			provider.Config.InstanceStore = true
+			provider.Config.InstanceStoreDocker = test.docker
+			provider.Config.DockerBootstrap = test.docker
+
+			encoded, err := GetUserData(context.Background(), provider)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			userData, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			mounted := strings.Contains(string(userData), "15-devpod-instance-store.sh")
+			if mounted != test.expected {
+				t.Errorf("expected instance store to be mounted: %v, got %v", test.expected, mounted)
+			}
+
+			dataRoot := strings.Contains(string(userData), `"data-root": "/mnt/instance-store/docker"`)
+			if dataRoot != (test.expected && test.docker) {
+				t.Errorf("expected docker data root on the instance store: %v, got %v", test.expected && test.docker, dataRoot)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package aws
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// pollJitter is the fraction poll intervals are randomized by, so machines
+// that are created or started at the same time do not poll in lockstep
+const pollJitter = 0.5
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// jitter randomizes d by up to pollJitter in either direction
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return d
+	}
+
+	jitterMu.Lock()
+	factor := jitterRand.Float64()*2 - 1
+	jitterMu.Unlock()
+
+	return d + time.Duration(factor*pollJitter*float64(d))
+}
+
+// The instance waiters of the SDK jitter their delays only from the second
+// retry on, these options jitter the first delay as well
+
+func jitterRunningWaiter(o *ec2.InstanceRunningWaiterOptions) {
+	o.MinDelay = jitter(o.MinDelay)
+}
+
+func jitterStoppedWaiter(o *ec2.InstanceStoppedWaiterOptions) {
+	o.MinDelay = jitter(o.MinDelay)
+}
+
+func jitterTerminatedWaiter(o *ec2.InstanceTerminatedWaiterOptions) {
+	o.MinDelay = jitter(o.MinDelay)
+}
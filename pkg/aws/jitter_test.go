@@ -0,0 +1,27 @@
+package aws
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJitter(t *testing.T) {
+	if d := jitter(0); d != 0 {
+		t.Errorf("expected no delay, got %s", d)
+	}
+
+	interval := 10 * time.Second
+	distinct := map[time.Duration]bool{}
+	for i := 0; i < 100; i++ {
+		d := jitter(interval)
+		if d < interval/2 || d > interval*3/2 {
+			t.Fatalf("expected a delay between %s and %s, got %s", interval/2, interval*3/2, d)
+		}
+
+		distinct[d] = true
+	}
+
+	if len(distinct) < 2 {
+		t.Errorf("expected randomized delays, got %v", distinct)
+	}
+}
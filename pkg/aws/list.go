@@ -0,0 +1,217 @@
+package aws
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Machine describes a devpod instance found in the account
+type Machine struct {
+	ID               string          `json:"id"`
+	InstanceID       string          `json:"instanceId"`
+	Region           string          `json:"region"`
+	Owner            string          `json:"owner,omitempty"`
+	State            string          `json:"state"`
+	InstanceType     string          `json:"instanceType"`
+	AvailabilityZone string          `json:"availabilityZone,omitempty"`
+	PublicIP         string          `json:"publicIp,omitempty"`
+	PrivateIP        string          `json:"privateIp,omitempty"`
+	CreatedAt        time.Time       `json:"createdAt"`
+	LaunchedAt       time.Time       `json:"launchedAt"`
+	Volumes          []MachineVolume `json:"volumes,omitempty"`
+
+	// MonthlyCost is a rough estimate in USD based on us-east-1 on-demand
+	// prices, only set if the prices of the instance type and all volumes
+	// are known
+	MonthlyCost *float64 `json:"monthlyCost,omitempty"`
+}
+
+// MachineVolume describes an EBS volume attached to a devpod instance
+type MachineVolume struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	SizeGB int32  `json:"sizeGB"`
+}
+
+// ListRegions returns the regions enabled for the account
+func ListRegions(ctx context.Context, svc EC2Client) ([]string, error) {
+	result, err := svc.DescribeRegions(ctx, &ec2.DescribeRegionsInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	regions := []string{}
+	for _, region := range result.Regions {
+		regions = append(regions, aws.ToString(region.RegionName))
+	}
+	sort.Strings(regions)
+
+	return regions, nil
+}
+
+// ListMachines returns all devpod instances that are not terminated, oldest
+// first
+func ListMachines(ctx context.Context, svc EC2Client, region string) ([]Machine, error) {
+	machines := []Machine{}
+	err := describeDevpodInstances(ctx, svc, func(instance types.Instance) {
+		machines = append(machines, newMachine(instance, region))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(machines) == 0 {
+		return machines, nil
+	}
+
+	volumes, err := getInstanceVolumes(ctx, svc, machines)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range machines {
+		machines[i].Volumes = volumes[machines[i].InstanceID]
+		machines[i].MonthlyCost = estimateMachineMonthlyCost(machines[i])
+	}
+
+	sort.SliceStable(machines, func(i, j int) bool {
+		return machines[i].CreatedAt.Before(machines[j].CreatedAt)
+	})
+
+	return machines, nil
+}
+
+func newMachine(instance types.Instance, region string) Machine {
+	machine := Machine{
+		InstanceID:   aws.ToString(instance.InstanceId),
+		Region:       region,
+		InstanceType: string(instance.InstanceType),
+		PublicIP:     aws.ToString(instance.PublicIpAddress),
+		PrivateIP:    aws.ToString(instance.PrivateIpAddress),
+		LaunchedAt:   aws.ToTime(instance.LaunchTime),
+	}
+
+	if instance.State != nil {
+		machine.State = string(instance.State.Name)
+	}
+
+	if instance.Placement != nil {
+		machine.AvailabilityZone = aws.ToString(instance.Placement.AvailabilityZone)
+	}
+
+	for _, tag := range instance.Tags {
+		switch aws.ToString(tag.Key) {
+		case "devpod":
+			machine.ID = aws.ToString(tag.Value)
+		case TagKeyOwner:
+			machine.Owner = aws.ToString(tag.Value)
+		case TagKeyCreatedAt:
+			createdAt, err := time.Parse(time.RFC3339, aws.ToString(tag.Value))
+			if err == nil {
+				machine.CreatedAt = createdAt
+			}
+		}
+	}
+
+	// instances created before the creation time was tagged still carry it
+	// as the attach time of their root volume, the launch time changes
+	// with every start
+	if machine.CreatedAt.IsZero() {
+		for _, mapping := range instance.BlockDeviceMappings {
+			if mapping.Ebs == nil || mapping.Ebs.AttachTime == nil {
+				continue
+			}
+
+			if machine.CreatedAt.IsZero() || mapping.Ebs.AttachTime.Before(machine.CreatedAt) {
+				machine.CreatedAt = *mapping.Ebs.AttachTime
+			}
+		}
+	}
+
+	if machine.CreatedAt.IsZero() {
+		machine.CreatedAt = machine.LaunchedAt
+	}
+
+	return machine
+}
+
+func getInstanceVolumes(ctx context.Context, svc EC2Client, machines []Machine) (map[string][]MachineVolume, error) {
+	instanceIDs := []string{}
+	for _, machine := range machines {
+		instanceIDs = append(instanceIDs, machine.InstanceID)
+	}
+
+	volumes := map[string][]MachineVolume{}
+
+	// filters accept at most 200 values
+	for start := 0; start < len(instanceIDs); start += 200 {
+		end := start + 200
+		if end > len(instanceIDs) {
+			end = len(instanceIDs)
+		}
+
+		input := &ec2.DescribeVolumesInput{
+			Filters: []types.Filter{
+				{
+					Name:   aws.String("attachment.instance-id"),
+					Values: instanceIDs[start:end],
+				},
+			},
+		}
+
+		for {
+			result, err := svc.DescribeVolumes(ctx, input)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, volume := range result.Volumes {
+				for _, attachment := range volume.Attachments {
+					instanceID := aws.ToString(attachment.InstanceId)
+					volumes[instanceID] = append(volumes[instanceID], MachineVolume{
+						ID:     aws.ToString(volume.VolumeId),
+						Type:   string(volume.VolumeType),
+						SizeGB: aws.ToInt32(volume.Size),
+					})
+				}
+			}
+
+			if aws.ToString(result.NextToken) == "" {
+				break
+			}
+			input.NextToken = result.NextToken
+		}
+	}
+
+	return volumes, nil
+}
+
+func estimateMachineMonthlyCost(machine Machine) *float64 {
+	cost := 0.0
+
+	// stopped instances only pay for their storage
+	if machine.State != string(types.InstanceStateNameStopped) {
+		hourly, ok := EstimateInstanceHourlyCost(machine.InstanceType)
+		if !ok {
+			return nil
+		}
+
+		cost += hourly * hoursPerMonth
+	}
+
+	for _, volume := range machine.Volumes {
+		monthly, ok := EstimateVolumeMonthlyCost(types.VolumeType(volume.Type), volume.SizeGB)
+		if !ok {
+			return nil
+		}
+
+		cost += monthly
+	}
+
+	return &cost
+}
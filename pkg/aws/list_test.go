@@ -0,0 +1,170 @@
+package aws
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+)
+
+func TestListMachines(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tagged := fake.Instance("i-tagged", "devpod-tagged", now)
+	tagged.Tags = append(
+		tagged.Tags,
+		tag(TagKeyOwner, testOwner),
+		tag(TagKeyCreatedAt, now.Add(-72*time.Hour).Format(time.RFC3339)),
+	)
+	tagged.Placement = &types.Placement{AvailabilityZone: aws.String("us-east-1a")}
+
+	// created before the creation time was tagged, falls back to the
+	// attach time of the root volume
+	legacy := fake.Instance("i-legacy", "devpod-legacy", now)
+	legacy.State = &types.InstanceState{Name: types.InstanceStateNameStopped}
+	legacy.BlockDeviceMappings = []types.InstanceBlockDeviceMapping{
+		{
+			DeviceName: aws.String("/dev/sda1"),
+			Ebs:        &types.EbsInstanceBlockDevice{AttachTime: aws.Time(now.Add(-96 * time.Hour))},
+		},
+	}
+
+	unknown := fake.Instance("i-unknown", "devpod-unknown", now)
+	unknown.InstanceType = types.InstanceTypeX2iedn32xlarge
+
+	terminated := fake.Instance("i-terminated", "devpod-terminated", now)
+	terminated.State = &types.InstanceState{Name: types.InstanceStateNameTerminated}
+
+	untagged := fake.Instance("i-untagged", "", now)
+	untagged.Tags = nil
+
+	ec2Client := fake.NewEC2()
+	ec2Client.Instances = []types.Instance{tagged, legacy, unknown, terminated, untagged}
+	ec2Client.Volumes = []types.Volume{
+		{
+			VolumeId:    aws.String("vol-tagged"),
+			Size:        aws.Int32(40),
+			VolumeType:  types.VolumeTypeGp3,
+			Attachments: []types.VolumeAttachment{{InstanceId: aws.String("i-tagged")}},
+		},
+		{
+			VolumeId:    aws.String("vol-legacy"),
+			Size:        aws.Int32(100),
+			VolumeType:  types.VolumeTypeGp2,
+			Attachments: []types.VolumeAttachment{{InstanceId: aws.String("i-legacy")}},
+		},
+	}
+
+	machines, err := ListMachines(context.Background(), ec2Client, "us-east-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ids := []string{}
+	for _, machine := range machines {
+		ids = append(ids, machine.ID)
+	}
+
+	// oldest first, terminated and untagged instances are skipped
+	expected := []string{"devpod-legacy", "devpod-tagged", "devpod-unknown"}
+	if len(ids) != len(expected) {
+		t.Fatalf("expected machines %v, got %v", expected, ids)
+	}
+	for i := range expected {
+		if ids[i] != expected[i] {
+			t.Fatalf("expected machines %v, got %v", expected, ids)
+		}
+	}
+
+	legacyMachine, taggedMachine, unknownMachine := machines[0], machines[1], machines[2]
+
+	if taggedMachine.Owner != testOwner || taggedMachine.Region != "us-east-1" || taggedMachine.AvailabilityZone != "us-east-1a" {
+		t.Errorf("unexpected machine %+v", taggedMachine)
+	}
+
+	if !taggedMachine.CreatedAt.Equal(now.Add(-72 * time.Hour)) {
+		t.Errorf("expected creation time from tag, got %v", taggedMachine.CreatedAt)
+	}
+
+	if !legacyMachine.CreatedAt.Equal(now.Add(-96 * time.Hour)) {
+		t.Errorf("expected creation time from root volume, got %v", legacyMachine.CreatedAt)
+	}
+
+	// c5.xlarge at 0.17/h plus 40GB gp3
+	if taggedMachine.MonthlyCost == nil || *taggedMachine.MonthlyCost < 127.29 || *taggedMachine.MonthlyCost > 127.31 {
+		t.Errorf("unexpected cost %v", taggedMachine.MonthlyCost)
+	}
+
+	// stopped instances only pay for their volumes
+	if legacyMachine.MonthlyCost == nil || *legacyMachine.MonthlyCost < 9.99 || *legacyMachine.MonthlyCost > 10.01 {
+		t.Errorf("unexpected cost %v", legacyMachine.MonthlyCost)
+	}
+
+	if unknownMachine.MonthlyCost != nil {
+		t.Errorf("expected unknown cost, got %v", *unknownMachine.MonthlyCost)
+	}
+}
+
+func TestEstimateInstanceHourlyCost(t *testing.T) {
+	tests := []struct {
+		instanceType string
+		expected     float64
+		ok           bool
+	}{
+		{instanceType: "t3.micro", expected: 0.0104, ok: true},
+		{instanceType: "m5.large", expected: 0.096, ok: true},
+		{instanceType: "c6g.4xlarge", expected: 0.544, ok: true},
+		{instanceType: "r6i.xlarge", expected: 0.252, ok: true},
+		{instanceType: "c5d.2xlarge", expected: 0.384, ok: true},
+		{instanceType: "m6id.large", expected: 0.1187, ok: true},
+		{instanceType: "cc2.8xlarge", expected: 2, ok: true},
+		{instanceType: "p4d.24xlarge"},
+		{instanceType: "t3.huge"},
+		{instanceType: "invalid"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.instanceType, func(t *testing.T) {
+			cost, ok := EstimateInstanceHourlyCost(test.instanceType)
+			if ok != test.ok {
+				t.Fatalf("expected ok %v, got %v", test.ok, ok)
+			}
+
+			if cost < test.expected-0.00001 || cost > test.expected+0.00001 {
+				t.Errorf("expected %v, got %v", test.expected, cost)
+			}
+		})
+	}
+}
+
+func TestEstimateSuggestedInstanceTypes(t *testing.T) {
+	content, err := os.ReadFile("../../hack/provider/provider.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the suggestions of AWS_INSTANCE_TYPE, up to the next option
+	suggestions := strings.SplitN(string(content), "\n  AWS_INSTANCE_TYPE:\n", 2)[1]
+	suggestions = regexp.MustCompile(`\n  [A-Z_]+:\n`).Split(suggestions, 2)[0]
+
+	// the default and the examples of the README
+	instanceTypes := []string{"c5.xlarge", "c5d.xlarge", "m6id.xlarge"}
+	for _, match := range regexp.MustCompile(`(?m)^      - (\S+)$`).FindAllStringSubmatch(suggestions, -1) {
+		instanceTypes = append(instanceTypes, match[1])
+	}
+	if len(instanceTypes) == 3 {
+		t.Fatal("expected instance type suggestions")
+	}
+
+	for _, instanceType := range instanceTypes {
+		if _, ok := EstimateInstanceHourlyCost(instanceType); !ok {
+			t.Errorf("expected a price for %s", instanceType)
+		}
+	}
+}
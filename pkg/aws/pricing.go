@@ -0,0 +1,124 @@
+package aws
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// hoursPerMonth is the number of hours AWS uses for monthly estimates
+const hoursPerMonth = 730
+
+// largeHourlyPrices are the us-east-1 on-demand Linux prices in USD of the
+// "large" size of common instance families. Other sizes scale linearly.
+var largeHourlyPrices = map[string]float64{
+	"t2":   0.0928,
+	"t3":   0.0832,
+	"t3a":  0.0752,
+	"t4g":  0.0672,
+	"c4":   0.1,
+	"c5":   0.085,
+	"c5a":  0.077,
+	"c5d":  0.096,
+	"c5n":  0.108,
+	"c6a":  0.0765,
+	"c6i":  0.085,
+	"c6id": 0.1008,
+	"c6g":  0.068,
+	"c6gd": 0.0768,
+	"c7a":  0.10264,
+	"c7i":  0.08925,
+	"c7g":  0.0725,
+	"m4":   0.1,
+	"m5":   0.096,
+	"m5a":  0.086,
+	"m5d":  0.113,
+	"m5ad": 0.103,
+	"m6a":  0.0864,
+	"m6i":  0.096,
+	"m6id": 0.1187,
+	"m6g":  0.077,
+	"m6gd": 0.0904,
+	"m7a":  0.11592,
+	"m7i":  0.1008,
+	"m7g":  0.0816,
+	"r4":   0.133,
+	"r5":   0.126,
+	"r5a":  0.113,
+	"r5d":  0.144,
+	"r6a":  0.1134,
+	"r6i":  0.126,
+	"r6id": 0.1512,
+	"r6g":  0.1008,
+	"r6gd": 0.1152,
+	"r7g":  0.1071,
+	"r7i":  0.1323,
+	"i3":   0.156,
+	"i4i":  0.172,
+	// only available as cc2.8xlarge
+	"cc2": 0.125,
+}
+
+var sizeMultipliers = map[string]float64{
+	"nano":     1.0 / 16,
+	"micro":    1.0 / 8,
+	"small":    1.0 / 4,
+	"medium":   1.0 / 2,
+	"large":    1,
+	"xlarge":   2,
+	"2xlarge":  4,
+	"4xlarge":  8,
+	"8xlarge":  16,
+	"9xlarge":  18,
+	"10xlarge": 20,
+	"12xlarge": 24,
+	"16xlarge": 32,
+	"18xlarge": 36,
+	"24xlarge": 48,
+	"32xlarge": 64,
+	"48xlarge": 96,
+}
+
+// volumeMonthlyPrices are the us-east-1 EBS prices in USD per GB-month
+var volumeMonthlyPrices = map[types.VolumeType]float64{
+	types.VolumeTypeGp3:      0.08,
+	types.VolumeTypeGp2:      0.10,
+	types.VolumeTypeIo1:      0.125,
+	types.VolumeTypeIo2:      0.125,
+	types.VolumeTypeSt1:      0.045,
+	types.VolumeTypeSc1:      0.015,
+	types.VolumeTypeStandard: 0.05,
+}
+
+// EstimateInstanceHourlyCost returns a rough us-east-1 on-demand hourly price
+// of the given instance type, false if the type is unknown. Prices in other
+// regions differ.
+func EstimateInstanceHourlyCost(instanceType string) (float64, bool) {
+	family, size, found := strings.Cut(instanceType, ".")
+	if !found {
+		return 0, false
+	}
+
+	price, ok := largeHourlyPrices[family]
+	if !ok {
+		return 0, false
+	}
+
+	multiplier, ok := sizeMultipliers[size]
+	if !ok {
+		return 0, false
+	}
+
+	return price * multiplier, true
+}
+
+// EstimateVolumeMonthlyCost returns a rough us-east-1 monthly storage price
+// of a volume, false if the volume type is unknown
+func EstimateVolumeMonthlyCost(volumeType types.VolumeType, sizeGB int32) (float64, bool) {
+	price, ok := volumeMonthlyPrices[volumeType]
+	if !ok {
+		return 0, false
+	}
+
+	return price * float64(sizeGB), true
+}
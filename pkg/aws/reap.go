@@ -0,0 +1,159 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/options"
+	"github.com/loft-sh/devpod/pkg/log"
+)
+
+// Actions the reaper takes on an instance
+const (
+	ReapStop      = "stop"
+	ReapTerminate = "terminate"
+)
+
+// ReapAction is an action the reaper takes to enforce the stop schedule or
+// ttl of an instance
+type ReapAction struct {
+	Region     string `json:"region,omitempty"`
+	Action     string `json:"action"`
+	InstanceID string `json:"instanceId"`
+	MachineID  string `json:"machineId"`
+	Reason     string `json:"reason"`
+}
+
+// FindReapActions returns the instances of a region to stop because their
+// stop schedule was due since they were started, and the instances to
+// terminate because they were stopped for longer than their ttl. Instances
+// with a devpod:keep-alive tag are skipped.
+func FindReapActions(
+	ctx context.Context,
+	svc EC2Client,
+	region string,
+	now time.Time,
+	logs log.Logger,
+) ([]ReapAction, error) {
+	actions := []ReapAction{}
+
+	err := describeDevpodInstances(ctx, svc, func(instance types.Instance) {
+		instanceID := aws.ToString(instance.InstanceId)
+		if instance.State == nil {
+			return
+		}
+
+		if IsKeptAlive(instance.Tags, now) {
+			logs.Debugf("Skipping %s, it is kept alive by the %s tag", instanceID, TagKeyKeepAlive)
+			return
+		}
+
+		action := ReapAction{
+			Region:     region,
+			InstanceID: instanceID,
+			MachineID:  getTagValue(instance.Tags, "devpod"),
+		}
+
+		switch instance.State.Name {
+		case types.InstanceStateNameRunning:
+			raw := getTagValue(instance.Tags, TagKeyStopSchedule)
+			if raw == "" {
+				return
+			}
+
+			schedule, err := options.ParseSchedule(raw)
+			if err != nil {
+				logs.Warnf("Skipping %s, invalid %s tag: %v", instanceID, TagKeyStopSchedule, err)
+				return
+			}
+
+			due := schedule.Next(aws.ToTime(instance.LaunchTime))
+			if due.IsZero() || due.After(now) {
+				return
+			}
+
+			action.Action = ReapStop
+			action.Reason = fmt.Sprintf("stop schedule %q was due at %s", schedule, due.Format(time.RFC3339))
+		case types.InstanceStateNameStopped:
+			raw := getTagValue(instance.Tags, TagKeyTTL)
+			if raw == "" {
+				return
+			}
+
+			ttl, err := options.ParseTTL(raw)
+			if err != nil {
+				logs.Warnf("Skipping %s, invalid %s tag: %v", instanceID, TagKeyTTL, err)
+				return
+			}
+
+			stoppedAt, ok := getStoppedAt(instance)
+			if !ok || now.Sub(stoppedAt) < ttl {
+				return
+			}
+
+			action.Action = ReapTerminate
+			action.Reason = fmt.Sprintf("stopped since %s, longer than its ttl of %s", stoppedAt.Format(time.RFC3339), raw)
+		default:
+			return
+		}
+
+		actions = append(actions, action)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return actions, nil
+}
+
+// ApplyReapAction stops or terminates the instance. With dryRun set the
+// request is only validated.
+func ApplyReapAction(ctx context.Context, svc EC2Client, action ReapAction, dryRun bool) error {
+	var err error
+
+	switch action.Action {
+	case ReapStop:
+		_, err = svc.StopInstances(ctx, &ec2.StopInstancesInput{
+			InstanceIds: []string{action.InstanceID},
+			DryRun:      aws.Bool(dryRun),
+		})
+	case ReapTerminate:
+		_, err = svc.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
+			InstanceIds: []string{action.InstanceID},
+			DryRun:      aws.Bool(dryRun),
+		})
+	default:
+		return fmt.Errorf("unknown action %s", action.Action)
+	}
+
+	if dryRun && IsDryRunSuccess(err) {
+		return nil
+	}
+
+	return err
+}
+
+// IsKeptAlive reports whether the devpod:keep-alive tag protects a resource
+// from the reaper and garbage collection. The tag either holds "true" or the
+// RFC3339 time until which the resource is kept.
+func IsKeptAlive(tags []types.Tag, now time.Time) bool {
+	for _, tag := range tags {
+		if aws.ToString(tag.Key) != TagKeyKeepAlive {
+			continue
+		}
+
+		value := strings.TrimSpace(aws.ToString(tag.Value))
+		if until, err := time.Parse(time.RFC3339, value); err == nil {
+			return now.Before(until)
+		}
+
+		return value != "" && !strings.EqualFold(value, "false")
+	}
+
+	return false
+}
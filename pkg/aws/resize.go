@@ -0,0 +1,220 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// instanceStateTimeout is the maximum time to wait for an instance to reach
+// a state
+var instanceStateTimeout = 10 * time.Minute
+
+// insufficientCapacityCodes are the error codes of a launch or start that
+// failed because AWS has no capacity for the instance type
+var insufficientCapacityCodes = map[string]bool{
+	"InsufficientInstanceCapacity":         true,
+	"InsufficientHostCapacity":             true,
+	"InsufficientReservedInstanceCapacity": true,
+	"InsufficientCapacity":                 true,
+}
+
+// ErrIncompatibleInstanceType is returned if an instance cannot run as the
+// requested instance type
+var ErrIncompatibleInstanceType = errors.New("incompatible instance type")
+
+// ResizeRollbackError is returned by Resize if there was no capacity for the
+// new instance type and the instance was started as its previous type again
+type ResizeRollbackError struct {
+	From string
+	To   string
+	Err  error
+}
+
+func (e *ResizeRollbackError) Error() string {
+	return fmt.Sprintf("no capacity to start as %s, rolled back to %s: %v", e.To, e.From, e.Err)
+}
+
+func (e *ResizeRollbackError) Unwrap() error {
+	return e.Err
+}
+
+// CheckInstanceTypeCompatibility verifies that the instance can run as the
+// given instance type: the architecture of its AMI has to be supported and
+// the instance needs ENA support for instance types that require it.
+// Switching to an instance type with NVMe EBS volumes only warns, as it
+// cannot be verified whether the AMI contains the NVMe driver.
+func CheckInstanceTypeCompatibility(
+	ctx context.Context,
+	svc EC2Client,
+	instance types.Instance,
+	instanceType string,
+	logs log.Logger,
+) error {
+	current := string(instance.InstanceType)
+
+	result, err := svc.DescribeInstanceTypes(ctx, &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: []types.InstanceType{types.InstanceType(current), types.InstanceType(instanceType)},
+	})
+	if err != nil {
+		return err
+	}
+
+	infos := map[string]types.InstanceTypeInfo{}
+	for _, info := range result.InstanceTypes {
+		infos[string(info.InstanceType)] = info
+	}
+
+	target, ok := infos[instanceType]
+	if !ok {
+		return fmt.Errorf("%w: %s is not available in this region", ErrIncompatibleInstanceType, instanceType)
+	}
+
+	images, err := svc.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		ImageIds: []string{aws.ToString(instance.ImageId)},
+	})
+	if err != nil || len(images.Images) == 0 {
+		logs.Warnf("Unable to find AMI %s of the instance, skipping the architecture check: %v", aws.ToString(instance.ImageId), err)
+	} else {
+		architecture := string(images.Images[0].Architecture)
+
+		supported := false
+		if target.ProcessorInfo != nil {
+			for _, supportedArchitecture := range target.ProcessorInfo.SupportedArchitectures {
+				if string(supportedArchitecture) == architecture {
+					supported = true
+				}
+			}
+		}
+
+		if !supported {
+			return fmt.Errorf(
+				"%w: %s does not support the %s architecture of AMI %s, please recreate the machine instead",
+				ErrIncompatibleInstanceType,
+				instanceType,
+				architecture,
+				aws.ToString(instance.ImageId),
+			)
+		}
+	}
+
+	if target.NetworkInfo != nil && target.NetworkInfo.EnaSupport == types.EnaSupportRequired && !aws.ToBool(instance.EnaSupport) {
+		return fmt.Errorf(
+			"%w: %s requires ENA, which is not enabled for instance %s",
+			ErrIncompatibleInstanceType,
+			instanceType,
+			aws.ToString(instance.InstanceId),
+		)
+	}
+
+	currentInfo, ok := infos[current]
+	if ok && target.EbsInfo != nil && target.EbsInfo.NvmeSupport == types.EbsNvmeSupportRequired &&
+		(currentInfo.EbsInfo == nil || currentInfo.EbsInfo.NvmeSupport == types.EbsNvmeSupportUnsupported) {
+		logs.Warnf(
+			"Instance type %s exposes EBS volumes as NVMe devices, the AMI needs the NVMe driver and device names will change",
+			instanceType,
+		)
+	}
+
+	return nil
+}
+
+// Resize changes the instance type of the instance, stopping it first if
+// necessary. With start set the instance is started afterwards and rolled
+// back to its previous instance type if there is no capacity for the new one.
+func Resize(
+	ctx context.Context,
+	svc EC2Client,
+	instance types.Instance,
+	instanceType string,
+	start bool,
+	logs log.Logger,
+) error {
+	instanceID := aws.ToString(instance.InstanceId)
+	previous := string(instance.InstanceType)
+	if previous == instanceType {
+		if start && instance.State != nil && instance.State.Name == types.InstanceStateNameStopped {
+			return Start(ctx, svc, instanceID)
+		}
+
+		return nil
+	}
+
+	err := CheckInstanceTypeCompatibility(ctx, svc, instance, instanceType, logs)
+	if err != nil {
+		return err
+	}
+
+	if instance.State != nil && instance.State.Name != types.InstanceStateNameStopped {
+		logs.Infof("Stopping instance %s to change its type", instanceID)
+
+		if instance.State.Name != types.InstanceStateNameStopping {
+			err := Stop(ctx, svc, instanceID)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := ec2.NewInstanceStoppedWaiter(svc, jitterStoppedWaiter).Wait(ctx, &ec2.DescribeInstancesInput{
+			InstanceIds: []string{instanceID},
+		}, instanceStateTimeout)
+		if err != nil {
+			return errors.Wrapf(err, "wait for instance %s to stop", instanceID)
+		}
+	}
+
+	logs.Infof("Changing instance type of %s from %s to %s", instanceID, previous, instanceType)
+	err = setInstanceType(ctx, svc, instanceID, instanceType)
+	if err != nil {
+		return err
+	}
+
+	if !start {
+		return nil
+	}
+
+	err = Start(ctx, svc, instanceID)
+	if err == nil {
+		return nil
+	}
+
+	if !IsInsufficientCapacity(err) {
+		return err
+	}
+
+	logs.Warnf("No capacity for %s, rolling back to %s", instanceType, previous)
+	rollbackErr := setInstanceType(ctx, svc, instanceID, previous)
+	if rollbackErr != nil {
+		return fmt.Errorf("%w, rolling back to %s failed: %v", err, previous, rollbackErr)
+	}
+
+	rollbackErr = Start(ctx, svc, instanceID)
+	if rollbackErr != nil {
+		return fmt.Errorf("%w, rolled back to %s but starting it failed: %v", err, previous, rollbackErr)
+	}
+
+	return &ResizeRollbackError{From: previous, To: instanceType, Err: err}
+}
+
+// IsInsufficientCapacity reports whether err is caused by AWS having no
+// capacity for an instance type
+func IsInsufficientCapacity(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && insufficientCapacityCodes[apiErr.ErrorCode()]
+}
+
+func setInstanceType(ctx context.Context, svc EC2Client, instanceID, instanceType string) error {
+	_, err := svc.ModifyInstanceAttribute(ctx, &ec2.ModifyInstanceAttributeInput{
+		InstanceId:   aws.String(instanceID),
+		InstanceType: &types.AttributeValue{Value: aws.String(instanceType)},
+	})
+
+	return err
+}
@@ -0,0 +1,141 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamTypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// CreatedResources records the shared resources created while creating a
+// machine, so an aborted create can delete them again. Resources that
+// already existed are not recorded, as other machines may use them.
+type CreatedResources struct {
+	mu sync.Mutex
+
+	SecurityGroupIDs    []string
+	RoleName            string
+	InstanceProfileName string
+}
+
+func (c *CreatedResources) addSecurityGroup(groupID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.SecurityGroupIDs = append(c.SecurityGroupIDs, groupID)
+}
+
+func (c *CreatedResources) setRole(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.RoleName = name
+}
+
+func (c *CreatedResources) setInstanceProfile(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.InstanceProfileName = name
+}
+
+// Empty returns whether no resources were created
+func (c *CreatedResources) Empty() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.SecurityGroupIDs) == 0 && c.RoleName == "" && c.InstanceProfileName == ""
+}
+
+// DeleteCreatedResources deletes the security groups, instance profile and
+// role recorded in providerAws.Created. The instances using them have to be
+// terminated already. Resources that cannot be deleted are logged and stay
+// recorded.
+func DeleteCreatedResources(ctx context.Context, providerAws *AwsProvider, logs log.Logger) error {
+	created := &providerAws.Created
+	created.mu.Lock()
+	defer created.mu.Unlock()
+
+	failed := 0
+
+	remaining := []string{}
+	for _, groupID := range created.SecurityGroupIDs {
+		logs.Infof("Deleting security group %s of the aborted create", groupID)
+
+		_, err := providerAws.EC2.DeleteSecurityGroup(ctx, &ec2.DeleteSecurityGroupInput{
+			GroupId: aws.String(groupID),
+		})
+		if err != nil && !isNotFound(err) {
+			failed++
+			remaining = append(remaining, groupID)
+			logs.Warnf("Unable to delete security group %s of the aborted create: %v", groupID, err)
+		}
+	}
+	created.SecurityGroupIDs = remaining
+
+	if created.InstanceProfileName != "" {
+		logs.Infof("Deleting instance profile %s of the aborted create", created.InstanceProfileName)
+
+		// deletes the role as well, if it was added to the profile
+		err := DeleteDevpodInstanceProfile(ctx, providerAws.IAM, created.InstanceProfileName)
+		if err != nil && !isNoSuchEntity(err) {
+			failed++
+			logs.Warnf("Unable to delete instance profile %s of the aborted create: %v", created.InstanceProfileName, err)
+		} else {
+			created.InstanceProfileName = ""
+		}
+	}
+
+	if created.RoleName != "" {
+		err := deleteRole(ctx, providerAws.IAM, created.RoleName)
+		if err != nil && !isNoSuchEntity(err) {
+			failed++
+			logs.Warnf("Unable to delete role %s of the aborted create: %v", created.RoleName, err)
+		} else {
+			created.RoleName = ""
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to delete %d resources of the aborted create", failed)
+	}
+
+	return nil
+}
+
+// deleteRole deletes the inline policies of the role and the role itself
+func deleteRole(ctx context.Context, svc IAMClient, name string) error {
+	policies, err := svc.ListRolePolicies(ctx, &iam.ListRolePoliciesInput{
+		RoleName: aws.String(name),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, policy := range policies.PolicyNames {
+		_, err := svc.DeleteRolePolicy(ctx, &iam.DeleteRolePolicyInput{
+			RoleName:   aws.String(name),
+			PolicyName: aws.String(policy),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = svc.DeleteRole(ctx, &iam.DeleteRoleInput{
+		RoleName: aws.String(name),
+	})
+
+	return err
+}
+
+func isNoSuchEntity(err error) bool {
+	var notFound *iamTypes.NoSuchEntityException
+	return errors.As(err, &notFound)
+}
@@ -0,0 +1,78 @@
+package aws
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+)
+
+func TestDeleteCreatedResources(t *testing.T) {
+	ec2Client := fake.NewDefaultEC2()
+	provider, iamClient := newTestProvider(t, ec2Client)
+
+	result, err := Create(context.Background(), provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(provider.Created.SecurityGroupIDs) != 1 || provider.Created.RoleName == "" || provider.Created.InstanceProfileName == "" {
+		t.Fatalf("expected the security group, role and instance profile to be recorded, got %+v", &provider.Created)
+	}
+
+	// in use by the instance
+	err = DeleteCreatedResources(context.Background(), provider, provider.Log)
+	checkError(t, err, "failed to delete 1 resources")
+	if len(provider.Created.SecurityGroupIDs) != 1 {
+		t.Errorf("expected the security group to stay recorded, got %v", provider.Created.SecurityGroupIDs)
+	}
+
+	err = Delete(context.Background(), ec2Client, aws.ToString(result.Instances[0].InstanceId))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = DeleteCreatedResources(context.Background(), provider, provider.Log)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ec2Client.SecurityGroups) != 0 {
+		t.Errorf("expected the security group to be deleted, got %v", ec2Client.SecurityGroups)
+	}
+	if len(iamClient.Roles) != 0 || len(iamClient.InstanceProfiles) != 0 {
+		t.Errorf("expected the role and instance profile to be deleted, got %v and %v", iamClient.Roles, iamClient.InstanceProfiles)
+	}
+	if !provider.Created.Empty() {
+		t.Errorf("expected no recorded resources, got %+v", &provider.Created)
+	}
+}
+
+func TestDeleteCreatedResourcesKeepsExisting(t *testing.T) {
+	ec2Client := fake.NewDefaultEC2()
+	ec2Client.SecurityGroups = append(ec2Client.SecurityGroups, types.SecurityGroup{
+		GroupId: aws.String("sg-existing"),
+		VpcId:   aws.String("vpc-default"),
+		Tags:    []types.Tag{tag("devpod", "devpod")},
+	})
+	provider, iamClient := newTestProvider(t, ec2Client)
+	iamClient.AddInstanceProfile("devpod-ec2-role")
+
+	_, err := Create(context.Background(), provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !provider.Created.Empty() {
+		t.Fatalf("expected no recorded resources, got %+v", &provider.Created)
+	}
+
+	err = DeleteCreatedResources(context.Background(), provider, provider.Log)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ec2Client.SecurityGroups) != 1 || len(iamClient.InstanceProfiles) != 1 {
+		t.Errorf("expected the existing resources to be kept, got %v and %v", ec2Client.SecurityGroups, iamClient.InstanceProfiles)
+	}
+}
@@ -0,0 +1,166 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/loft-sh/devpod-provider-aws/pkg/options"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// TagKeyStopAction is the tag telling self-stop how an instance stops itself,
+// it is written from AWS_STOP_ACTION when the instance is created
+const TagKeyStopAction = "devpod:stop-action"
+
+// selfStopAttempts is how often self-stop tries each request
+var selfStopAttempts = 5
+
+// selfStopRetryDelay is the delay before the first retry of self-stop, it
+// grows with every attempt
+var selfStopRetryDelay = 2 * time.Second
+
+// GetSelf returns the ID and region of the instance the provider runs on
+// from the instance metadata service
+func GetSelf(ctx context.Context, metadata IMDSClient) (string, string, error) {
+	var document imds.InstanceIdentityDocument
+	err := withRetries(ctx, func() error {
+		result, err := metadata.GetInstanceIdentityDocument(ctx, &imds.GetInstanceIdentityDocumentInput{})
+		if err != nil {
+			return err
+		}
+
+		document = result.InstanceIdentityDocument
+		return nil
+	})
+	if err != nil {
+		return "", "", errors.Wrap(err, "get instance identity document")
+	}
+
+	if document.InstanceID == "" || document.Region == "" {
+		return "", "", fmt.Errorf("instance identity document has no instance ID or region")
+	}
+
+	return document.InstanceID, document.Region, nil
+}
+
+// SelfStop stops the instance with the given ID, which is the instance the
+// provider runs on, using only the permissions of the devpod instance role.
+// Instances tagged devpod:stop-action=hibernate are hibernated, or stopped
+// if hibernation is not possible. Requests are retried, and an instance that
+// is already stopping or stopped is not an error.
+func SelfStop(ctx context.Context, svc EC2Client, instanceID string, logs log.Logger) error {
+	var instance *types.Instance
+	err := withRetries(ctx, func() error {
+		result, err := svc.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+			InstanceIds: []string{instanceID},
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, reservation := range result.Reservations {
+			for i := range reservation.Instances {
+				instance = &reservation.Instances[i]
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "describe instance %s", instanceID)
+	} else if instance == nil {
+		return fmt.Errorf("instance %s not found", instanceID)
+	}
+
+	if instance.State != nil {
+		switch instance.State.Name {
+		case types.InstanceStateNameStopping, types.InstanceStateNameStopped:
+			logs.Infof("Instance %s is already %s", instanceID, instance.State.Name)
+			return nil
+		case types.InstanceStateNameShuttingDown, types.InstanceStateNameTerminated:
+			return fmt.Errorf("instance %s is %s", instanceID, instance.State.Name)
+		}
+	}
+
+	hibernate := getTagValue(instance.Tags, TagKeyStopAction) == options.StopActionHibernate
+	if hibernate {
+		logs.Infof("Hibernating instance %s", instanceID)
+
+		err := withRetries(ctx, func() error {
+			return stopInstance(ctx, svc, instanceID, true)
+		})
+		if err == nil {
+			return nil
+		}
+
+		logs.Warnf("Unable to hibernate instance %s, stopping it instead: %v", instanceID, err)
+	}
+
+	logs.Infof("Stopping instance %s", instanceID)
+
+	err = withRetries(ctx, func() error {
+		return stopInstance(ctx, svc, instanceID, false)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "stop instance %s", instanceID)
+	}
+
+	return nil
+}
+
+func stopInstance(ctx context.Context, svc EC2Client, instanceID string, hibernate bool) error {
+	input := &ec2.StopInstancesInput{
+		InstanceIds: []string{instanceID},
+	}
+	if hibernate {
+		input.Hibernate = aws.Bool(true)
+	}
+
+	_, err := svc.StopInstances(ctx, input)
+
+	return err
+}
+
+// withRetries calls fn until it succeeds, it fails with an error that will
+// not go away or selfStopAttempts are used up
+func withRetries(ctx context.Context, fn func() error) error {
+	delay := selfStopRetryDelay
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= selfStopAttempts || isPermanent(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(jitter(delay)):
+		}
+		delay *= 2
+	}
+}
+
+// isPermanent reports whether err is an API error retrying will not fix,
+// e.g. missing permissions or an unsupported hibernation configuration
+func isPermanent(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	switch apiErr.ErrorCode() {
+	case "UnauthorizedOperation", "AccessDenied", "AuthFailure", "UnsupportedHibernationConfiguration", "UnsupportedOperation":
+		return true
+	}
+
+	return isNotFound(err)
+}
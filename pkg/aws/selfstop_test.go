@@ -0,0 +1,108 @@
+package aws
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod-provider-aws/pkg/aws/fake"
+	"github.com/loft-sh/devpod/pkg/log"
+	"github.com/sirupsen/logrus"
+)
+
+// flakyEC2 fails the first StopInstances calls with the given errors
+type flakyEC2 struct {
+	*fake.EC2
+
+	stopErrors []error
+	stopInputs []*ec2.StopInstancesInput
+}
+
+func (f *flakyEC2) StopInstances(ctx context.Context, params *ec2.StopInstancesInput, optFns ...func(*ec2.Options)) (*ec2.StopInstancesOutput, error) {
+	f.stopInputs = append(f.stopInputs, params)
+	if len(f.stopErrors) > 0 {
+		err := f.stopErrors[0]
+		f.stopErrors = f.stopErrors[1:]
+		return nil, err
+	}
+
+	return f.EC2.StopInstances(ctx, params, optFns...)
+}
+
+func TestSelfStop(t *testing.T) {
+	defer func(delay time.Duration) { selfStopRetryDelay = delay }(selfStopRetryDelay)
+	selfStopRetryDelay = time.Millisecond
+
+	hibernating := fake.Instance("i-1", "devpod-test", time.Now())
+	hibernating.Tags = append(hibernating.Tags, tag(TagKeyStopAction, "hibernate"))
+	hibernating.HibernationOptions = &types.HibernationOptions{Configured: aws.Bool(true)}
+
+	tests := []struct {
+		name       string
+		instance   types.Instance
+		stopErrors []error
+		hibernate  []bool
+		err        string
+	}{
+		{
+			name:      "stop",
+			instance:  fake.Instance("i-1", "devpod-test", time.Now()),
+			hibernate: []bool{false},
+		},
+		{
+			name:     "transient errors are retried",
+			instance: fake.Instance("i-1", "devpod-test", time.Now()),
+			stopErrors: []error{
+				fake.APIError("RequestLimitExceeded", "Request limit exceeded."),
+				fake.APIError("IncorrectInstanceState", "The instance is pending."),
+			},
+			hibernate: []bool{false, false, false},
+		},
+		{
+			name:       "missing permissions are not retried",
+			instance:   fake.Instance("i-1", "devpod-test", time.Now()),
+			stopErrors: []error{fake.APIError("UnauthorizedOperation", "You are not authorized to perform this operation.")},
+			hibernate:  []bool{false},
+			err:        "UnauthorizedOperation",
+		},
+		{
+			name:      "hibernate",
+			instance:  hibernating,
+			hibernate: []bool{true},
+		},
+		{
+			name:       "hibernate falls back to stop",
+			instance:   hibernating,
+			stopErrors: []error{fake.APIError("UnsupportedHibernationConfiguration", "Hibernation is not supported.")},
+			hibernate:  []bool{true, false},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec2Client := fake.NewDefaultEC2()
+			ec2Client.Instances = []types.Instance{test.instance}
+			svc := &flakyEC2{EC2: ec2Client, stopErrors: test.stopErrors}
+
+			err := SelfStop(context.Background(), svc, "i-1", log.NewStreamLogger(io.Discard, io.Discard, logrus.DebugLevel))
+			checkError(t, err, test.err)
+
+			hibernate := []bool{}
+			for _, input := range svc.stopInputs {
+				hibernate = append(hibernate, aws.ToBool(input.Hibernate))
+			}
+			if len(hibernate) != len(test.hibernate) {
+				t.Fatalf("expected stop requests %v, got %v", test.hibernate, hibernate)
+			}
+			for i := range hibernate {
+				if hibernate[i] != test.hibernate[i] {
+					t.Errorf("expected stop requests %v, got %v", test.hibernate, hibernate)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package aws
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/loft-sh/devpod/pkg/client"
+)
+
+// GetClientStatus maps the state of an instance to the status devpod expects
+func GetClientStatus(state types.InstanceStateName) client.Status {
+	switch state {
+	case types.InstanceStateNameRunning:
+		return client.StatusRunning
+	case types.InstanceStateNameStopped:
+		return client.StatusStopped
+	case types.InstanceStateNameTerminated:
+		return client.StatusNotFound
+	default:
+		return client.StatusBusy
+	}
+}
+
+// GetInstanceStatusChecks returns the system and instance status checks and
+// the scheduled events of the instance, or nil if EC2 reports none
+func GetInstanceStatusChecks(ctx context.Context, svc EC2Client, instanceID string) (*types.InstanceStatus, error) {
+	result, err := svc.DescribeInstanceStatus(ctx, &ec2.DescribeInstanceStatusInput{
+		InstanceIds:         []string{instanceID},
+		IncludeAllInstances: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.InstanceStatuses) == 0 {
+		return nil, nil
+	}
+
+	return &result.InstanceStatuses[0], nil
+}
+
+// GetSpotInstanceRequest returns the spot request of a spot instance, its
+// status tells whether the instance is marked for interruption
+func GetSpotInstanceRequest(ctx context.Context, svc EC2Client, requestID string) (*types.SpotInstanceRequest, error) {
+	result, err := svc.DescribeSpotInstanceRequests(ctx, &ec2.DescribeSpotInstanceRequestsInput{
+		SpotInstanceRequestIds: []string{requestID},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.SpotInstanceRequests) == 0 {
+		return nil, nil
+	}
+
+	return &result.SpotInstanceRequests[0], nil
+}
+
+// GetInstanceVolumes returns the volumes attached to the instance
+func GetInstanceVolumes(ctx context.Context, svc EC2Client, instanceID string) ([]types.Volume, error) {
+	input := &ec2.DescribeVolumesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("attachment.instance-id"),
+				Values: []string{instanceID},
+			},
+		},
+	}
+
+	volumes := []types.Volume{}
+	for {
+		result, err := svc.DescribeVolumes(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+
+		volumes = append(volumes, result.Volumes...)
+
+		if aws.ToString(result.NextToken) == "" {
+			return volumes, nil
+		}
+		input.NextToken = result.NextToken
+	}
+}
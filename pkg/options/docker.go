@@ -0,0 +1,36 @@
+package options
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ParseRegistryMirrors parses a comma separated list of registry mirror URLs,
+// which have to be http or https URLs without a path
+func ParseRegistryMirrors(raw string) ([]string, error) {
+	mirrors := []string{}
+	for _, mirror := range strings.Split(raw, ",") {
+		mirror = strings.TrimSpace(mirror)
+		if mirror == "" {
+			continue
+		}
+
+		parsed, err := url.Parse(mirror)
+		if err != nil {
+			return nil, err
+		}
+
+		if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("%s is not an http or https URL", mirror)
+		}
+
+		if strings.Trim(parsed.Path, "/") != "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+			return nil, fmt.Errorf("%s must not have a path", mirror)
+		}
+
+		mirrors = append(mirrors, parsed.Scheme+"://"+parsed.Host)
+	}
+
+	return mirrors, nil
+}
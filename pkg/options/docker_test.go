@@ -0,0 +1,41 @@
+package options
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRegistryMirrors(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected []string
+		err      bool
+	}{
+		{raw: "", expected: []string{}},
+		{raw: "https://mirror.gcr.io", expected: []string{"https://mirror.gcr.io"}},
+		{
+			raw:      " https://mirror.example.com/ , http://10.0.0.1:5000",
+			expected: []string{"https://mirror.example.com", "http://10.0.0.1:5000"},
+		},
+		{raw: "mirror.example.com", err: true},
+		{raw: "ftp://mirror.example.com", err: true},
+		{raw: "https://mirror.example.com/v2", err: true},
+	}
+
+	for _, test := range tests {
+		mirrors, err := ParseRegistryMirrors(test.raw)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.raw)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.raw, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(mirrors, test.expected) {
+			t.Errorf("%q: expected %v, got %v", test.raw, test.expected, mirrors)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package options
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Values of AWS_IMDS_TOKENS
+const (
+	IMDSTokensRequired = "required"
+	IMDSTokensOptional = "optional"
+)
+
+// maxIMDSHopLimit is the highest hop limit EC2 accepts for the responses of
+// the instance metadata service
+const maxIMDSHopLimit = 64
+
+// ParseIMDSHopLimit parses the hop limit of the instance metadata service.
+// It defaults to 1, so containers on the instance cannot reach the service.
+func ParseIMDSHopLimit(raw string) (int32, error) {
+	if raw == "" {
+		return 1, nil
+	}
+
+	hopLimit, err := strconv.Atoi(raw)
+	if err != nil || hopLimit < 1 || hopLimit > maxIMDSHopLimit {
+		return 0, fmt.Errorf("%s is not a number between 1 and %d", raw, maxIMDSHopLimit)
+	}
+
+	return int32(hopLimit), nil
+}
+
+// ParseIMDSTokens parses whether the instance metadata service requires
+// session tokens, i.e. IMDSv2. It defaults to required.
+func ParseIMDSTokens(raw string) (string, error) {
+	switch raw {
+	case "":
+		return IMDSTokensRequired, nil
+	case IMDSTokensRequired, IMDSTokensOptional:
+		return raw, nil
+	default:
+		return "", fmt.Errorf("%s is neither %s nor %s", raw, IMDSTokensRequired, IMDSTokensOptional)
+	}
+}
@@ -0,0 +1,53 @@
+package options
+
+import "testing"
+
+func TestParseIMDSHopLimit(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected int32
+		err      bool
+	}{
+		{raw: "", expected: 1},
+		{raw: "3", expected: 3},
+		{raw: "64", expected: 64},
+		{raw: "0", err: true},
+		{raw: "65", err: true},
+		{raw: "two", err: true},
+	}
+
+	for _, test := range tests {
+		hopLimit, err := ParseIMDSHopLimit(test.raw)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.raw)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.raw, err)
+			continue
+		}
+
+		if hopLimit != test.expected {
+			t.Errorf("%q: expected %d, got %d", test.raw, test.expected, hopLimit)
+		}
+	}
+}
+
+func TestParseIMDSTokens(t *testing.T) {
+	for raw, expected := range map[string]string{
+		"":         IMDSTokensRequired,
+		"required": IMDSTokensRequired,
+		"optional": IMDSTokensOptional,
+	} {
+		tokens, err := ParseIMDSTokens(raw)
+		if err != nil || tokens != expected {
+			t.Errorf("%q: expected %q, got %q, %v", raw, expected, tokens, err)
+		}
+	}
+
+	_, err := ParseIMDSTokens("v1")
+	if err == nil {
+		t.Errorf("expected an error for v1")
+	}
+}
@@ -3,7 +3,9 @@ package options
 import (
 	"fmt"
 	"os"
+	"path"
 	"strconv"
+	"time"
 )
 
 var (
@@ -16,9 +18,35 @@ var (
 	AWS_SUBNET_ID                     = "AWS_SUBNET_ID"
 	AWS_VPC_ID                        = "AWS_VPC_ID"
 	AWS_INSTANCE_TAGS                 = "AWS_INSTANCE_TAGS"
+	AWS_DEFAULT_TAGS                  = "AWS_DEFAULT_TAGS"
 	AWS_INSTANCE_PROFILE_ARN          = "AWS_INSTANCE_PROFILE_ARN"
 	AWS_USE_INSTANCE_CONNECT_ENDPOINT = "AWS_USE_INSTANCE_CONNECT_ENDPOINT"
 	AWS_INSTANCE_CONNECT_ENDPOINT_ID  = "AWS_INSTANCE_CONNECT_ENDPOINT_ID"
+	AWS_ENDPOINT_URL                  = "AWS_ENDPOINT_URL"
+	AWS_ENDPOINT_URL_EC2              = "AWS_ENDPOINT_URL_EC2"
+	AWS_ENDPOINT_URL_IAM              = "AWS_ENDPOINT_URL_IAM"
+	AWS_ENDPOINT_URL_STS              = "AWS_ENDPOINT_URL_STS"
+	AWS_STOP_SCHEDULE                 = "AWS_STOP_SCHEDULE"
+	AWS_TTL                           = "AWS_TTL"
+	AWS_TERMINATE_DUPLICATES          = "AWS_TERMINATE_DUPLICATES"
+	AWS_KEEP_VOLUMES                  = "AWS_KEEP_VOLUMES"
+	AWS_USER_DATA_EXTRA               = "AWS_USER_DATA_EXTRA"
+	AWS_USER_DATA_FILE                = "AWS_USER_DATA_FILE"
+	AWS_DOCKER_BOOTSTRAP              = "AWS_DOCKER_BOOTSTRAP"
+	AWS_DOCKER_REGISTRY_MIRRORS       = "AWS_DOCKER_REGISTRY_MIRRORS"
+	AWS_DOCKER_DATA_ROOT              = "AWS_DOCKER_DATA_ROOT"
+	AWS_INSTANCE_STORE                = "AWS_INSTANCE_STORE"
+	AWS_INSTANCE_STORE_DOCKER         = "AWS_INSTANCE_STORE_DOCKER"
+	AWS_INSTANCE_STORE_TMP            = "AWS_INSTANCE_STORE_TMP"
+	AWS_IMDS_HOP_LIMIT                = "AWS_IMDS_HOP_LIMIT"
+	AWS_IMDS_TOKENS                   = "AWS_IMDS_TOKENS"
+	AWS_IMDS_TAGS                     = "AWS_IMDS_TAGS"
+	AWS_FORWARD_CREDENTIALS           = "AWS_FORWARD_CREDENTIALS"
+	AWS_STOP_ACTION                   = "AWS_STOP_ACTION"
+	AWS_PROVIDER_TIMEOUT              = "AWS_PROVIDER_TIMEOUT"
+	AWS_RETRY_MODE                    = "AWS_RETRY_MODE"
+	AWS_RETRY_MAX_ATTEMPTS            = "AWS_RETRY_MAX_ATTEMPTS"
+	AWS_RETRY_MAX_BACKOFF             = "AWS_RETRY_MAX_BACKOFF"
 )
 
 type Options struct {
@@ -27,15 +55,36 @@ type Options struct {
 	RootDevice                 string
 	MachineFolder              string
 	MachineID                  string
+	MachineContext             string
+	WorkspaceID                string
 	MachineType                string
 	VpcID                      string
 	SubnetID                   string
 	SecurityGroupID            string
 	InstanceProfileArn         string
-	InstanceTags               string
+	InstanceTags               []Tag
+	DefaultTags                []Tag
 	Zone                       string
 	UseInstanceConnectEndpoint bool
 	InstanceConnectEndpointID  string
+	StopSchedule               *Schedule
+	TTL                        time.Duration
+	TerminateDuplicates        bool
+	KeepVolumes                bool
+	UserDataExtra              string
+	UserDataFile               string
+	DockerBootstrap            bool
+	DockerRegistryMirrors      []string
+	DockerDataRoot             string
+	InstanceStore              bool
+	InstanceStoreDocker        bool
+	InstanceStoreTmp           bool
+	IMDSHopLimit               int32
+	IMDSTokens                 string
+	IMDSTags                   bool
+	ForwardCredentials         bool
+	StopAction                 string
+	Retry                      Retry
 }
 
 func FromEnv(init bool) (*Options, error) {
@@ -63,11 +112,78 @@ func FromEnv(init bool) (*Options, error) {
 	retOptions.SecurityGroupID = os.Getenv(AWS_SECURITY_GROUP_ID)
 	retOptions.SubnetID = os.Getenv(AWS_SUBNET_ID)
 	retOptions.VpcID = os.Getenv(AWS_VPC_ID)
-	retOptions.InstanceTags = os.Getenv(AWS_INSTANCE_TAGS)
+	retOptions.InstanceTags, err = ParseTags(os.Getenv(AWS_INSTANCE_TAGS))
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", AWS_INSTANCE_TAGS, err)
+	}
+	retOptions.DefaultTags, err = ParseTags(os.Getenv(AWS_DEFAULT_TAGS))
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", AWS_DEFAULT_TAGS, err)
+	}
 	retOptions.InstanceProfileArn = os.Getenv(AWS_INSTANCE_PROFILE_ARN)
 	retOptions.Zone = os.Getenv(AWS_REGION)
 	retOptions.UseInstanceConnectEndpoint = os.Getenv(AWS_USE_INSTANCE_CONNECT_ENDPOINT) == "true"
 	retOptions.InstanceConnectEndpointID = os.Getenv(AWS_INSTANCE_CONNECT_ENDPOINT_ID)
+	retOptions.TerminateDuplicates = os.Getenv(AWS_TERMINATE_DUPLICATES) == "true"
+	retOptions.KeepVolumes = os.Getenv(AWS_KEEP_VOLUMES) == "true"
+	retOptions.UserDataExtra = os.Getenv(AWS_USER_DATA_EXTRA)
+	retOptions.UserDataFile = os.Getenv(AWS_USER_DATA_FILE)
+	retOptions.DockerBootstrap = os.Getenv(AWS_DOCKER_BOOTSTRAP) == "true"
+	retOptions.DockerRegistryMirrors, err = ParseRegistryMirrors(os.Getenv(AWS_DOCKER_REGISTRY_MIRRORS))
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", AWS_DOCKER_REGISTRY_MIRRORS, err)
+	}
+	retOptions.DockerDataRoot = os.Getenv(AWS_DOCKER_DATA_ROOT)
+	if retOptions.DockerDataRoot != "" && !path.IsAbs(retOptions.DockerDataRoot) {
+		return nil, fmt.Errorf("%s has to be an absolute path", AWS_DOCKER_DATA_ROOT)
+	}
+	retOptions.InstanceStoreDocker = os.Getenv(AWS_INSTANCE_STORE_DOCKER) == "true"
+	retOptions.InstanceStoreTmp = os.Getenv(AWS_INSTANCE_STORE_TMP) == "true"
+	retOptions.InstanceStore = os.Getenv(AWS_INSTANCE_STORE) == "true" || retOptions.InstanceStoreDocker || retOptions.InstanceStoreTmp
+	if retOptions.InstanceStoreDocker {
+		if !retOptions.DockerBootstrap {
+			return nil, fmt.Errorf("%s requires %s", AWS_INSTANCE_STORE_DOCKER, AWS_DOCKER_BOOTSTRAP)
+		} else if retOptions.DockerDataRoot != "" {
+			return nil, fmt.Errorf("%s and %s cannot be used together", AWS_INSTANCE_STORE_DOCKER, AWS_DOCKER_DATA_ROOT)
+		}
+	}
+	retOptions.IMDSHopLimit, err = ParseIMDSHopLimit(os.Getenv(AWS_IMDS_HOP_LIMIT))
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", AWS_IMDS_HOP_LIMIT, err)
+	}
+	retOptions.IMDSTokens, err = ParseIMDSTokens(os.Getenv(AWS_IMDS_TOKENS))
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", AWS_IMDS_TOKENS, err)
+	}
+	retOptions.IMDSTags = os.Getenv(AWS_IMDS_TAGS) == "true"
+	retOptions.ForwardCredentials = os.Getenv(AWS_FORWARD_CREDENTIALS) == "true"
+	err = retOptions.ValidateTags()
+	if err != nil {
+		return nil, err
+	}
+	retOptions.StopAction, err = ParseStopAction(os.Getenv(AWS_STOP_ACTION))
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", AWS_STOP_ACTION, err)
+	}
+
+	retOptions.Retry, err = RetryFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	if stopSchedule := os.Getenv(AWS_STOP_SCHEDULE); stopSchedule != "" {
+		retOptions.StopSchedule, err = ParseSchedule(stopSchedule)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", AWS_STOP_SCHEDULE, err)
+		}
+	}
+
+	if ttl := os.Getenv(AWS_TTL); ttl != "" {
+		retOptions.TTL, err = ParseTTL(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", AWS_TTL, err)
+		}
+	}
 
 	// Return eraly if we're just doing init
 	if init {
@@ -86,9 +202,31 @@ func FromEnv(init bool) (*Options, error) {
 		return nil, err
 	}
 
+	retOptions.MachineContext = os.Getenv("MACHINE_CONTEXT")
+	retOptions.WorkspaceID = os.Getenv("WORKSPACE_ID")
+
 	return retOptions, nil
 }
 
+// Values of AWS_STOP_ACTION
+const (
+	StopActionStop      = "stop"
+	StopActionHibernate = "hibernate"
+)
+
+// ParseStopAction parses how the agent stops an idle instance. It defaults
+// to stop.
+func ParseStopAction(raw string) (string, error) {
+	switch raw {
+	case "":
+		return StopActionStop, nil
+	case StopActionStop, StopActionHibernate:
+		return raw, nil
+	default:
+		return "", fmt.Errorf("%s is neither %s nor %s", raw, StopActionStop, StopActionHibernate)
+	}
+}
+
 func fromEnvOrError(name string) (string, error) {
 	val := os.Getenv(name)
 	if val == "" {
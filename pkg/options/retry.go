@@ -0,0 +1,92 @@
+package options
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Values of AWS_RETRY_MODE
+const (
+	RetryModeStandard = "standard"
+	RetryModeAdaptive = "adaptive"
+)
+
+// Retry configures how the AWS clients retry requests. An empty mode is
+// adaptive, zero limits keep the defaults of the SDK.
+type Retry struct {
+	Mode        string
+	MaxAttempts int
+	MaxBackoff  time.Duration
+}
+
+// RetryFromEnv parses AWS_RETRY_MODE, AWS_RETRY_MAX_ATTEMPTS and
+// AWS_RETRY_MAX_BACKOFF, e.g. for the commands that need no machine options
+func RetryFromEnv() (Retry, error) {
+	var (
+		retry Retry
+		err   error
+	)
+
+	retry.Mode, err = ParseRetryMode(os.Getenv(AWS_RETRY_MODE))
+	if err != nil {
+		return Retry{}, fmt.Errorf("parse %s: %w", AWS_RETRY_MODE, err)
+	}
+
+	retry.MaxAttempts, err = ParseRetryMaxAttempts(os.Getenv(AWS_RETRY_MAX_ATTEMPTS))
+	if err != nil {
+		return Retry{}, fmt.Errorf("parse %s: %w", AWS_RETRY_MAX_ATTEMPTS, err)
+	}
+
+	retry.MaxBackoff, err = ParseRetryMaxBackoff(os.Getenv(AWS_RETRY_MAX_BACKOFF))
+	if err != nil {
+		return Retry{}, fmt.Errorf("parse %s: %w", AWS_RETRY_MAX_BACKOFF, err)
+	}
+
+	return retry, nil
+}
+
+// ParseRetryMode parses the retry mode of the AWS clients. It defaults to
+// adaptive, which also limits the request rate once requests are throttled,
+// standard only retries them.
+func ParseRetryMode(raw string) (string, error) {
+	switch raw {
+	case "":
+		return RetryModeAdaptive, nil
+	case RetryModeStandard, RetryModeAdaptive:
+		return raw, nil
+	}
+
+	return "", fmt.Errorf("invalid retry mode %q, expected %s or %s", raw, RetryModeStandard, RetryModeAdaptive)
+}
+
+// ParseRetryMaxAttempts parses how often a request is attempted, 1 disables
+// retries. It returns 0 for the default of the SDK.
+func ParseRetryMaxAttempts(raw string) (int, error) {
+	if raw == "" {
+		return 0, nil
+	}
+
+	maxAttempts, err := strconv.Atoi(raw)
+	if err != nil || maxAttempts < 1 {
+		return 0, fmt.Errorf("invalid max attempts %q, expected a number of at least 1", raw)
+	}
+
+	return maxAttempts, nil
+}
+
+// ParseRetryMaxBackoff parses the maximum delay between the attempts of a
+// request, e.g. "20s". It returns 0 for the default of the SDK.
+func ParseRetryMaxBackoff(raw string) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+
+	maxBackoff, err := time.ParseDuration(raw)
+	if err != nil || maxBackoff <= 0 {
+		return 0, fmt.Errorf("invalid max backoff %q, expected e.g. 20s", raw)
+	}
+
+	return maxBackoff, nil
+}
@@ -0,0 +1,129 @@
+package options
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRetryMode(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected string
+		err      bool
+	}{
+		{raw: "", expected: RetryModeAdaptive},
+		{raw: "standard", expected: RetryModeStandard},
+		{raw: "adaptive", expected: RetryModeAdaptive},
+		{raw: "legacy", err: true},
+	}
+
+	for _, test := range tests {
+		mode, err := ParseRetryMode(test.raw)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.raw)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.raw, err)
+			continue
+		}
+
+		if mode != test.expected {
+			t.Errorf("%q: expected %s, got %s", test.raw, test.expected, mode)
+		}
+	}
+}
+
+func TestParseRetryMaxAttempts(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected int
+		err      bool
+	}{
+		{raw: "", expected: 0},
+		{raw: "1", expected: 1},
+		{raw: "25", expected: 25},
+		{raw: "0", err: true},
+		{raw: "ten", err: true},
+	}
+
+	for _, test := range tests {
+		maxAttempts, err := ParseRetryMaxAttempts(test.raw)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.raw)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.raw, err)
+			continue
+		}
+
+		if maxAttempts != test.expected {
+			t.Errorf("%q: expected %d, got %d", test.raw, test.expected, maxAttempts)
+		}
+	}
+}
+
+func TestParseRetryMaxBackoff(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected time.Duration
+		err      bool
+	}{
+		{raw: "", expected: 0},
+		{raw: "1m", expected: time.Minute},
+		{raw: "0", err: true},
+		{raw: "30", err: true},
+	}
+
+	for _, test := range tests {
+		maxBackoff, err := ParseRetryMaxBackoff(test.raw)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.raw)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.raw, err)
+			continue
+		}
+
+		if maxBackoff != test.expected {
+			t.Errorf("%q: expected %s, got %s", test.raw, test.expected, maxBackoff)
+		}
+	}
+}
+
+func TestFromEnvRetry(t *testing.T) {
+	t.Setenv(AWS_INSTANCE_TYPE, "c5.xlarge")
+	t.Setenv(AWS_DISK_SIZE, "40")
+
+	options, err := FromEnv(true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := Retry{Mode: RetryModeAdaptive}
+	if options.Retry != expected {
+		t.Errorf("expected %+v by default, got %+v", expected, options.Retry)
+	}
+
+	t.Setenv(AWS_RETRY_MODE, RetryModeStandard)
+	t.Setenv(AWS_RETRY_MAX_ATTEMPTS, "10")
+	options, err = FromEnv(true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected = Retry{Mode: RetryModeStandard, MaxAttempts: 10}
+	if options.Retry != expected {
+		t.Errorf("expected %+v, got %+v", expected, options.Retry)
+	}
+
+	t.Setenv(AWS_RETRY_MAX_BACKOFF, "soon")
+	_, err = FromEnv(true)
+	if err == nil || !strings.Contains(err.Error(), "parse AWS_RETRY_MAX_BACKOFF") {
+		t.Errorf("expected a parse error, got %v", err)
+	}
+}
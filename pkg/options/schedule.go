@@ -0,0 +1,242 @@
+package options
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a cron expression with the fields minute, hour, day of month,
+// month and day of week, evaluated in a time zone
+type Schedule struct {
+	raw      string
+	location *time.Location
+
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	// day of month and day of week match if either matches when both are
+	// restricted, like in cron
+	domStar bool
+	dowStar bool
+}
+
+type scheduleField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var scheduleFields = []scheduleField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{
+		name: "month",
+		min:  1,
+		max:  12,
+		names: map[string]int{
+			"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+			"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+		},
+	},
+	{
+		name: "day of week",
+		min:  0,
+		max:  7,
+		names: map[string]int{
+			"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+		},
+	},
+}
+
+// ParseSchedule parses a cron expression like "0 20 * * 1-5", optionally
+// prefixed with a time zone like "TZ=Europe/Berlin 0 20 * * mon-fri". The
+// time zone defaults to UTC.
+func ParseSchedule(raw string) (*Schedule, error) {
+	fields := strings.Fields(raw)
+	schedule := &Schedule{
+		raw:      strings.Join(fields, " "),
+		location: time.UTC,
+	}
+
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "TZ=") || strings.HasPrefix(fields[0], "CRON_TZ=")) {
+		_, zone, _ := strings.Cut(fields[0], "=")
+
+		location, err := time.LoadLocation(zone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %w", zone, err)
+		}
+
+		schedule.location = location
+		fields = fields[1:]
+	}
+
+	if len(fields) != len(scheduleFields) {
+		return nil, fmt.Errorf("invalid schedule %q, expected [TZ=<zone>] <minute> <hour> <day of month> <month> <day of week>", raw)
+	}
+
+	bits := []*uint64{&schedule.minute, &schedule.hour, &schedule.dom, &schedule.month, &schedule.dow}
+	for i, field := range scheduleFields {
+		value, err := field.parse(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", raw, err)
+		}
+
+		*bits[i] = value
+	}
+
+	// 7 is sunday as well
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow |= 1
+	}
+
+	schedule.domStar = fields[2] == "*"
+	schedule.dowStar = fields[4] == "*"
+
+	return schedule, nil
+}
+
+// String returns the schedule in the format accepted by ParseSchedule
+func (s *Schedule) String() string {
+	return s.raw
+}
+
+// Next returns the first time after the given time the schedule matches,
+// the zero time if it never does, e.g. for the 31st of february
+func (s *Schedule) Next(after time.Time) time.Time {
+	t := after.In(s.location)
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, s.location)
+
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !has(s.month, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.location)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.location)
+		case !has(s.hour, t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.location)
+		case !has(s.minute, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := has(s.dom, t.Day())
+	dow := has(s.dow, int(t.Weekday()))
+
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+
+	return dom || dow
+}
+
+func has(bits uint64, value int) bool {
+	return bits&(1<<uint(value)) != 0
+}
+
+// parse parses a comma separated list of values, ranges and steps like
+// "*/15", "1-5" or "mon,wed,fri"
+func (f scheduleField) parse(raw string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(raw, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q in %s", stepPart, f.name)
+			}
+		}
+
+		start, end := f.min, f.max
+		if rangePart != "*" {
+			from, to, isRange := strings.Cut(rangePart, "-")
+
+			var err error
+			start, err = f.value(from)
+			if err != nil {
+				return 0, err
+			}
+
+			end = start
+			if isRange {
+				end, err = f.value(to)
+				if err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				end = f.max
+			}
+
+			if end < start {
+				return 0, fmt.Errorf("invalid range %q in %s", rangePart, f.name)
+			}
+		}
+
+		for value := start; value <= end; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+
+	return bits, nil
+}
+
+func (f scheduleField) value(raw string) (int, error) {
+	if value, ok := f.names[strings.ToLower(raw)]; ok {
+		return value, nil
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < f.min || value > f.max {
+		return 0, fmt.Errorf("invalid %s %q, expected %d-%d", f.name, raw, f.min, f.max)
+	}
+
+	return value, nil
+}
+
+// ParseTTL parses a duration like "72h" or "7d"
+func ParseTTL(raw string) (time.Duration, error) {
+	if strings.HasSuffix(raw, "d") {
+		value, err := strconv.Atoi(strings.TrimSuffix(raw, "d"))
+		if err != nil || value < 1 {
+			return 0, fmt.Errorf("invalid ttl %q, expected e.g. 7d or 72h", raw)
+		}
+
+		return time.Duration(value) * 24 * time.Hour, nil
+	}
+
+	ttl, err := time.ParseDuration(raw)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("invalid ttl %q, expected e.g. 7d or 72h", raw)
+	}
+
+	return ttl, nil
+}
+
+// FormatTTL formats a duration as accepted by ParseTTL, in days or hours
+// if possible
+func FormatTTL(ttl time.Duration) string {
+	if ttl%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", ttl/(24*time.Hour))
+	}
+
+	if ttl%time.Hour == 0 {
+		return fmt.Sprintf("%dh", ttl/time.Hour)
+	}
+
+	return ttl.String()
+}
@@ -0,0 +1,126 @@
+package options
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+
+	tests := []struct {
+		schedule string
+		after    time.Time
+		expected time.Time
+		err      string
+	}{
+		{
+			schedule: "0 20 * * *",
+			after:    time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC),
+			expected: time.Date(2023, 6, 1, 20, 0, 0, 0, time.UTC),
+		},
+		{
+			schedule: "0 20 * * *",
+			after:    time.Date(2023, 6, 1, 20, 0, 0, 0, time.UTC),
+			expected: time.Date(2023, 6, 2, 20, 0, 0, 0, time.UTC),
+		},
+		{
+			// friday evening to monday evening
+			schedule: "30 19 * * mon-fri",
+			after:    time.Date(2023, 6, 2, 20, 0, 0, 0, time.UTC),
+			expected: time.Date(2023, 6, 5, 19, 30, 0, 0, time.UTC),
+		},
+		{
+			schedule: "TZ=Europe/Berlin 0 20 * * 1-5",
+			after:    time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC),
+			expected: time.Date(2023, 6, 1, 20, 0, 0, 0, berlin),
+		},
+		{
+			schedule: "*/15 * * * *",
+			after:    time.Date(2023, 6, 1, 12, 7, 30, 0, time.UTC),
+			expected: time.Date(2023, 6, 1, 12, 15, 0, 0, time.UTC),
+		},
+		{
+			// either day of month or day of week matches
+			schedule: "0 0 1 * sun",
+			after:    time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC),
+			expected: time.Date(2023, 6, 4, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			schedule: "0 0 31 2 *",
+			after:    time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			schedule: "0 20 * *",
+			err:      "expected [TZ=<zone>]",
+		},
+		{
+			schedule: "0 24 * * *",
+			err:      "invalid hour",
+		},
+		{
+			schedule: "0 20 * * 5-1",
+			err:      "invalid range",
+		},
+		{
+			schedule: "TZ=Nowhere/City 0 20 * * *",
+			err:      "invalid time zone",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.schedule, func(t *testing.T) {
+			schedule, err := ParseSchedule(test.schedule)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			next := schedule.Next(test.after)
+			if !next.Equal(test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, next)
+			}
+		})
+	}
+}
+
+func TestParseTTL(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected time.Duration
+		err      bool
+	}{
+		{raw: "7d", expected: 7 * 24 * time.Hour},
+		{raw: "36h", expected: 36 * time.Hour},
+		{raw: "0d", err: true},
+		{raw: "-1h", err: true},
+		{raw: "week", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.raw, func(t *testing.T) {
+			ttl, err := ParseTTL(test.raw)
+			if (err != nil) != test.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if ttl != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, ttl)
+			}
+
+			if !test.err && FormatTTL(ttl) != test.raw {
+				t.Errorf("expected %q to round trip, got %q", test.raw, FormatTTL(ttl))
+			}
+		})
+	}
+}
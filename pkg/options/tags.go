@@ -0,0 +1,263 @@
+package options
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxTagKeyLength and maxTagValueLength are the AWS limits in characters
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+
+	// maxUserTags leaves room for the tags the provider adds itself within
+	// the AWS limit of 50 tags per resource
+	maxUserTags = 40
+)
+
+var legacyTagRegex = regexp.MustCompile(`^Name=([^,]*),Value=(.*)$`)
+
+// iamTagRegex matches the characters IAM allows in tag keys and values,
+// which are stricter than those of EC2
+var iamTagRegex = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]*$`)
+
+// Tag is a key/value pair applied to the resources created by the provider
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// ParseTags parses a list of tags in one of the supported formats:
+//
+//   - JSON: {"team":"platform","cost center":"42"}
+//   - key=value pairs separated by ';', where '\' escapes ';', '=' and '\'
+//   - the legacy "Name=XXX,Value=YYY Name=ZZZ,Value=WWW" format
+func ParseTags(raw string) ([]Tag, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+
+	var (
+		tags []Tag
+		err  error
+	)
+
+	switch {
+	case strings.HasPrefix(raw, "{"):
+		tags, err = parseJSONTags(raw)
+	case strings.HasPrefix(raw, "Name=") && strings.Contains(raw, ",Value="):
+		tags, err = parseLegacyTags(raw)
+	default:
+		tags, err = parseKeyValueTags(raw)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// ValidateTags checks AWS_INSTANCE_TAGS and AWS_DEFAULT_TAGS against the AWS
+// tag limits, AWS_DEFAULT_TAGS also against the characters IAM allows, as
+// they tag the IAM role and instance profile as well. With AWS_IMDS_TAGS the
+// keys have to be accepted by the instance metadata.
+func (o *Options) ValidateTags() error {
+	if err := validateTags(o.InstanceTags); err != nil {
+		return fmt.Errorf("parse %s: %w", AWS_INSTANCE_TAGS, err)
+	}
+	if err := validateTags(o.DefaultTags); err != nil {
+		return fmt.Errorf("parse %s: %w", AWS_DEFAULT_TAGS, err)
+	}
+	for _, tag := range o.DefaultTags {
+		if err := validateIAMTag(tag); err != nil {
+			return fmt.Errorf("parse %s: %w", AWS_DEFAULT_TAGS, err)
+		}
+	}
+	if len(MergeTags(o.DefaultTags, o.InstanceTags)) > maxUserTags {
+		return fmt.Errorf("%s and %s together exceed %d tags", AWS_INSTANCE_TAGS, AWS_DEFAULT_TAGS, maxUserTags)
+	}
+
+	if o.IMDSTags {
+		for _, tag := range MergeTags(o.DefaultTags, o.InstanceTags) {
+			if err := validateIMDSTagKey(tag.Key); err != nil {
+				return fmt.Errorf("%s is enabled, but %w", AWS_IMDS_TAGS, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateIMDSTagKey checks that a tag key can be exposed in the instance
+// metadata, EC2 refuses to launch instances with other keys
+func validateIMDSTagKey(key string) error {
+	switch {
+	case strings.ContainsAny(key, " /"):
+		return fmt.Errorf("tag key %q contains a space or /, which the instance metadata does not allow", key)
+	case key == "." || key == ".." || key == "_index":
+		return fmt.Errorf("tag key %q is not allowed in the instance metadata", key)
+	}
+
+	return nil
+}
+
+// validateIAMTag checks that IAM accepts the tag, it only allows letters,
+// numbers, spaces and _.:/=+-@
+func validateIAMTag(tag Tag) error {
+	if !iamTagRegex.MatchString(tag.Key) {
+		return fmt.Errorf("tag key %q contains characters IAM does not allow, only letters, numbers, spaces and _.:/=+-@ are allowed", tag.Key)
+	} else if !iamTagRegex.MatchString(tag.Value) {
+		return fmt.Errorf("value of tag %q contains characters IAM does not allow, only letters, numbers, spaces and _.:/=+-@ are allowed", tag.Key)
+	}
+
+	return nil
+}
+
+// MergeTags merges the given tag lists, later lists override the values of
+// earlier ones for the same key
+func MergeTags(lists ...[]Tag) []Tag {
+	result := []Tag{}
+	index := map[string]int{}
+
+	for _, list := range lists {
+		for _, tag := range list {
+			if i, ok := index[tag.Key]; ok {
+				result[i].Value = tag.Value
+				continue
+			}
+
+			index[tag.Key] = len(result)
+			result = append(result, tag)
+		}
+	}
+
+	return result
+}
+
+func parseJSONTags(raw string) ([]Tag, error) {
+	values := map[string]string{}
+	if err := json.Unmarshal([]byte(raw), &values); err != nil {
+		return nil, fmt.Errorf("parse tags as JSON object: %w", err)
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tags := []Tag{}
+	for _, key := range keys {
+		tags = append(tags, Tag{Key: key, Value: values[key]})
+	}
+
+	return tags, nil
+}
+
+func parseLegacyTags(raw string) ([]Tag, error) {
+	tags := []Tag{}
+	for _, field := range strings.Fields(raw) {
+		match := legacyTagRegex.FindStringSubmatch(field)
+		if match == nil {
+			return nil, fmt.Errorf("invalid tag %q, expected Name=XXX,Value=YYY", field)
+		}
+
+		tags = append(tags, Tag{Key: match[1], Value: match[2]})
+	}
+
+	return tags, nil
+}
+
+func parseKeyValueTags(raw string) ([]Tag, error) {
+	tags := []Tag{}
+
+	var (
+		key, current strings.Builder
+		hasKey       bool
+		escaped      bool
+	)
+
+	flush := func() error {
+		if !hasKey {
+			if strings.TrimSpace(current.String()) == "" {
+				current.Reset()
+				return nil
+			}
+
+			return fmt.Errorf("invalid tag %q, expected key=value", current.String())
+		}
+
+		tags = append(tags, Tag{
+			Key:   strings.TrimSpace(key.String()),
+			Value: strings.TrimSpace(current.String()),
+		})
+		key.Reset()
+		current.Reset()
+		hasKey = false
+
+		return nil
+	}
+
+	for _, r := range raw {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '=' && !hasKey:
+			key.WriteString(current.String())
+			current.Reset()
+			hasKey = true
+		case r == ';':
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+
+	if escaped {
+		return nil, fmt.Errorf("invalid tags %q, trailing escape character", raw)
+	}
+
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+func validateTags(tags []Tag) error {
+	if len(tags) > maxUserTags {
+		return fmt.Errorf("too many tags: %d, at most %d are allowed", len(tags), maxUserTags)
+	}
+
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		switch {
+		case tag.Key == "":
+			return fmt.Errorf("tag key must not be empty")
+		case utf8.RuneCountInString(tag.Key) > maxTagKeyLength:
+			return fmt.Errorf("tag key %q exceeds %d characters", tag.Key, maxTagKeyLength)
+		case utf8.RuneCountInString(tag.Value) > maxTagValueLength:
+			return fmt.Errorf("value of tag %q exceeds %d characters", tag.Key, maxTagValueLength)
+		case strings.HasPrefix(strings.ToLower(tag.Key), "aws:"):
+			return fmt.Errorf("tag key %q uses the reserved aws: prefix", tag.Key)
+		case tag.Key == "devpod" || strings.HasPrefix(tag.Key, "devpod:"):
+			return fmt.Errorf("tag key %q is reserved by the provider", tag.Key)
+		case seen[tag.Key]:
+			return fmt.Errorf("duplicate tag key %q", tag.Key)
+		}
+
+		seen[tag.Key] = true
+	}
+
+	return nil
+}
@@ -0,0 +1,297 @@
+package options
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected []Tag
+		err      string
+	}{
+		{
+			name: "empty",
+			raw:  "  ",
+		},
+		{
+			name: "JSON",
+			raw:  `{"team":"platform","cost center":"42"}`,
+			expected: []Tag{
+				{Key: "cost center", Value: "42"},
+				{Key: "team", Value: "platform"},
+			},
+		},
+		{
+			name: "invalid JSON",
+			raw:  `{"team":1}`,
+			err:  "parse tags as JSON object",
+		},
+		{
+			name: "key value pairs",
+			raw:  "team=platform; env = dev ;",
+			expected: []Tag{
+				{Key: "team", Value: "platform"},
+				{Key: "env", Value: "dev"},
+			},
+		},
+		{
+			name: "escaped separators",
+			raw:  `query=a\=b\;c;path=C:\\tmp;formula=x=y`,
+			expected: []Tag{
+				{Key: "query", Value: "a=b;c"},
+				{Key: "path", Value: `C:\tmp`},
+				{Key: "formula", Value: "x=y"},
+			},
+		},
+		{
+			name: "empty value",
+			raw:  "team=",
+			expected: []Tag{
+				{Key: "team", Value: ""},
+			},
+		},
+		{
+			name: "missing value",
+			raw:  "team=platform;env",
+			err:  `invalid tag "env", expected key=value`,
+		},
+		{
+			name: "trailing escape",
+			raw:  `team=platform\`,
+			err:  "trailing escape character",
+		},
+		{
+			name: "legacy form",
+			raw:  "Name=team,Value=platform Name=cost!center,Value=(42)",
+			expected: []Tag{
+				{Key: "team", Value: "platform"},
+				{Key: "cost!center", Value: "(42)"},
+			},
+		},
+		{
+			name: "invalid legacy form",
+			raw:  "Name=team,Value=platform env",
+			err:  `invalid tag "env", expected Name=XXX,Value=YYY`,
+		},
+		{
+			// accepted before tags were validated, so existing machines keep working
+			name: "special characters",
+			raw:  "Name=a!#$%&'(),Value=*+-./:;<>?@[]^_{|}~",
+			expected: []Tag{
+				{Key: "a!#$%&'()", Value: "*+-./:;<>?@[]^_{|}~"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tags, err := ParseTags(test.raw)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(tags) == 0 && len(test.expected) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(tags, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, tags)
+			}
+		})
+	}
+}
+
+func TestValidateTags(t *testing.T) {
+	manyTags := func(prefix string, count int) []Tag {
+		tags := []Tag{}
+		for i := 0; i < count; i++ {
+			tags = append(tags, Tag{Key: fmt.Sprintf("%s%d", prefix, i), Value: "x"})
+		}
+
+		return tags
+	}
+
+	tests := []struct {
+		name     string
+		instance []Tag
+		defaults []Tag
+		imdsTags bool
+		err      string
+	}{
+		{
+			name:     "valid",
+			instance: []Tag{{Key: "team", Value: "platform"}},
+			defaults: []Tag{{Key: "team", Value: "infra"}, {Key: "cost center", Value: "42"}},
+		},
+		{
+			name:     "duplicate key",
+			instance: []Tag{{Key: "team", Value: "a"}, {Key: "team", Value: "b"}},
+			err:      `parse AWS_INSTANCE_TAGS: duplicate tag key "team"`,
+		},
+		{
+			name:     "empty key",
+			defaults: []Tag{{Key: "", Value: "a"}},
+			err:      "parse AWS_DEFAULT_TAGS: tag key must not be empty",
+		},
+		{
+			name:     "reserved aws prefix",
+			instance: []Tag{{Key: "AWS:team", Value: "a"}},
+			err:      "reserved aws: prefix",
+		},
+		{
+			name:     "reserved devpod key",
+			instance: []Tag{{Key: "devpod:owner", Value: "a"}},
+			err:      "reserved by the provider",
+		},
+		{
+			name:     "key too long",
+			instance: []Tag{{Key: strings.Repeat("k", maxTagKeyLength+1), Value: "a"}},
+			err:      "exceeds 128 characters",
+		},
+		{
+			name:     "value too long",
+			instance: []Tag{{Key: "team", Value: strings.Repeat("ü", maxTagValueLength+1)}},
+			err:      "exceeds 256 characters",
+		},
+		{
+			name:     "longest value",
+			instance: []Tag{{Key: "team", Value: strings.Repeat("ü", maxTagValueLength)}},
+		},
+		{
+			name:     "too many tags",
+			instance: manyTags("tag", maxUserTags+1),
+			err:      "too many tags: 41, at most 40 are allowed",
+		},
+		{
+			name:     "most tags",
+			instance: manyTags("tag", maxUserTags),
+		},
+		{
+			name:     "too many merged tags",
+			instance: manyTags("instance", 30),
+			defaults: manyTags("default", 11),
+			err:      "AWS_INSTANCE_TAGS and AWS_DEFAULT_TAGS together exceed 40 tags",
+		},
+		{
+			name:     "overridden default tags",
+			instance: manyTags("tag", 30),
+			defaults: manyTags("tag", 30),
+		},
+		{
+			name:     "metadata tags",
+			instance: []Tag{{Key: "team:name", Value: "a b/c"}},
+			imdsTags: true,
+		},
+		{
+			name:     "metadata tag with space",
+			defaults: []Tag{{Key: "cost center", Value: "42"}},
+			imdsTags: true,
+			err:      `AWS_IMDS_TAGS is enabled, but tag key "cost center" contains a space or /`,
+		},
+		{
+			name:     "metadata tag with slash",
+			instance: []Tag{{Key: "team/name", Value: "a"}},
+			imdsTags: true,
+			err:      `tag key "team/name" contains a space or /`,
+		},
+		{
+			name:     "reserved metadata tag",
+			instance: []Tag{{Key: "_index", Value: "a"}},
+			imdsTags: true,
+			err:      `tag key "_index" is not allowed in the instance metadata`,
+		},
+		{
+			name:     "space without metadata tags",
+			instance: []Tag{{Key: "cost center", Value: "42"}},
+		},
+		{
+			name:     "instance tag IAM does not allow",
+			instance: []Tag{{Key: "team", Value: "a&b"}},
+		},
+		{
+			name:     "default tag key IAM does not allow",
+			defaults: []Tag{{Key: "team#1", Value: "a"}},
+			err:      `parse AWS_DEFAULT_TAGS: tag key "team#1" contains characters IAM does not allow`,
+		},
+		{
+			name:     "default tag value IAM does not allow",
+			defaults: []Tag{{Key: "team", Value: "a&b"}},
+			err:      `parse AWS_DEFAULT_TAGS: value of tag "team" contains characters IAM does not allow`,
+		},
+		{
+			name:     "default tag IAM allows",
+			defaults: []Tag{{Key: "cost-center_1", Value: "é 42 a.b:c/d=e+f@g"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := &Options{InstanceTags: test.instance, DefaultTags: test.defaults, IMDSTags: test.imdsTags}
+
+			err := options.ValidateTags()
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestFromEnvValidatesTags(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		err  string
+	}{
+		{
+			name: "valid",
+			env:  map[string]string{AWS_INSTANCE_TAGS: "team=platform", AWS_DEFAULT_TAGS: "cost center=42"},
+		},
+		{
+			name: "invalid format",
+			env:  map[string]string{AWS_INSTANCE_TAGS: "team=platform;env"},
+			err:  "parse AWS_INSTANCE_TAGS",
+		},
+		{
+			name: "reserved key",
+			env:  map[string]string{AWS_DEFAULT_TAGS: "devpod:owner=me"},
+			err:  "parse AWS_DEFAULT_TAGS",
+		},
+		{
+			name: "metadata tag key",
+			env:  map[string]string{AWS_INSTANCE_TAGS: "team/name=a", AWS_IMDS_TAGS: "true"},
+			err:  "AWS_IMDS_TAGS is enabled",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv(AWS_INSTANCE_TYPE, "c5.xlarge")
+			t.Setenv(AWS_DISK_SIZE, "40")
+			for _, name := range []string{AWS_INSTANCE_TAGS, AWS_DEFAULT_TAGS, AWS_IMDS_TAGS} {
+				t.Setenv(name, test.env[name])
+			}
+
+			_, err := FromEnv(true)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
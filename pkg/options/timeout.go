@@ -0,0 +1,21 @@
+package options
+
+import (
+	"fmt"
+	"time"
+)
+
+// ParseTimeout parses the maximum duration of a provider command, e.g. "10m".
+// It defaults to 0, which means no timeout.
+func ParseTimeout(raw string) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+
+	timeout, err := time.ParseDuration(raw)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid timeout %q, expected e.g. 10m or 0 for none", raw)
+	}
+
+	return timeout, nil
+}
@@ -0,0 +1,39 @@
+package options
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeout(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected time.Duration
+		err      bool
+	}{
+		{raw: "", expected: 0},
+		{raw: "0", expected: 0},
+		{raw: "10m", expected: 10 * time.Minute},
+		{raw: "1h30m", expected: 90 * time.Minute},
+		{raw: "-1m", err: true},
+		{raw: "10", err: true},
+		{raw: "ten minutes", err: true},
+	}
+
+	for _, test := range tests {
+		timeout, err := ParseTimeout(test.raw)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.raw)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.raw, err)
+			continue
+		}
+
+		if timeout != test.expected {
+			t.Errorf("%q: expected %s, got %s", test.raw, test.expected, timeout)
+		}
+	}
+}
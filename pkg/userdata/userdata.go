@@ -0,0 +1,301 @@
+// Package userdata renders the cloud-init user data of devpod instances as a
+// MIME multipart archive, so user supplied cloud-config and shell parts can
+// be added to the parts of the provider.
+package userdata
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// Content types of the parts cloud-init understands
+const (
+	ContentTypeCloudConfig = "text/cloud-config"
+	ContentTypeShellScript = "text/x-shellscript"
+)
+
+// MaxSize is the maximum size of the user data of an instance
+const MaxSize = 16 * 1024
+
+// ECRCredentialHelperVersion is the release of amazon-ecr-credential-helper
+// installed if the image has no package for it
+const ECRCredentialHelperVersion = "0.9.0"
+
+// InstanceStoreMountPath is where the NVMe instance store is mounted
+const InstanceStoreMountPath = "/mnt/instance-store"
+
+// The credentials of the instance role are served at the address ECS serves
+// the credentials of tasks at, as the AWS SDKs resolve
+// AWS_CONTAINER_CREDENTIALS_RELATIVE_URI against it. The path ends with the
+// credentials token.
+const (
+	CredentialsAddress = "169.254.170.2"
+	CredentialsPath    = "/devpod/credentials"
+)
+
+// boundary separates the parts, it is fixed so the rendered user data is
+// reproducible
+const boundary = "==DEVPOD-USER-DATA=="
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+var templates = template.Must(template.ParseFS(templateFS, "templates/*.tmpl"))
+
+var credentialsTokenRegexp = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+
+// Config holds the values the user data is rendered with
+type Config struct {
+	// User is created with passwordless sudo
+	User string
+	// PublicKey is authorized to log in as User
+	PublicKey string
+	// Docker is installed and configured if set
+	Docker *Docker
+	// InstanceStore is mounted at InstanceStoreMountPath on every boot if set
+	InstanceStore *InstanceStore
+	// Credentials of the instance role are served at CredentialsAddress
+	// if set
+	Credentials *Credentials
+	// Extra parts are appended after the parts of the provider
+	Extra []Part
+}
+
+// Docker configures the container runtime of the instance
+type Docker struct {
+	// RegistryMirrors are used to pull images from docker hub
+	RegistryMirrors []string
+	// DataRoot is the directory docker stores its images and containers in
+	DataRoot string
+}
+
+// InstanceStore configures what the NVMe instance store is used for
+type InstanceStore struct {
+	// Docker stores its images and containers on the instance store,
+	// requires Config.Docker
+	Docker bool
+	// Tmp bind mounts a directory of the instance store at /tmp
+	Tmp bool
+}
+
+// Credentials configures forwarding the credentials of the instance role
+// into the devcontainer
+type Credentials struct {
+	// Token is the secret last element of the credentials path, it may
+	// only contain letters and digits
+	Token string
+}
+
+// daemonConfig returns the /etc/docker/daemon.json for the settings that
+// differ from the defaults of docker, or an empty string if there are none
+func (d *Docker) daemonConfig() (string, error) {
+	config := map[string]interface{}{}
+	if len(d.RegistryMirrors) > 0 {
+		config["registry-mirrors"] = d.RegistryMirrors
+	}
+	if d.DataRoot != "" {
+		config["data-root"] = d.DataRoot
+	}
+	if len(config) == 0 {
+		return "", nil
+	}
+
+	out, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+// Part is a part of the user data
+type Part struct {
+	Filename    string
+	ContentType string
+	Content     string
+}
+
+// NewPart returns a part with the content type derived from its first line,
+// which has to be #cloud-config or a #! shebang
+func NewPart(filename, content string) (Part, error) {
+	part := Part{Filename: filename, Content: content}
+
+	switch {
+	case strings.HasPrefix(content, "#cloud-config"):
+		part.ContentType = ContentTypeCloudConfig
+	case strings.HasPrefix(content, "#!"):
+		part.ContentType = ContentTypeShellScript
+	default:
+		return Part{}, fmt.Errorf("%s has to start with #cloud-config or a #! shebang", filename)
+	}
+
+	return part, nil
+}
+
+// Render returns the user data for the config
+func Render(config Config) (string, error) {
+	if config.User == "" {
+		return "", fmt.Errorf("user is missing")
+	}
+
+	if config.PublicKey == "" || strings.ContainsAny(config.PublicKey, "'\n") {
+		return "", fmt.Errorf("invalid public key")
+	}
+
+	// cloud-init runs the shell scripts in the order of their filenames
+	script := &bytes.Buffer{}
+	err := templates.ExecuteTemplate(script, "devpod-user.sh.tmpl", config)
+	if err != nil {
+		return "", err
+	}
+
+	parts := []Part{
+		{
+			Filename:    "10-devpod-user.sh",
+			ContentType: ContentTypeShellScript,
+			Content:     script.String(),
+		},
+	}
+
+	if config.InstanceStore != nil {
+		part, err := renderInstanceStore(config.InstanceStore)
+		if err != nil {
+			return "", err
+		}
+
+		parts = append(parts, part)
+	}
+
+	if config.Docker != nil {
+		docker := *config.Docker
+		if config.InstanceStore != nil && config.InstanceStore.Docker {
+			if docker.DataRoot != "" {
+				return "", fmt.Errorf("docker data root %s conflicts with the instance store", docker.DataRoot)
+			}
+
+			docker.DataRoot = InstanceStoreMountPath + "/docker"
+		}
+
+		part, err := renderDocker(config.User, &docker)
+		if err != nil {
+			return "", err
+		}
+
+		parts = append(parts, part)
+	}
+
+	if config.InstanceStore != nil && config.InstanceStore.Docker && config.Docker == nil {
+		return "", fmt.Errorf("docker on the instance store requires docker to be bootstrapped")
+	}
+
+	if config.Credentials != nil {
+		part, err := renderCredentials(config.Credentials)
+		if err != nil {
+			return "", err
+		}
+
+		parts = append(parts, part)
+	}
+
+	parts = append(parts, config.Extra...)
+
+	out := &bytes.Buffer{}
+	fmt.Fprintf(out, "Content-Type: multipart/mixed; boundary=\"%s\"\n", boundary)
+	fmt.Fprint(out, "MIME-Version: 1.0\n")
+
+	for _, part := range parts {
+		if strings.Contains(part.Content, boundary) {
+			return "", fmt.Errorf("%s must not contain %s", part.Filename, boundary)
+		}
+
+		fmt.Fprintf(out, "\n--%s\n", boundary)
+		fmt.Fprintf(out, "Content-Type: %s; charset=\"utf-8\"\n", part.ContentType)
+		fmt.Fprint(out, "MIME-Version: 1.0\n")
+		fmt.Fprint(out, "Content-Transfer-Encoding: 7bit\n")
+		fmt.Fprintf(out, "Content-Disposition: attachment; filename=\"%s\"\n\n", part.Filename)
+		fmt.Fprint(out, part.Content)
+		if !strings.HasSuffix(part.Content, "\n") {
+			fmt.Fprint(out, "\n")
+		}
+	}
+	fmt.Fprintf(out, "\n--%s--\n", boundary)
+
+	if out.Len() > MaxSize {
+		return "", fmt.Errorf("user data has %d bytes, at most %d are allowed", out.Len(), MaxSize)
+	}
+
+	return out.String(), nil
+}
+
+func renderDocker(user string, docker *Docker) (Part, error) {
+	if strings.ContainsAny(docker.DataRoot, "'\n") {
+		return Part{}, fmt.Errorf("invalid docker data root %s", docker.DataRoot)
+	}
+
+	daemonConfig, err := docker.daemonConfig()
+	if err != nil {
+		return Part{}, err
+	}
+
+	script := &bytes.Buffer{}
+	err = templates.ExecuteTemplate(script, "devpod-docker.sh.tmpl", map[string]string{
+		"User":                       user,
+		"DataRoot":                   docker.DataRoot,
+		"DaemonConfig":               daemonConfig,
+		"ECRCredentialHelperVersion": ECRCredentialHelperVersion,
+	})
+	if err != nil {
+		return Part{}, err
+	}
+
+	return Part{
+		Filename:    "20-devpod-docker.sh",
+		ContentType: ContentTypeShellScript,
+		Content:     script.String(),
+	}, nil
+}
+
+func renderInstanceStore(instanceStore *InstanceStore) (Part, error) {
+	script := &bytes.Buffer{}
+	err := templates.ExecuteTemplate(script, "devpod-instance-store.sh.tmpl", map[string]interface{}{
+		"MountPath": InstanceStoreMountPath,
+		"Docker":    instanceStore.Docker,
+		"Tmp":       instanceStore.Tmp,
+	})
+	if err != nil {
+		return Part{}, err
+	}
+
+	return Part{
+		Filename:    "15-devpod-instance-store.sh",
+		ContentType: ContentTypeShellScript,
+		Content:     script.String(),
+	}, nil
+}
+
+func renderCredentials(credentials *Credentials) (Part, error) {
+	if !credentialsTokenRegexp.MatchString(credentials.Token) {
+		return Part{}, fmt.Errorf("invalid credentials token")
+	}
+
+	script := &bytes.Buffer{}
+	err := templates.ExecuteTemplate(script, "devpod-credentials.sh.tmpl", map[string]string{
+		"Address": CredentialsAddress,
+		"Path":    CredentialsPath,
+		"Token":   credentials.Token,
+	})
+	if err != nil {
+		return Part{}, err
+	}
+
+	return Part{
+		Filename:    "30-devpod-credentials.sh",
+		ContentType: ContentTypeShellScript,
+		Content:     script.String(),
+	}, nil
+}
@@ -0,0 +1,169 @@
+package userdata
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+const testPublicKey = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQC devpod"
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name          string
+		extra         map[string]string
+		docker        *Docker
+		instanceStore *InstanceStore
+		credentials   *Credentials
+		golden        string
+		err           string
+	}{
+		{
+			name:   "default",
+			golden: "default.golden",
+		},
+		{
+			name: "extra parts",
+			extra: map[string]string{
+				"packages.yaml": "#cloud-config\npackages:\n  - htop\n",
+			},
+			golden: "extra.golden",
+		},
+		{
+			name: "extra shell script",
+			extra: map[string]string{
+				"setup.sh": "#!/bin/bash\necho hello",
+			},
+			golden: "extra-shell.golden",
+		},
+		{
+			name:   "docker",
+			docker: &Docker{},
+			golden: "docker.golden",
+		},
+		{
+			name: "docker with mirrors and data root",
+			docker: &Docker{
+				RegistryMirrors: []string{"https://mirror.gcr.io"},
+				DataRoot:        "/mnt/docker",
+			},
+			golden: "docker-daemon.golden",
+		},
+		{
+			name:          "instance store",
+			instanceStore: &InstanceStore{},
+			golden:        "instance-store.golden",
+		},
+		{
+			name:          "instance store for docker and tmp",
+			docker:        &Docker{RegistryMirrors: []string{"https://mirror.gcr.io"}},
+			instanceStore: &InstanceStore{Docker: true, Tmp: true},
+			golden:        "instance-store-docker.golden",
+		},
+		{
+			name:          "instance store for docker without docker",
+			instanceStore: &InstanceStore{Docker: true},
+			err:           "docker on the instance store requires docker",
+		},
+		{
+			name:          "instance store with docker data root",
+			docker:        &Docker{DataRoot: "/data/docker"},
+			instanceStore: &InstanceStore{Docker: true},
+			err:           "docker data root /data/docker conflicts with the instance store",
+		},
+		{
+			name:   "invalid docker data root",
+			docker: &Docker{DataRoot: "/mnt/'docker"},
+			err:    "invalid docker data root",
+		},
+		{
+			name:        "credentials",
+			credentials: &Credentials{Token: "0123456789abcdef"},
+			golden:      "credentials.golden",
+		},
+		{
+			name:        "invalid credentials token",
+			credentials: &Credentials{Token: "token'"},
+			err:         "invalid credentials token",
+		},
+		{
+			name: "unknown part",
+			extra: map[string]string{
+				"notes.txt": "hello",
+			},
+			err: "notes.txt has to start with #cloud-config or a #! shebang",
+		},
+		{
+			name: "too large",
+			extra: map[string]string{
+				"large.sh": "#!/bin/sh\n" + string(make([]byte, MaxSize)),
+			},
+			err: "user data has",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := Config{
+				User:          "devpod",
+				PublicKey:     testPublicKey,
+				Docker:        test.docker,
+				InstanceStore: test.instanceStore,
+				Credentials:   test.credentials,
+			}
+
+			var err error
+			for filename, content := range test.extra {
+				var part Part
+				part, err = NewPart(filename, content)
+				if err != nil {
+					break
+				}
+
+				config.Extra = append(config.Extra, part)
+			}
+
+			var rendered string
+			if err == nil {
+				rendered, err = Render(config)
+			}
+
+			if test.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			path := filepath.Join("testdata", test.golden)
+			if *update {
+				err := os.WriteFile(path, []byte(rendered), 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			expected, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read golden file, run with -update to create it: %v", err)
+			}
+
+			if rendered != string(expected) {
+				t.Errorf("rendered user data differs from %s, run with -update to accept it:\n%s", path, rendered)
+			}
+		})
+	}
+}
+
+func TestRenderInvalidPublicKey(t *testing.T) {
+	_, err := Render(Config{User: "devpod", PublicKey: "ssh-rsa AAAA'; rm -rf /"})
+	if err == nil {
+		t.Fatal("expected an error for a public key with quotes")
+	}
+}
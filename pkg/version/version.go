@@ -0,0 +1,5 @@
+package version
+
+// Version is the provider version, it is set at build time through
+// -ldflags "-X github.com/loft-sh/devpod-provider-aws/pkg/version.Version=..."
+var Version = "dev"